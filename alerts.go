@@ -0,0 +1,152 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+const (
+	AlertPanicHeader = "Ошибка в команде %s"
+	AlertErrorHeader = "Ошибка: %s"
+	AlertFingerprint = "Отпечаток: %s"
+	AlertRepeated    = "Повторялась ещё %d раз с прошлого сообщения."
+)
+
+const (
+	alert_interval   = 10 * time.Minute // one alert per fingerprint at most this often
+	max_alert_frames = 8
+	max_alert_text   = 500 // characters of the error, the message limit is 4096
+)
+
+type alertState struct {
+	sent       time.Time
+	suppressed int
+}
+
+var digits_re = regexp.MustCompile(`[0-9]+`)
+
+// own_package prefixes the functions of this program in stack traces, "main."
+// unless built some other way.
+var own_package = strings.TrimSuffix(runtime.FuncForPC(reflect.ValueOf(fingerprint).Pointer()).Name(), "fingerprint")
+
+// compactStack keeps the frames of this program below the panic from a
+// debug.Stack() trace, as "function file:line".
+func compactStack(stack string) []string {
+	lines := strings.Split(stack, "\n")
+	// frames above the panic are the recover handler itself
+	for i, line := range lines {
+		if strings.HasPrefix(line, "panic(") {
+			lines = lines[i+2:]
+			break
+		}
+	}
+	var frames []string
+	for i := 0; i+1 < len(lines) && len(frames) < max_alert_frames; i += 2 {
+		function, location := lines[i], strings.TrimSpace(lines[i+1])
+		if !strings.HasPrefix(function, own_package) {
+			continue
+		}
+		if cut := strings.LastIndex(function, "("); cut > 0 {
+			function = function[:cut]
+		}
+		location, _, _ = strings.Cut(location, " ")
+		frames = append(frames, function+" "+filepath.Base(location))
+	}
+	return frames
+}
+
+// fingerprint groups recurring errors: the error with its numbers masked,
+// like ids and offsets, and the functions it went through.
+func fingerprint(text string, frames []string) string {
+	h := sha256.New()
+	h.Write([]byte(digits_re.ReplaceAllString(text, "N")))
+	for _, frame := range frames {
+		function, _, _ := strings.Cut(frame, " ")
+		h.Write([]byte("\n" + function))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:12]
+}
+
+// redactedUpdate is what the alert shows of the message that failed: where
+// it came from and the command, without names or the arguments.
+func redactedUpdate(message JsonTable) string {
+	command, _, _ := strings.Cut(getStr(message, "text"), " ")
+	return toJson(JsonTable{
+		"chat_id":    getChatId(message),
+		"chat_type":  getStr(getTbl(message, "chat"), "type"),
+		"thread_id":  getThreadId(message),
+		"message_id": getNum(message, "message_id"),
+		"command":    command,
+	})
+}
+
+// throttleAlert reports whether an alert with the fingerprint is due, and
+// how many were held back since the last one.
+func (bot *Bot) throttleAlert(key string, now time.Time) (bool, int) {
+	bot.alerts_mux.Lock()
+	defer bot.alerts_mux.Unlock()
+	state, ok := bot.alerts[key]
+	if !ok {
+		state = &alertState{}
+		bot.alerts[key] = state
+	}
+	if now.Sub(state.sent) < alert_interval {
+		state.suppressed++
+		return false, 0
+	}
+	suppressed := state.suppressed
+	state.sent, state.suppressed = now, 0
+	return true, suppressed
+}
+
+func (bot *Bot) sendAlert(header string, text string, key string, details []string) {
+	if bot.alert_chat == "" {
+		return
+	}
+	due, suppressed := bot.throttleAlert(key, time.Now())
+	if !due {
+		return
+	}
+	if runes := []rune(text); len(runes) > max_alert_text {
+		text = string(runes[:max_alert_text]) + "…"
+	}
+	b := &TextBuilder{}
+	b.Bold(header).Line("")
+	b.Line(text)
+	b.Line(fmt.Sprintf(AlertFingerprint, key))
+	if suppressed > 0 {
+		b.Line(fmt.Sprintf(AlertRepeated, suppressed))
+	}
+	if len(details) > 0 {
+		b.Pre(strings.Join(details, "\n"), "")
+	}
+	go func() {
+		if _, err := bot.sendFormattedPrivateMessage(bot.alert_chat, b); err != nil {
+			bot.log.Warn("failed to send alert", "fingerprint", key, "error", err)
+		}
+	}()
+}
+
+// alertPanic reports a command that panicked with the place it panicked
+// and the update it was handling.
+func (bot *Bot) alertPanic(message JsonTable, r any, stack string) {
+	frames := compactStack(stack)
+	text := fmt.Sprint(r)
+	key := fingerprint(text, frames)
+	command, _, _ := strings.Cut(getStr(message, "text"), " ")
+	details := append(frames, "", redactedUpdate(message))
+	bot.sendAlert(fmt.Sprintf(AlertPanicHeader, command), text, key, details)
+}
+
+// alertError reports a failure outside of commands, like saving the state.
+func (bot *Bot) alertError(what string, err error) {
+	text := err.Error()
+	bot.sendAlert(fmt.Sprintf(AlertErrorHeader, what), text, fingerprint(what+": "+text, nil), nil)
+}
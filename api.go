@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ApiEvent is the public view of an event. User ids, licenses and the audit
+// log stay private.
+type ApiEvent struct {
+	EventId     int         `json:"event_id"`
+	Label       string      `json:"label"` // as shown in the chat
+	ChatId      json.Number `json:"chat_id"`
+	ThreadId    json.Number `json:"thread_id,omitempty"`
+	Description string      `json:"description"`
+	StartTime   time.Time   `json:"start_time"`
+	Location    string      `json:"location"`
+	Capacity    int         `json:"capacity"`
+	ClosedAt    time.Time   `json:"closed_at,omitzero"`
+
+	Registrations []ApiMember `json:"registrations"`
+	Waitlist      []ApiMember `json:"waitlist"`
+}
+
+type ApiMember struct {
+	Seq   int    `json:"seq"`
+	Name  string `json:"name"`
+	Slots int    `json:"slots"`
+}
+
+func apiMembers(list []MemberRecord) []ApiMember {
+	members := make([]ApiMember, 0, len(list))
+	for _, m := range list {
+		members = append(members, ApiMember{m.Seq, m.Name, m.slots()})
+	}
+	return members
+}
+
+func apiEvent(event *EventInfo) ApiEvent {
+	return ApiEvent{
+		EventId:       event.EventId,
+		Label:         event.ref(),
+		ChatId:        event.ChatId,
+		ThreadId:      event.ThreadId,
+		Description:   event.Description,
+		StartTime:     event.StartTime,
+		Location:      event.Location,
+		Capacity:      event.Capacity,
+		ClosedAt:      event.ClosedAt,
+		Registrations: apiMembers(event.Registrations),
+		Waitlist:      apiMembers(event.Waitlist),
+	}
+}
+
+// requireApiToken checks the "Authorization: Bearer <token>" header.
+func (bot *Bot) requireApiToken(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(bot.api_token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// loadApiState reads the state through the store rather than from memory,
+// so a standby instance serves the same data as the leader.
+func (bot *Bot) loadApiState(w http.ResponseWriter) (*BotState, bool) {
+	state, err := bot.store.Load()
+	if err != nil {
+		bot.log.Error("api: failed to load state", "error", err)
+		http.Error(w, "failed to load state", http.StatusInternalServerError)
+		return nil, false
+	}
+	return state, true
+}
+
+func writeJson(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// apiChatEvent serves the active event of a chat, or of a topic with
+// ?thread_id=.
+func (bot *Bot) apiChatEvent(w http.ResponseWriter, r *http.Request) {
+	state, ok := bot.loadApiState(w)
+	if !ok {
+		return
+	}
+	key := EventKey{json.Number(r.PathValue("id")), json.Number(r.URL.Query().Get("thread_id"))}
+	event, ok := state.Events[key]
+	if !ok {
+		http.Error(w, "no active event", http.StatusNotFound)
+		return
+	}
+	writeJson(w, apiEvent(event))
+}
+
+// apiChatHistory serves the closed events of a chat, oldest first.
+func (bot *Bot) apiChatHistory(w http.ResponseWriter, r *http.Request) {
+	state, ok := bot.loadApiState(w)
+	if !ok {
+		return
+	}
+	events := []ApiEvent{}
+	for _, event := range state.History[json.Number(r.PathValue("id"))] {
+		events = append(events, apiEvent(event))
+	}
+	writeJson(w, events)
+}
+
+func (bot *Bot) registerApi(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/chats/{id}/event", bot.requireApiToken(bot.apiChatEvent))
+	mux.HandleFunc("GET /api/chats/{id}/history", bot.requireApiToken(bot.apiChatHistory))
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// parseCommand splits a message text into the command token and its
+// arguments. Runs of whitespace separate arguments, and double or single
+// quotes group words into one argument: `/set title "Summer cup"` gives
+// "/set", ["title", "Summer cup"]. An unterminated quote runs to the end.
+func parseCommand(text string) (string, []string) {
+	var tokens []string
+	var current strings.Builder
+	in_token := false
+	var quote rune
+
+	for _, r := range text {
+		switch {
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			in_token = true
+		case unicode.IsSpace(r):
+			if in_token {
+				tokens = append(tokens, current.String())
+				current.Reset()
+				in_token = false
+			}
+		default:
+			current.WriteRune(r)
+			in_token = true
+		}
+	}
+	if in_token {
+		tokens = append(tokens, current.String())
+	}
+
+	if len(tokens) == 0 {
+		return "", nil
+	}
+	args := tokens[1:]
+	for i := range args {
+		args[i] = strings.TrimSpace(args[i])
+	}
+	return tokens[0], args
+}
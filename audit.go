@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	audit_created      = "created"
+	audit_registered   = "registered"
+	audit_waitlisted   = "waitlisted"
+	audit_unregistered = "unregistered"
+	audit_removed      = "removed"
+	audit_promoted     = "promoted"
+	audit_confirmed    = "confirmed"
+	audit_expired      = "expired"
+	audit_edited       = "edited"
+	audit_bumped       = "bumped"
+	audit_imported     = "imported"
+	audit_merged       = "merged"
+	audit_closed       = "closed"
+	audit_not_admitted = "not_admitted"
+	audit_restored     = "restored"
+	audit_capacity     = "capacity"
+	audit_demoted      = "demoted"
+	audit_paid         = "paid"
+	audit_locked       = "locked"
+)
+
+const (
+	LogUsage    = "Использование: /log <номер события>"
+	LogNotFound = "В этом канале нет события #%s."
+	LogHeader   = "Журнал события #%s (%d):"
+	LogLine     = "%s %s — %s"
+	LogBotActor = "бот"
+)
+
+var audit_labels = map[string]string{
+	audit_created:      "создано",
+	audit_registered:   "регистрация",
+	audit_waitlisted:   "лист ожидания",
+	audit_unregistered: "отмена регистрации",
+	audit_removed:      "удалён",
+	audit_promoted:     "переведён из листа ожидания",
+	audit_confirmed:    "подтвердил участие",
+	audit_expired:      "не подтвердил участие",
+	audit_edited:       "изменено",
+	audit_bumped:       "приоритет в листе ожидания",
+	audit_imported:     "импорт участников",
+	audit_merged:       "объединение",
+	audit_closed:       "закрыто",
+	audit_not_admitted: "лист ожидания очищен к началу",
+	audit_restored:     "регистрация возвращена",
+	audit_capacity:     "число мест",
+	audit_demoted:      "переведён в лист ожидания",
+	audit_paid:         "оплата",
+	audit_locked:       "регистрация закрыта",
+}
+
+// AuditEntry is one line of the event's log. Actor is empty for actions the
+// bot takes itself, like promotions and scheduled events.
+type AuditEntry struct {
+	Time    time.Time
+	Action  string
+	ActorId json.Number `json:",omitempty"`
+	Actor   string      `json:",omitempty"`
+	Details string      `json:",omitempty"`
+}
+
+// audit appends to the event log. message is the command that caused the
+// action, nil for the bot's own actions. Must be called with state_mux held.
+func (e *EventInfo) audit(message JsonTable, action string, details string) {
+	entry := AuditEntry{Time: time.Now(), Action: action, Details: details}
+	if message != nil {
+		entry.ActorId = getSenderId(message)
+		entry.Actor = userDisplayName(getTbl(message, "from"))
+	}
+	e.Log = append(e.Log, entry)
+}
+
+// renderLog lists the log of the event given as "<chat_id>:<event ref>".
+func (bot *Bot) renderLog(user_id json.Number, arg string) (string, []string) {
+	chat, ref, _ := strings.Cut(arg, ":")
+
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	event := bot.findEventRef(json.Number(chat), ref)
+	if event == nil {
+		return fmt.Sprintf(LogNotFound, escapeMarkdown(ref)), nil
+	}
+
+	var lines []string
+	for _, entry := range event.Log {
+		actor := LogBotActor
+		if entry.Actor != "" {
+			actor = fmt.Sprintf("%s (`%s`)", escapeMarkdown(entry.Actor), entry.ActorId)
+		}
+		line := fmt.Sprintf(LogLine, entry.Time.Format(event_time_layout), actor, audit_labels[entry.Action])
+		if entry.Details != "" {
+			line += ": " + escapeMarkdown(entry.Details)
+		}
+		lines = append(lines, line)
+	}
+	return fmt.Sprintf(LogHeader, event.ref(), len(lines)), lines
+}
+
+// eventLog sends the log of an active or closed event to the admin privately.
+func (bot *Bot) eventLog(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	user_id := getSenderId(message)
+
+	if len(args) != 1 {
+		bot.sendPrivateMessage(user_id, LogUsage, false)
+		return
+	}
+	bot.sendPaged(user_id, user_id, "log", fmt.Sprintf("%s:%s", chat_id, strings.TrimPrefix(args[0], "#")))
+}
+
+// logChat is the chat of a "log" listing, whose arg is "<chat>:<event>".
+func logChat(arg string) json.Number {
+	chat, _, _ := strings.Cut(arg, ":")
+	return json.Number(chat)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type messageRef struct {
+	chat_id    json.Number
+	message_id json.Number
+}
+
+// runTransient runs a command with its own context, see commandContext. Unless the command keeps its output, the
+// command message and every reply the bot sends to it are deleted after
+// auto_delete_delay.
+func (bot *Bot) runTransient(message JsonTable, args []string, command Command) {
+	handler := command.build(bot)
+	ctx, done := bot.commandContext(message)
+	defer done()
+	ctx = withCommand(ctx, command)
+	if bot.auto_delete_delay == 0 || command.KeepOutput || isPrivateChat(message) {
+		handler(ctx, message, args)
+		return
+	}
+
+	ref := messageRef{getChatId(message), getNum(message, "message_id")}
+	bot.transient_messages_mux.Lock()
+	bot.transient_messages[ref] = true
+	bot.transient_messages_mux.Unlock()
+
+	handler(ctx, message, args)
+
+	bot.transient_messages_mux.Lock()
+	delete(bot.transient_messages, ref)
+	bot.transient_messages_mux.Unlock()
+	bot.deleteLater(ref)
+}
+
+// trackReply schedules deletion of a reply sent to a transient command.
+func (bot *Bot) trackReply(chat_id interface{}, reply_to json.Number, reply JsonAny) {
+	chat := json.Number(fmt.Sprint(chat_id))
+	bot.transient_messages_mux.Lock()
+	transient := bot.transient_messages[messageRef{chat, reply_to}]
+	bot.transient_messages_mux.Unlock()
+	if transient {
+		reply_tbl, _ := reply.(JsonTable)
+		bot.deleteLater(messageRef{chat, getNum(reply_tbl, "message_id")})
+	}
+}
+
+// Lifetime of replies that are always cleaned up, like denials posted in the
+// chat when the user can't be DMed.
+const ephemeral_reply_ttl = 30 * time.Second
+
+func (bot *Bot) deleteLater(ref messageRef) {
+	bot.deleteAfter(ref, bot.auto_delete_delay)
+}
+
+func (bot *Bot) deleteAfter(ref messageRef, delay time.Duration) {
+	time.AfterFunc(delay, func() {
+		_, err := bot.apiCall("deleteMessage", JsonTable{
+			"chat_id":    ref.chat_id,
+			"message_id": ref.message_id,
+		})
+		if err != nil {
+			// most likely the bot isn't allowed to delete messages there
+			bot.log.Debug("failed to delete message", "chat_id", ref.chat_id, "message_id", ref.message_id, "error", err)
+		}
+	})
+}
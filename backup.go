@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	BackupReport = "Снимок состояния сохранён: %s"
+	BackupFailed = "Не удалось сохранить снимок состояния."
+)
+
+// Version of the BotState layout, raised when a field changes meaning so an
+// older binary refuses snapshots it would misread.
+const state_schema = 1
+
+const backup_time_layout = "20060102T150405Z"
+
+// Snapshot is a self-contained copy of the state, written by /backup.
+type Snapshot struct {
+	Schema  int
+	Created time.Time
+	Version string
+	State   *BotState
+}
+
+// writeSnapshot saves the state to a new timestamped file in backup_dir. The
+// state is only encoded under state_mux, the file is written without it.
+func (bot *Bot) writeSnapshot(now time.Time) (string, error) {
+	bot.state_mux.Lock()
+	data, err := json.MarshalIndent(&Snapshot{
+		Schema:  state_schema,
+		Created: now.UTC(),
+		Version: versionString(),
+		State:   bot.currentState(),
+	}, "", "  ")
+	bot.state_mux.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(bot.backup_dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(bot.backup_dir, "state-"+now.UTC().Format(backup_time_layout)+".json")
+	return path, writeFileAtomic(path, data)
+}
+
+// restoreSnapshot replaces the saved state with the one in the snapshot at
+// path. Run before loadState.
+func (bot *Bot) restoreSnapshot(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	snapshot := &Snapshot{}
+	if err := json.Unmarshal(data, snapshot); err != nil {
+		return err
+	}
+	switch {
+	case snapshot.Schema == 0 || snapshot.State == nil:
+		return errors.New("not a state snapshot")
+	case snapshot.Schema > state_schema:
+		return fmt.Errorf("snapshot schema %d is newer than %d", snapshot.Schema, state_schema)
+	}
+	bot.log.Warn("restoring state from snapshot", "file", path, "created", snapshot.Created, "version", snapshot.Version)
+	return bot.store.Save(snapshot.State)
+}
+
+func (bot *Bot) backupState(ctx context.Context, message JsonTable, args []string) {
+	path, err := bot.writeSnapshot(time.Now())
+	if err != nil {
+		bot.messageLogger(message).Error("failed to write snapshot", "error", err)
+		bot.respond(ctx, message, BackupFailed)
+		return
+	}
+	bot.messageLogger(message).Info("snapshot written", "file", path)
+	bot.respond(ctx, message, fmt.Sprintf(BackupReport, escapeMarkdown(path)))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+const (
+	BannedMsg      = "Вам закрыт доступ к командам бота в этом канале."
+	BanUsage       = "Ответьте командой /ban на сообщение пользователя или укажите его id: /ban <id>"
+	UnbanUsage     = "Ответьте командой /unban на сообщение пользователя или укажите его id: /unban <id>"
+	BanAdminMsg    = "Нельзя закрыть доступ администратору канала."
+	BanReport      = "Пользователю `%s` закрыт доступ к командам бота. Его регистрации остались, удалить их можно через /remove."
+	UnbanReport    = "Пользователю `%s` снова доступны команды бота."
+	UnbanNotBanned = "Пользователь `%s` не был заблокирован."
+)
+
+var user_id_re = regexp.MustCompile(`^[0-9]+$`)
+
+func (bot *Bot) isBanned(chat_id json.Number, user_id json.Number) bool {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	return bot.chat_bans[chat_id][user_id]
+}
+
+// banTarget is the user a /ban or /unban is about: the author of the message
+// it replies to, or the id given as the argument.
+func (bot *Bot) banTarget(message JsonTable, args []string) json.Number {
+	if len(args) == 1 && user_id_re.MatchString(args[0]) {
+		return json.Number(args[0])
+	}
+	reply_to := getTbl(message, "reply_to_message")
+	if len(args) > 0 || hasKey(reply_to, "forum_topic_created") {
+		return ""
+	}
+	if target := getSenderId(reply_to); target != bot.Id {
+		return target
+	}
+	return ""
+}
+
+func (bot *Bot) banUser(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	target := bot.banTarget(message, args)
+	if target == "" {
+		bot.sendReply(chat_id, thread_id, message_id, BanUsage)
+		return
+	}
+	if is_admin, _ := bot.isUserAdmin(target, chat_id); is_admin {
+		bot.sendReply(chat_id, thread_id, message_id, BanAdminMsg)
+		return
+	}
+
+	bot.state_mux.Lock()
+	if bot.chat_bans[chat_id] == nil {
+		bot.chat_bans[chat_id] = map[json.Number]bool{}
+	}
+	bot.chat_bans[chat_id][target] = true
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	bot.messageLogger(message).Info("user banned", "target", target)
+	bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(BanReport, target))
+}
+
+func (bot *Bot) unbanUser(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	target := bot.banTarget(message, args)
+	if target == "" {
+		bot.sendReply(chat_id, thread_id, message_id, UnbanUsage)
+		return
+	}
+
+	bot.state_mux.Lock()
+	banned := bot.chat_bans[chat_id][target]
+	if banned {
+		delete(bot.chat_bans[chat_id], target)
+		if len(bot.chat_bans[chat_id]) == 0 {
+			delete(bot.chat_bans, chat_id)
+		}
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+
+	if !banned {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(UnbanNotBanned, target))
+		return
+	}
+	bot.messageLogger(message).Info("user unbanned", "target", target)
+	bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(UnbanReport, target))
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+const batch_progress_interval = 2 * time.Second
+
+const (
+	BatchProgressMsg = "Отправлено %d/%d"
+	BatchDoneMsg     = "Готово: отправлено %d/%d."
+	BatchFailedMsg   = "Не удалось отправить: %s"
+	NotifyAsk        = "Введите текст сообщения для участников события #%s:"
+	NotifyNoMembers  = "На событие #%s никто не зарегистрирован."
+)
+
+type OutgoingMessage struct {
+	ChatId json.Number
+	Name   string // used in the failure report
+	Text   string
+}
+
+// coalesce merges messages addressed to the same chat into one, keeping the
+// order in which chats first appear.
+func coalesce(messages []OutgoingMessage) []OutgoingMessage {
+	var result []OutgoingMessage
+	index := map[json.Number]int{}
+	for _, m := range messages {
+		if i, ok := index[m.ChatId]; ok {
+			result[i].Text += "\n\n" + m.Text
+			continue
+		}
+		index[m.ChatId] = len(result)
+		result = append(result, m)
+	}
+	return result
+}
+
+// sendBatch delivers messages through the outbox, keeping a status
+// message in status_chat updated with the progress, and reports the
+// recipients that couldn't be reached at the end.
+func (bot *Bot) sendBatch(status_chat json.Number, messages []OutgoingMessage) {
+	messages = coalesce(messages)
+	total := len(messages)
+
+	var status_id json.Number
+	if resp, err := bot.sendPrivateMessage(status_chat, fmt.Sprintf(BatchProgressMsg, 0, total), false); err == nil {
+		status_id = getNum(asTbl(resp), "message_id")
+	}
+
+	// the outbox delivers one at a time, so the callbacks don't overlap
+	sent, done := 0, 0
+	var failed []string
+	last_update := time.Now()
+	var wg sync.WaitGroup
+	wg.Add(total)
+	for _, m := range messages {
+		request := JsonTable{"chat_id": m.ChatId, "text": m.Text, "parse_mode": "Markdown"}
+		bot.queueMessage(request, func(result JsonAny, err error) {
+			defer wg.Done()
+			done++
+			if err != nil {
+				failed = append(failed, escapeMarkdown(m.Name))
+			} else {
+				sent++
+			}
+			if status_id != "" && time.Since(last_update) > batch_progress_interval && done < total {
+				bot.editMessageText(status_chat, status_id, fmt.Sprintf(BatchProgressMsg, done, total))
+				last_update = time.Now()
+			}
+		})
+	}
+	wg.Wait()
+
+	report := fmt.Sprintf(BatchDoneMsg, sent, total)
+	if status_id != "" {
+		bot.editMessageText(status_chat, status_id, report)
+	} else {
+		bot.sendPrivateMessage(status_chat, report, false)
+	}
+	if len(failed) > 0 {
+		bot.sendPrivateMessage(status_chat, fmt.Sprintf(BatchFailedMsg, strings.Join(failed, ", ")), false)
+	}
+}
+
+func (bot *Bot) editMessageText(chat_id json.Number, message_id json.Number, text string) {
+	_, err := bot.apiCall("editMessageText", JsonTable{
+		"chat_id":    chat_id,
+		"message_id": message_id,
+		"text":       text,
+		"parse_mode": "Markdown",
+	})
+	if err != nil {
+		// "message is not modified" is harmless here
+		bot.log.Debug("failed to edit message", "chat_id", chat_id, "error", err)
+	}
+}
+
+// notifyMembers DMs a text from the admin to everyone registered or waiting
+// for the active event.
+func (bot *Bot) notifyMembers(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	user_id := getSenderId(message)
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	var members []MemberRecord
+	if ok {
+		members = append(append(members, event.Registrations...), event.Waitlist...)
+	}
+	bot.state_mux.Unlock()
+	if !ok {
+		bot.sendPrivateMessage(user_id, NoActiveEventMsg, false)
+		return
+	}
+	if len(members) == 0 {
+		bot.sendPrivateMessage(user_id, fmt.Sprintf(NotifyNoMembers, event.ref()), false)
+		return
+	}
+
+	text, err := bot.askText(ctx, user_id, fmt.Sprintf(NotifyAsk, event.ref()))
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+
+	var messages []OutgoingMessage
+	for _, member := range members {
+		// imported members have no chat to DM
+		if member.UserId != "" && !bot.isOptedOut(member.UserId) {
+			messages = append(messages, OutgoingMessage{member.UserId, member.Name, escapeMarkdown(text)})
+		}
+	}
+	bot.sendBatch(user_id, messages)
+}
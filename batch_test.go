@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// Imported members have no user id, /notify must not queue a send to ""
+// for them.
+func TestNotifySkipsImportedMembers(t *testing.T) {
+	t.Parallel()
+	bot, api := newTestBot(t)
+	go bot.outbox.run(bot)
+	event := &EventInfo{EventId: 1, ChatId: "-1001"}
+	event.Registrations = []MemberRecord{{Seq: 1, Name: "a", UserId: "5"}, {Seq: 2, Name: "b"}}
+	event.Waitlist = []MemberRecord{{Seq: 3, Name: "c"}}
+	bot.state_mux.Lock()
+	bot.current_events[EventKey{ChatId: event.ChatId}] = event
+	bot.state_mux.Unlock()
+
+	message := JsonTable{"message_id": json.Number("3"), "text": "/notify",
+		"chat": JsonTable{"id": event.ChatId, "type": "supergroup"}, "from": JsonTable{"id": json.Number("2")}}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		bot.notifyMembers(context.Background(), message, nil)
+	}()
+	// the question is the first message
+	waitFor(t, "the question", func() bool { return bot.isWaiting("1") })
+	bot.processReply(reply("1", "Старт переносится"))
+	<-done
+
+	var recipients []json.Number
+	for _, request := range api.sent("sendMessage") {
+		if strings.Contains(getStr(request, "text"), "Старт переносится") {
+			recipients = append(recipients, getNum(request, "chat_id"))
+		}
+	}
+	if len(recipients) != 1 || recipients[0] != "5" {
+		t.Errorf("sent to %v, want only 5", recipients)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Bot is everything that belongs to one bot token: the API endpoints and
+// the identity from getMe, the state saved by saveState, the settings read
+// at startup and the work in progress. The command handlers are its
+// methods. Only the HTTP clients and send_limiter are shared by all the bots
+// of a process.
+type Bot struct {
+	Name string // username, without the @
+	Id   json.Number
+	// the name in BOT_TOKENS, empty for the only bot of the process
+	instance string
+	log      *slog.Logger // with a "bot" field when there are several
+
+	url         string
+	file_url    string
+	client      *http.Client // API calls
+	long_client *http.Client // getUpdates and downloads, same connection pool
+	headers     http.Header  // added to every request, BOT_API_HEADERS
+	// stops calling the API while it keeps failing for this token
+	breaker *CircuitBreaker
+
+	// prompts waiting for an answer, by the prompt message id
+	replies     map[json.Number]*Prompt
+	replies_mux sync.Mutex
+	// how long a question waits for its answer
+	reply_timeout time.Duration
+
+	commands       map[string]Command
+	paged_listings map[string]PagedListing // by callback data prefix
+
+	// everything saved by saveState, guarded by state_mux
+	state_mux      sync.Mutex
+	store          Store
+	id_counter     int32
+	current_events map[EventKey]*EventInfo
+	chat_configs   map[json.Number]*ChatConfig
+	// closed events per chat, oldest first
+	chat_history map[json.Number][]*EventInfo
+	// users who don't want informational DMs
+	opted_out  map[json.Number]bool
+	chat_infos map[json.Number]*ChatInfo
+	// when users joined each chat. The Bot API doesn't report join dates in
+	// getChatMember, so they are collected from join updates. Users who
+	// joined before the bot started watching are unknown.
+	join_dates map[json.Number]map[json.Number]time.Time
+	// keyed by command, then chat. Saved along with the next state change
+	// rather than on every command.
+	command_usage map[string]map[json.Number]*UsageCounter
+	// users each chat blocked with /ban. They stay in the chat, the bot just
+	// ignores their commands and reactions there.
+	chat_bans map[json.Number]map[json.Number]bool
+	// pauses every command but /maintenance, survives a restart
+	maintenance bool
+
+	outbox      *Outbox
+	outbox_file string // BOT_OUTBOX_FILE, empty keeps the queue in memory
+
+	admins_cache     map[json.Number]adminsCacheEntry
+	admins_cache_mux sync.Mutex
+	// held by a user for the whole multi-question register flow in a chat,
+	// so a second /register can't produce a duplicate record
+	registration_locks     map[registrationKey]bool
+	registration_locks_mux sync.Mutex
+	// the commands each user has in progress, for /cancel
+	running_commands     map[json.Number][]*runningCommand
+	running_commands_mux sync.Mutex
+	// command output deleted after auto_delete_delay
+	transient_messages     map[messageRef]bool
+	transient_messages_mux sync.Mutex
+	alerts                 map[string]*alertState
+	alerts_mux             sync.Mutex
+
+	// parent of every command context, cancelled with ErrShuttingDown on
+	// shutdown to wake up everyone waiting for a reply
+	root_ctx  context.Context
+	stop_root context.CancelCauseFunc
+	// running update handlers, waited for on shutdown
+	handlers sync.WaitGroup
+	// a token per update being handled, see acquireWorker
+	update_workers chan struct{}
+	// the updates being handled by update id, with their start, for naming
+	// the stragglers when shutdown gives up on them
+	in_flight     map[int64]time.Time
+	in_flight_mux sync.Mutex
+	// the getUpdates offset past the last update handed to a worker, 0
+	// until the poll loop accepted one
+	poll_offset atomic.Int64
+
+	// Only one instance may poll for updates. With BOT_LOCK_FILE set the
+	// instance holding an exclusive lock on that file is the leader; the
+	// lock goes away with the process, so a standby takes over when the
+	// leader dies.
+	leader_lock_path string
+	leader_lock      *os.File // kept open, closing it drops the lock
+	is_leader        atomic.Bool
+
+	// operators of the bot itself, BOT_ADMINS
+	bot_admins map[json.Number]bool
+	// may run the read-only commands everywhere, BOT_OBSERVERS
+	bot_observers map[json.Number]bool
+	// receives error reports, BOT_ALERT_CHAT. Disabled if empty.
+	alert_chat json.Number
+	// lower-cased substrings rejected in event descriptions and participant
+	// names, BOT_BANNED_WORDS. Empty disables the filter.
+	banned_words      []string
+	confirm_dm        bool
+	send_typing       bool          // off with BOT_TYPING=0
+	allow_seed        bool          // enables /seed, BOT_ALLOW_SEED=1. Never in production.
+	auto_delete_delay time.Duration // 0 disables auto-deletion
+	send_retry_policy RetryPolicy
+	// reply to non-admins trying admin commands
+	deny_message string
+	// reply to commands during maintenance
+	maintenance_message string
+	// sent to users whose question was cut short by a shutdown
+	shutdown_message string
+
+	files_dir  string // where saveFile puts downloads, BOT_FILES_DIR
+	backup_dir string
+	// serves /healthz and /debug/vars, BOT_HEALTH_ADDR. Off if empty.
+	health_addr string
+	// enables the read-only HTTP API, BOT_API_TOKEN
+	api_token string
+	// public address of the health server, BOT_CALENDAR_URL. The feeds are
+	// served only when it is set.
+	calendar_url string
+	// webhook mode instead of long polling, BOT_WEBHOOK_URL
+	webhook_url    string
+	webhook_secret string // BOT_WEBHOOK_SECRET, checked on every update
+	webhook_addr   string // BOT_WEBHOOK_ADDR, default_webhook_addr if empty
+}
+
+// Prompt is a question waiting for its answer on ch.
+type Prompt struct {
+	ch      chan JsonAny // closed by closePrompts
+	user_id json.Number  // who is asked
+	chat_id json.Number  // where the command asking it was sent
+	plain   bool         // takes a message that isn't a reply, see deliverPlain
+}
+
+// newBot creates a bot with empty state and the default settings, the
+// caller loads the state and applies the configuration.
+func newBot(api_url string, token string, client *http.Client, long_client *http.Client) *Bot {
+	bot := &Bot{
+		log:         slog.Default(),
+		url:         api_url + token + "/",
+		file_url:    strings.TrimSuffix(api_url, "bot") + "file/bot" + token + "/",
+		client:      client,
+		long_client: long_client,
+		replies:     map[json.Number]*Prompt{},
+		headers:     http.Header{},
+
+		reply_timeout: default_reply_timeout,
+
+		current_events: map[EventKey]*EventInfo{},
+		chat_configs:   map[json.Number]*ChatConfig{},
+		chat_history:   map[json.Number][]*EventInfo{},
+		opted_out:      map[json.Number]bool{},
+		chat_infos:     map[json.Number]*ChatInfo{},
+		join_dates:     map[json.Number]map[json.Number]time.Time{},
+		command_usage:  map[string]map[json.Number]*UsageCounter{},
+		chat_bans:      map[json.Number]map[json.Number]bool{},
+
+		admins_cache:       map[json.Number]adminsCacheEntry{},
+		registration_locks: map[registrationKey]bool{},
+		running_commands:   map[json.Number][]*runningCommand{},
+		transient_messages: map[messageRef]bool{},
+		alerts:             map[string]*alertState{},
+		update_workers:     make(chan struct{}, default_update_workers),
+		in_flight:          map[int64]time.Time{},
+
+		bot_admins:          map[json.Number]bool{},
+		bot_observers:       map[json.Number]bool{},
+		confirm_dm:          true,
+		send_typing:         true,
+		send_retry_policy:   RetrySafe,
+		deny_message:        AuthorizeErrorMsg,
+		maintenance_message: MaintenanceMsg,
+		shutdown_message:    ShutdownMsg,
+		files_dir:           "files",
+		backup_dir:          "backups",
+	}
+	bot.breaker = newCircuitBreaker(breaker_threshold, breaker_cooldown, bot.log)
+	bot.root_ctx, bot.stop_root = context.WithCancelCause(context.Background())
+	bot.commands = bot.builtinCommands()
+	bot.paged_listings = bot.pagedListings()
+	return bot
+}
+
+// parseHeaders parses BOT_API_HEADERS, "Name: value" pairs separated by
+// newlines or semicolons.
+func parseHeaders(value string) (http.Header, error) {
+	headers := http.Header{}
+	for _, line := range strings.FieldsFunc(value, func(r rune) bool { return r == '\n' || r == ';' }) {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		name, content, ok := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("expected Name: value, got %q", line)
+		}
+		headers.Add(name, strings.TrimSpace(content))
+	}
+	return headers, nil
+}
+
+func (bot *Bot) post(tg_func string, data []byte) (*http.Response, error) {
+	client := bot.client
+	if tg_func == "getUpdates" {
+		client = bot.long_client
+	}
+	request, err := http.NewRequest(http.MethodPost, bot.url+tg_func, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	bot.addHeaders(request)
+	request.Header.Set("Content-Type", "application/json")
+	return client.Do(request)
+}
+
+func (bot *Bot) getFile(file_path string) (*http.Response, error) {
+	request, err := http.NewRequest(http.MethodGet, bot.file_url+file_path, nil)
+	if err != nil {
+		return nil, err
+	}
+	bot.addHeaders(request)
+	return bot.long_client.Do(request)
+}
+
+func (bot *Bot) addHeaders(request *http.Request) {
+	for name, values := range bot.headers {
+		request.Header[name] = values
+	}
+}
+
+// identify fills Name and Id from a getMe result.
+func (bot *Bot) identify(me JsonAny) {
+	bot.Name = getStr(asTbl(me), "username")
+	bot.Id = getNum(asTbl(me), "id")
+}
+
+// waiter returns the channel the answer of user_id to the message_id prompt
+// arrives on. chat_id is the chat of the command asking.
+func (bot *Bot) waiter(user_id json.Number, chat_id json.Number, message_id json.Number) chan JsonAny {
+	bot.replies_mux.Lock()
+	defer bot.replies_mux.Unlock()
+	prompt, ok := bot.replies[message_id]
+	if !ok {
+		prompt = &Prompt{ch: make(chan JsonAny, 1)}
+		bot.replies[message_id] = prompt
+	}
+	prompt.user_id, prompt.chat_id = user_id, chat_id
+	return prompt.ch
+}
+
+// acceptPlain lets the message_id prompt be answered without replying to it.
+// Only for prompts asking for text, a button prompt has nothing to do with a
+// message. Must be called before waiting on the prompt.
+func (bot *Bot) acceptPlain(message_id json.Number) {
+	bot.replies_mux.Lock()
+	defer bot.replies_mux.Unlock()
+	prompt, ok := bot.replies[message_id]
+	if !ok {
+		prompt = &Prompt{ch: make(chan JsonAny, 1)}
+		bot.replies[message_id] = prompt
+	}
+	prompt.plain = true
+}
+
+func (bot *Bot) stopWaiting(message_id json.Number) {
+	bot.replies_mux.Lock()
+	delete(bot.replies, message_id)
+	bot.replies_mux.Unlock()
+}
+
+func (bot *Bot) isWaiting(message_id json.Number) bool {
+	bot.replies_mux.Lock()
+	defer bot.replies_mux.Unlock()
+	_, ok := bot.replies[message_id]
+	return ok
+}
+
+// deliverReply hands reply to whoever waits on message_id. The first reply
+// wins: it takes the waiter out of replies, so later ones find nobody and
+// the buffered send never blocks. Returns false if nobody was waiting.
+func (bot *Bot) deliverReply(message_id json.Number, reply JsonAny) bool {
+	bot.replies_mux.Lock()
+	prompt, ok := bot.replies[message_id]
+	delete(bot.replies, message_id)
+	bot.replies_mux.Unlock()
+	if ok {
+		prompt.ch <- reply
+	}
+	return ok
+}
+
+// deliverPlain takes a message that isn't a reply as the answer when its
+// author waits on exactly one prompt and that prompt accepts plain answers.
+// With more than one prompt there's no telling which the message is for.
+func (bot *Bot) deliverPlain(user_id json.Number, message JsonAny) bool {
+	bot.replies_mux.Lock()
+	var found []json.Number
+	for message_id, prompt := range bot.replies {
+		if prompt.user_id == user_id {
+			found = append(found, message_id)
+		}
+	}
+	if len(found) != 1 || !bot.replies[found[0]].plain {
+		bot.replies_mux.Unlock()
+		return false
+	}
+	bot.replies_mux.Unlock()
+	return bot.deliverReply(found[0], message)
+}
+
+// closePrompts closes the prompts matching, so their waitForReply returns
+// ErrPromptFlushed, and returns how many there were.
+func (bot *Bot) closePrompts(matching func(p *Prompt) bool) int {
+	bot.replies_mux.Lock()
+	var flushed []*Prompt
+	for message_id, prompt := range bot.replies {
+		if matching(prompt) {
+			delete(bot.replies, message_id)
+			flushed = append(flushed, prompt)
+		}
+	}
+	bot.replies_mux.Unlock()
+	// taken out of replies under the lock, so nobody sends on them anymore
+	for _, prompt := range flushed {
+		close(prompt.ch)
+	}
+	return len(flushed)
+}
+
+func (bot *Bot) pendingReplies() int {
+	bot.replies_mux.Lock()
+	defer bot.replies_mux.Unlock()
+	return len(bot.replies)
+}
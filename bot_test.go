@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// reply is a user's answer to the message_id prompt of the bot.
+func reply(message_id json.Number, text string) JsonTable {
+	return JsonTable{
+		"message_id": json.Number("900"),
+		"chat":       JsonTable{"id": json.Number("2"), "type": "private"},
+		"from":       JsonTable{"id": json.Number("2")},
+		"text":       text,
+		"reply_to_message": JsonTable{
+			"message_id": message_id,
+			"from":       JsonTable{"id": test_bot_id},
+		},
+	}
+}
+
+// Two replies racing for one prompt: exactly one is delivered, the other
+// finds nobody waiting, and neither blocks.
+func TestConcurrentReplies(t *testing.T) {
+	for range 100 {
+		b := newBot("http://localhost/bot", "test", http.DefaultClient, http.DefaultClient)
+		ch := b.waiter("2", "", "10")
+
+		delivered := make(chan string, 2)
+		var wg sync.WaitGroup
+		for _, text := range []string{"first", "second"} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if b.deliverReply("10", text) {
+					delivered <- text
+				}
+			}()
+		}
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("deliverReply blocked")
+		}
+		close(delivered)
+
+		var winners []string
+		for text := range delivered {
+			winners = append(winners, text)
+		}
+		if len(winners) != 1 {
+			t.Fatalf("delivered %v, want exactly one reply", winners)
+		}
+		if got := <-ch; got != winners[0] {
+			t.Errorf("waiter got %v, want %v", got, winners[0])
+		}
+		if b.isWaiting("10") {
+			t.Error("prompt still waiting after its reply")
+		}
+	}
+}
+
+// The reply after the first one is told the question expired.
+func TestSecondReplyExpired(t *testing.T) {
+	t.Parallel()
+	bot, api := newTestBot(t)
+	ch := bot.waiter("2", "", "10")
+
+	bot.processReply(reply("10", "first"))
+	bot.processReply(reply("10", "second"))
+
+	if got := getStr(asTbl(<-ch), "text"); got != "first" {
+		t.Errorf("waiter got %q, want the first reply", got)
+	}
+	sent := api.sent("sendMessage")
+	if len(sent) != 1 || getStr(sent[0], "text") != ReplyTimoutMsg || getNum(sent[0], "chat_id") != "2" {
+		t.Errorf("sent %v, want one ReplyTimoutMsg to the user", sent)
+	}
+}
+
+// answer is what askText returned.
+type answer struct {
+	text string
+	err  error
+}
+
+func ask(bot *Bot, question string) chan answer {
+	result := make(chan answer, 1)
+	go func() {
+		text, err := bot.askText(context.Background(), "2", question)
+		result <- answer{text, err}
+	}()
+	return result
+}
+
+func TestReplyRoundTrip(t *testing.T) {
+	t.Parallel()
+	bot, api := newTestBot(t)
+	result := ask(bot, "Как зовут?")
+	// the fake API numbers messages from 1, the question is the first
+	waitFor(t, "the question", func() bool { return bot.isWaiting("1") })
+
+	bot.handleMessage(JsonTable{"update_id": json.Number("1"), "message": reply("1", " Вася ")})
+	got := <-result
+	if got.err != nil || got.text != "Вася" {
+		t.Errorf("askText = %q, %v; want the reply", got.text, got.err)
+	}
+	if sent := api.sent("sendMessage"); len(sent) != 1 || getStr(sent[0], "text") != "Как зовут?" {
+		t.Errorf("sent %v, want only the question", sent)
+	}
+	if n := bot.pendingReplies(); n != 0 {
+		t.Errorf("%d prompts still waiting", n)
+	}
+}
+
+func TestReplyTimeout(t *testing.T) {
+	t.Parallel()
+	bot, api := newTestBot(t)
+	bot.reply_timeout = 20 * time.Millisecond
+	result := ask(bot, "Как зовут?")
+
+	if got := <-result; !errors.Is(got.err, ErrReplyTimeout) {
+		t.Fatalf("askText = %q, %v; want ErrReplyTimeout", got.text, got.err)
+	}
+	if bot.isWaiting("1") || bot.pendingReplies() != 0 {
+		t.Error("the waiter wasn't removed after the timeout")
+	}
+
+	// answering too late is told so
+	bot.handleMessage(JsonTable{"update_id": json.Number("1"), "message": reply("1", "Вася")})
+	sent := api.sent("sendMessage")
+	if len(sent) != 2 || getStr(sent[1], "text") != ReplyTimoutMsg || getNum(sent[1], "chat_id") != "2" {
+		t.Errorf("sent %v, want the question and ReplyTimoutMsg", sent)
+	}
+}
+
+func TestReplyToUnknownMessage(t *testing.T) {
+	t.Parallel()
+	bot, api := newTestBot(t)
+
+	// a reply to one of the bot's messages nobody waits on is an expired
+	// prompt
+	bot.processReply(reply("555", "Вася"))
+	sent := api.sent("sendMessage")
+	if len(sent) != 1 || getStr(sent[0], "text") != ReplyTimoutMsg {
+		t.Errorf("sent %v, want ReplyTimoutMsg", sent)
+	}
+
+	// a reply to somebody else's message is ignored
+	chatter := reply("556", "согласен")
+	chatter["reply_to_message"] = JsonTable{"message_id": json.Number("556"), "from": JsonTable{"id": json.Number("3")}}
+	bot.processReply(chatter)
+	if sent := api.sent("sendMessage"); len(sent) != 1 {
+		t.Errorf("sent %v, want nothing more after a reply to another user", sent)
+	}
+}
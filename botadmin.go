@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+const (
+	BotAdminOnlyMsg = "Команда доступна только администраторам бота."
+	ObserverOnlyMsg = "Команда доступна только наблюдателям и администраторам."
+)
+
+func parseUserIds(list string) map[json.Number]bool {
+	ids := map[json.Number]bool{}
+	for _, id := range strings.Split(list, ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids[json.Number(id)] = true
+		}
+	}
+	return ids
+}
+
+func (bot *Bot) isBotAdmin(user_id json.Number) bool {
+	return bot.bot_admins[user_id]
+}
+
+func (bot *Bot) isObserver(user_id json.Number) bool {
+	return bot.bot_observers[user_id] || bot.bot_admins[user_id]
+}
+
+// requireObserver lets observers and bot operators through anywhere, and
+// admins of the chat the command was sent to.
+func (bot *Bot) requireObserver(next CommandHandler) CommandHandler {
+	return func(ctx context.Context, message JsonTable, args []string) {
+		if bot.isObserver(getSenderId(message)) {
+			next(ctx, message, args)
+			return
+		}
+		if isPrivateChat(message) {
+			bot.replyPrivately(message, ObserverOnlyMsg)
+			bot.countDenied(message)
+			return
+		}
+		if bot.authorize(message) {
+			next(ctx, message, args)
+		} else {
+			bot.countDenied(message)
+		}
+	}
+}
+
+// requireBotAdmin restricts a command to bot operators in a private chat.
+func (bot *Bot) requireBotAdmin(next CommandHandler) CommandHandler {
+	return func(ctx context.Context, message JsonTable, args []string) {
+		user_id := getSenderId(message)
+		if !bot.isBotAdmin(user_id) || !isPrivateChat(message) {
+			bot.sendPrivateMessage(user_id, BotAdminOnlyMsg, false)
+			bot.countDenied(message)
+			return
+		}
+		next(ctx, message, args)
+	}
+}
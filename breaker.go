@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	breaker_threshold = 5
+	breaker_cooldown  = 30 * time.Second
+)
+
+var ErrBreakerOpen = errors.New("telegram API circuit breaker is open")
+
+type BreakerState int
+
+const (
+	BreakerClosed BreakerState = iota
+	BreakerOpen
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	}
+	return "closed"
+}
+
+// CircuitBreaker stops calling a failing API for a while. After threshold
+// consecutive failures it opens and rejects calls for cooldown, then lets a
+// single probe through: success closes it, failure opens it again.
+type CircuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	log       *slog.Logger
+
+	mux       sync.Mutex
+	state     BreakerState
+	failures  int
+	opened_at time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration, log *slog.Logger) *CircuitBreaker {
+	return &CircuitBreaker{threshold: threshold, cooldown: cooldown, log: log}
+}
+
+// Allow returns ErrBreakerOpen if the call must not be made now.
+func (b *CircuitBreaker) Allow() error {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.opened_at) < b.cooldown {
+			return ErrBreakerOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrBreakerOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+// Record reports the outcome of an allowed call.
+func (b *CircuitBreaker) Record(failed bool) {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	b.probing = false
+	if !failed {
+		if b.state != BreakerClosed {
+			b.log.Info("circuit breaker closed")
+		}
+		b.state, b.failures = BreakerClosed, 0
+		return
+	}
+
+	b.failures++
+	if b.state == BreakerHalfOpen || b.failures >= b.threshold {
+		if b.state != BreakerOpen {
+			b.log.Warn("circuit breaker opened", "failures", b.failures)
+		}
+		b.state = BreakerOpen
+		b.opened_at = time.Now()
+	}
+}
+
+func (b *CircuitBreaker) State() BreakerState {
+	b.mux.Lock()
+	defer b.mux.Unlock()
+	return b.state
+}
+
+// isApiFailure tells infrastructure failures, which count against the
+// breaker, from errors the API reported for a particular request.
+func isApiFailure(err error) bool {
+	var api_err TgApiError
+	var migrated *ChatMigratedError
+	return err != nil && !errors.As(err, &api_err) && !errors.As(err, &migrated)
+}
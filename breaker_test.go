@@ -0,0 +1,31 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+)
+
+// One token failing doesn't stop the other bots of the process.
+func TestBreakerPerBot(t *testing.T) {
+	t.Parallel()
+	healthy, _ := newTestBot(t)
+	down := httptest.NewServer(nil)
+	down.Close()
+	failing := newBot(down.URL+"/bot", "revoked", healthy.client, healthy.long_client)
+	failing.send_retry_policy = RetryNever
+
+	for range breaker_threshold {
+		failing.apiCall("sendMessage", JsonTable{"chat_id": "1", "text": "hi"})
+	}
+	if _, err := failing.apiCall("sendMessage", JsonTable{"chat_id": "1", "text": "hi"}); !errors.Is(err, ErrBreakerOpen) {
+		t.Fatalf("after %d failures the call failed with %v, want ErrBreakerOpen", breaker_threshold, err)
+	}
+
+	if _, err := healthy.apiCall("sendMessage", JsonTable{"chat_id": "1", "text": "hi"}); err != nil {
+		t.Errorf("the other bot's call failed: %v", err)
+	}
+	if state := healthy.breaker.State(); state != BreakerClosed {
+		t.Errorf("the other bot's breaker is %s", state)
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	CalendarLink  = "Календарь событий канала, добавьте ссылку в приложение календаря как подписку:\n%s"
+	CalendarOff   = "Календарь не настроен, обратитесь к администратору бота."
+	CalendarReset = "Старая ссылка на календарь больше не работает, новая:\n%s"
+)
+
+const (
+	ical_time_layout = "20060102T150405Z"
+	ical_line_octets = 75
+)
+
+func newCalendarToken() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (bot *Bot) calendarLink(chat_id json.Number, token string) string {
+	return fmt.Sprintf("%s/calendar/%s/%s.ics", strings.TrimSuffix(bot.calendar_url, "/"), chat_id, token)
+}
+
+// showCalendar posts the feed link, making up the chat's token the first
+// time. "/calendar reset" lets an admin replace a leaked link.
+func (bot *Bot) showCalendar(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	if bot.calendar_url == "" {
+		bot.respond(ctx, message, CalendarOff)
+		return
+	}
+	reset := len(args) == 1 && args[0] == "reset"
+	if reset && !bot.authorize(message) {
+		return
+	}
+
+	token := bot.getChatConfig(chat_id).CalendarToken
+	if token == "" || reset {
+		token = newCalendarToken()
+		bot.updateChatConfig(chat_id, func(config *ChatConfig) {
+			config.CalendarToken = token
+		})
+	}
+	text := CalendarLink
+	if reset {
+		bot.messageLogger(message).Info("calendar token reset")
+		text = CalendarReset
+	}
+	bot.respondRaw(ctx, message, fmt.Sprintf(text, bot.calendarLink(chat_id, token)))
+}
+
+// icalText escapes a TEXT value.
+func icalText(text string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`).Replace(text)
+}
+
+// writeIcalLine writes a content line, folded at 75 octets without
+// splitting characters.
+func writeIcalLine(b *strings.Builder, line string) {
+	limit := ical_line_octets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		b.WriteString(line[:cut] + "\r\n ")
+		line = line[cut:]
+		limit = ical_line_octets - 1 // the leading space counts
+	}
+	b.WriteString(line + "\r\n")
+}
+
+// calendarEvents are the active events of the chat and the closed ones
+// still to start, soonest first. Events without a start time are left out.
+func calendarEvents(state *BotState, chat_id json.Number, now time.Time) []*EventInfo {
+	var events []*EventInfo
+	for _, event := range state.Events {
+		if event.ChatId == chat_id && !event.StartTime.IsZero() {
+			events = append(events, event)
+		}
+	}
+	for _, event := range state.History[chat_id] {
+		if event.StartTime.After(now) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool { return events[i].StartTime.Before(events[j].StartTime) })
+	return events
+}
+
+func formatCalendar(state *BotState, chat_id json.Number, now time.Time) string {
+	b := &strings.Builder{}
+	writeIcalLine(b, "BEGIN:VCALENDAR")
+	writeIcalLine(b, "VERSION:2.0")
+	writeIcalLine(b, "PRODID:-//drift-tracker-bot//calendar//RU")
+	if info, ok := state.Chats[chat_id]; ok && info.Title != "" {
+		writeIcalLine(b, "X-WR-CALNAME:"+icalText(info.Title))
+	}
+	for _, event := range calendarEvents(state, chat_id, now) {
+		summary, _, _ := strings.Cut(event.Description, "\n")
+		writeIcalLine(b, "BEGIN:VEVENT")
+		writeIcalLine(b, fmt.Sprintf("UID:%d-%s@drift-tracker-bot", event.EventId, chat_id))
+		writeIcalLine(b, "DTSTAMP:"+now.UTC().Format(ical_time_layout))
+		writeIcalLine(b, "DTSTART:"+event.StartTime.UTC().Format(ical_time_layout))
+		writeIcalLine(b, "SUMMARY:"+icalText(fmt.Sprintf("#%s %s", event.ref(), strings.TrimSpace(summary))))
+		writeIcalLine(b, "DESCRIPTION:"+icalText(event.Description))
+		if event.Location != "" {
+			writeIcalLine(b, "LOCATION:"+icalText(event.Location))
+		}
+		writeIcalLine(b, "END:VEVENT")
+	}
+	writeIcalLine(b, "END:VCALENDAR")
+	return b.String()
+}
+
+// calendarFeed serves /calendar/{id}/{token}.ics. Like the API it reads the
+// state through the store.
+func (bot *Bot) calendarFeed(w http.ResponseWriter, r *http.Request) {
+	chat_id := json.Number(r.PathValue("id"))
+	token, ok := strings.CutSuffix(r.PathValue("token"), ".ics")
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	state, ok := bot.loadApiState(w)
+	if !ok {
+		return
+	}
+	config, ok := state.Configs[chat_id]
+	if !ok || config.CalendarToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.CalendarToken)) != 1 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Write([]byte(formatCalendar(state, chat_id, time.Now())))
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	confirm_yes = "yes"
+	confirm_no  = "no"
+)
+
+const (
+	ConfirmYesButton = "Да"
+	ConfirmNoButton  = "Нет"
+)
+
+type InlineButton struct {
+	Text string `json:"text"`
+	Data string `json:"callback_data"`
+}
+
+func inlineKeyboard(rows ...[]InlineButton) JsonTable {
+	return JsonTable{"inline_keyboard": rows}
+}
+
+func (bot *Bot) answerCallback(callback_id string, text string) {
+	request := JsonTable{"callback_query_id": callback_id}
+	if text != "" {
+		request["text"] = text
+	}
+	if _, err := bot.apiCall("answerCallbackQuery", request); err != nil {
+		bot.log.Warn("failed to answer callback query", "error", err)
+	}
+}
+
+// processCallback routes a button press to whoever waits on the message the
+// keyboard is attached to, the same way processReply routes text replies.
+func (bot *Bot) processCallback(callback JsonTable) {
+	if bot.processPageCallback(callback) || bot.processUndoCallback(callback) {
+		return
+	}
+	if bot.deliverReply(getNum(getTbl(callback, "message"), "message_id"), callback) {
+		bot.answerCallback(getStr(callback, "id"), "")
+	} else {
+		bot.answerCallback(getStr(callback, "id"), ReplyTimoutMsg)
+	}
+}
+
+func (bot *Bot) removeKeyboard(chat_id json.Number, message_id json.Number) {
+	_, err := bot.apiCall("editMessageReplyMarkup", JsonTable{
+		"chat_id":    chat_id,
+		"message_id": message_id,
+	})
+	if err != nil {
+		bot.log.Warn("failed to remove keyboard", "chat_id", chat_id, "error", err)
+	}
+}
+
+// askConfirmation DMs a question with Yes/No buttons and waits for the answer.
+func (bot *Bot) askConfirmation(ctx context.Context, user_id json.Number, question string) (bool, error) {
+	resp, err := bot.apiCall("sendMessage", JsonTable{
+		"chat_id":    user_id,
+		"text":       question,
+		"parse_mode": "Markdown",
+		"reply_markup": inlineKeyboard([]InlineButton{
+			{ConfirmYesButton, confirm_yes},
+			{ConfirmNoButton, confirm_no},
+		}),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	message_id := getNum(asTbl(resp), "message_id")
+	answer, err := bot.waitForReply(ctx, user_id, message_id)
+	bot.removeKeyboard(user_id, message_id)
+	if err != nil {
+		return false, err
+	}
+	return getStr(asTbl(answer), "data") == confirm_yes, nil
+}
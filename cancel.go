@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	CancelledMsg       = "Команда прервана."
+	NothingToCancelMsg = "Нет выполняющихся команд."
+)
+
+// Upper bound on a whole command, including every question it asks.
+const command_timeout = 15 * time.Minute
+
+var (
+	ErrCancelled      = errors.New("command cancelled")
+	ErrCommandTimeout = errors.New("command timed out")
+)
+
+type commandMessageKey struct{}
+
+// commandMessage is the message of the command ctx belongs to, nil outside
+// commands.
+func commandMessage(ctx context.Context) JsonTable {
+	message, _ := ctx.Value(commandMessageKey{}).(JsonTable)
+	return message
+}
+
+type runningCommand struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+}
+
+// commandContext returns the context of a command sent in message. It ends
+// with ErrShuttingDown, ErrCommandTimeout after command_timeout or with
+// ErrCancelled on /cancel from the sender. done must be called when the
+// command returns.
+func (bot *Bot) commandContext(message JsonTable) (context.Context, func()) {
+	timeout_ctx, stop := context.WithTimeoutCause(bot.root_ctx, command_timeout, ErrCommandTimeout)
+	ctx, cancel := context.WithCancelCause(context.WithValue(timeout_ctx, commandMessageKey{}, message))
+	user_id := getSenderId(message)
+	running := &runningCommand{ctx, cancel}
+
+	bot.running_commands_mux.Lock()
+	bot.running_commands[user_id] = append(bot.running_commands[user_id], running)
+	bot.running_commands_mux.Unlock()
+
+	return ctx, func() {
+		bot.running_commands_mux.Lock()
+		list := bot.running_commands[user_id]
+		for i, r := range list {
+			if r == running {
+				list = append(list[:i], list[i+1:]...)
+				break
+			}
+		}
+		if len(list) == 0 {
+			delete(bot.running_commands, user_id)
+		} else {
+			bot.running_commands[user_id] = list
+		}
+		bot.running_commands_mux.Unlock()
+		cancel(nil)
+		stop()
+	}
+}
+
+// cancelCommands aborts the other commands of the sender, typically one
+// waiting for an answer in the private chat.
+func (bot *Bot) cancelCommands(ctx context.Context, message JsonTable, args []string) {
+	user_id := getSenderId(message)
+	cancelled := 0
+	bot.running_commands_mux.Lock()
+	for _, r := range bot.running_commands[user_id] {
+		if r.ctx != ctx {
+			r.cancel(ErrCancelled)
+			cancelled++
+		}
+	}
+	bot.running_commands_mux.Unlock()
+
+	text := CancelledMsg
+	if cancelled == 0 {
+		text = NothingToCancelMsg
+	}
+	bot.sendReply(getChatId(message), getThreadId(message), getNum(message, "message_id"), text)
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	EventFullMsg     = "Все места на событие #%s заняты. Новые участники попадут в лист ожидания."
+	EventSlotFreeMsg = "На событие #%s освободилось мест: %d. Регистрация — /register."
+	OwnerFullMsg     = "Событие #%s заполнено: заняты все места (%d)."
+	OwnerEmptyMsg    = "На событие #%s не осталось зарегистрированных участников."
+
+	SetCapUsage    = "Использование: /setcap <число мест, 0 — без ограничений> [force]"
+	SetCapTooFew   = "Участники уже занимают %d мест. Чтобы перевести лишних в лист ожидания, добавьте force: /setcap %d force"
+	SetCapReport   = "Число мест на событие #%s: %s. Переведено из листа ожидания: %d, в лист ожидания: %d."
+	SetCapDemoted  = "Число мест на событие #%s уменьшено, вы переведены в лист ожидания.\n\n%s\n\nВаш номер в листе ожидания: %s."
+	SetCapStepDesc = "%s → %s"
+	CapUnlimited   = "без ограничений"
+)
+
+func capacityLabel(capacity int) string {
+	if capacity == 0 {
+		return CapUnlimited
+	}
+	return strconv.Itoa(capacity)
+}
+
+// demoteOverflow moves registrations back to the waitlist until they fit
+// into the capacity, those with the lowest priority and the latest first.
+// They go ahead of everyone waiting with the same priority. Must be called
+// with state_mux held.
+func demoteOverflow(event *EventInfo) []MemberRecord {
+	if event.Capacity == 0 {
+		return nil
+	}
+	order := make([]int, len(event.Registrations))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ra, rb := event.Registrations[order[a]], event.Registrations[order[b]]
+		if ra.Priority != rb.Priority {
+			return ra.Priority < rb.Priority
+		}
+		return order[a] > order[b]
+	})
+
+	drop := map[int]bool{}
+	taken := event.takenSlots()
+	for _, i := range order {
+		if taken <= event.Capacity {
+			break
+		}
+		drop[i] = true
+		taken -= event.Registrations[i].slots()
+	}
+
+	var kept, demoted []MemberRecord
+	for i, member := range event.Registrations {
+		if drop[i] {
+			member.ClaimDeadline = time.Time{}
+			demoted = append(demoted, member)
+			event.audit(nil, audit_demoted, member.Name)
+		} else {
+			kept = append(kept, member)
+		}
+	}
+	event.Registrations = kept
+	event.Waitlist = append(append([]MemberRecord{}, demoted...), event.Waitlist...)
+	event.sortWaitlist()
+	return demoted
+}
+
+// setCapacity changes the capacity of the active event. Raising it offers the
+// new slots to the waitlist, lowering it below what is taken needs "force"
+// and moves the overflow to the waitlist.
+func (bot *Bot) setCapacity(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	force := len(args) == 2 && args[1] == "force"
+	if len(args) == 0 || len(args) > 2 || (len(args) == 2 && !force) {
+		bot.sendReply(chat_id, thread_id, message_id, SetCapUsage)
+		return
+	}
+	capacity, err := strconv.Atoi(args[0])
+	if err != nil || capacity < 0 {
+		bot.sendReply(chat_id, thread_id, message_id, SetCapUsage)
+		return
+	}
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	if !ok {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	if taken := event.takenSlots(); capacity != 0 && taken > capacity && !force {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(SetCapTooFew, taken, capacity))
+		return
+	}
+	old := event.Capacity
+	event.Capacity = capacity
+	event.audit(message, audit_capacity, fmt.Sprintf(SetCapStepDesc, capacityLabel(old), capacityLabel(capacity)))
+	demoted := demoteOverflow(event)
+	promoted := promoteNext(event, "")
+	type demotion struct {
+		user_id  json.Number
+		position int
+	}
+	var demotions []demotion
+	for _, member := range demoted {
+		if member.UserId != "" {
+			demotions = append(demotions, demotion{member.UserId, event.findWaiting(member.UserId) + 1})
+		}
+	}
+	details := formatEventDetails(event)
+	report := fmt.Sprintf(SetCapReport, event.ref(), capacityLabel(capacity), len(promoted), len(demoted))
+	notice := bot.capacityNotice(event)
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	bot.sendReply(chat_id, thread_id, message_id, report)
+	for _, d := range demotions {
+		bot.sendNotification(d.user_id, fmt.Sprintf(SetCapDemoted, event.ref(), details, localeOf(event.Locale).Ordinal(d.position)))
+	}
+	bot.notifyPromotions(promoted)
+	bot.announceCapacity(event, notice)
+}
+
+// owner is who opened the event. Events from before OwnerId was kept fall
+// back to whoever the log says created them, "" for scheduled ones.
+func (e *EventInfo) owner() json.Number {
+	if e.OwnerId != "" {
+		return e.OwnerId
+	}
+	for _, entry := range e.Log {
+		if entry.Action == audit_created {
+			return entry.ActorId
+		}
+	}
+	return ""
+}
+
+// ownerNotice DMs the owner of the event text if the chat has "ownernotify"
+// on and the owner hasn't opted out. Must be called with state_mux held.
+func (bot *Bot) ownerNotice(event *EventInfo, text string) {
+	config, ok := bot.chat_configs[event.ChatId]
+	owner := event.owner()
+	if !ok || !config.NotifyOwner || owner == "" || bot.opted_out[owner] {
+		return
+	}
+	bot.queueMessage(JsonTable{"chat_id": owner, "text": text, "parse_mode": "Markdown"}, nil)
+}
+
+// capacityNotice returns the channel announcement due after the number of
+// registrations changed, or "" if there is nothing new to announce. The full
+// notice is posted once until a slot frees up again. The owner is told the
+// same way when the event fills up and when its last registration is gone.
+// Must be called with state_mux held, before saveState.
+func (bot *Bot) capacityNotice(event *EventInfo) string {
+	switch registered := len(event.Registrations) > 0; {
+	case registered && !event.HadRegistrations:
+		event.HadRegistrations = true
+	case !registered && event.HadRegistrations:
+		event.HadRegistrations = false
+		bot.ownerNotice(event, fmt.Sprintf(OwnerEmptyMsg, event.ref()))
+	}
+	if event.Capacity == 0 {
+		return ""
+	}
+	full := event.isFull()
+	switch {
+	case full && !event.FullNoticeSent:
+		event.FullNoticeSent = true
+		bot.ownerNotice(event, fmt.Sprintf(OwnerFullMsg, event.ref(), event.Capacity))
+		return fmt.Sprintf(EventFullMsg, event.ref())
+	case !full && event.FullNoticeSent:
+		event.FullNoticeSent = false
+		if config, ok := bot.chat_configs[event.ChatId]; ok && config.AnnounceFreeSlots {
+			return fmt.Sprintf(EventSlotFreeMsg, event.ref(), event.Capacity-event.takenSlots())
+		}
+	}
+	return ""
+}
+
+func (bot *Bot) announceCapacity(event *EventInfo, notice string) {
+	if notice != "" {
+		bot.queueMessage(chatMessage(event.ChatId, event.ThreadId, notice), nil)
+	}
+}
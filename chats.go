@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	ChatsHeader = "Чаты с активными событиями (%d):"
+	ChatsLine   = "`%s` %s — событий: %d, активность: %s"
+)
+
+// ChatInfo is what the bot remembers about the chats it sees.
+type ChatInfo struct {
+	Title        string
+	LastActivity time.Time
+}
+
+// trackChat remembers the title and activity of group chats. The state is
+// only saved when the title changes, activity times are written along with
+// the next save.
+func (bot *Bot) trackChat(message JsonTable) {
+	chat := getTbl(message, "chat")
+	if chat == nil || isPrivateChat(message) {
+		return
+	}
+	chat_id := getNum(chat, "id")
+	title := getStr(chat, "title")
+
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	info, ok := bot.chat_infos[chat_id]
+	if !ok {
+		info = &ChatInfo{}
+		bot.chat_infos[chat_id] = info
+	}
+	info.LastActivity = time.Now()
+	if info.Title != title {
+		info.Title = title
+		bot.saveState()
+	}
+}
+
+func (bot *Bot) renderChats(user_id json.Number, arg string) (string, []string) {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+
+	counts := map[json.Number]int{}
+	for _, event := range bot.current_events {
+		counts[event.ChatId]++
+	}
+	chat_ids := make([]json.Number, 0, len(counts))
+	for chat_id := range counts {
+		chat_ids = append(chat_ids, chat_id)
+	}
+	sort.Slice(chat_ids, func(i, j int) bool { return chat_ids[i] < chat_ids[j] })
+
+	var lines []string
+	for _, chat_id := range chat_ids {
+		title, activity := "?", "?"
+		if info, ok := bot.chat_infos[chat_id]; ok {
+			title = escapeMarkdown(info.Title)
+			if !info.LastActivity.IsZero() {
+				activity = info.LastActivity.Format(event_time_layout)
+			}
+		}
+		lines = append(lines, fmt.Sprintf(ChatsLine, chat_id, title, counts[chat_id], activity))
+	}
+	return fmt.Sprintf(ChatsHeader, len(chat_ids)), lines
+}
+
+func (bot *Bot) listChats(ctx context.Context, message JsonTable, args []string) {
+	bot.sendPaged(getChatId(message), getSenderId(message), "chats", "")
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Middleware wraps a command handler with a cross-cutting concern. Returning
+// without calling next stops the command.
+type Middleware = func(next CommandHandler) CommandHandler
+
+// Access is who may run a command. It also decides in which command menu
+// the command is shown.
+type Access int
+
+const (
+	AccessAnyone    Access = iota
+	AccessObserver         // read-only commands, checked by requireObserver
+	AccessChatAdmin        // admins of the chat, checked by requireAdmin
+	AccessBotAdmin         // bot operators, checked by requireBotAdmin
+)
+
+// HelpSection groups the commands anyone may run in /help. Commands with
+// access restrictions are listed in the admin section.
+type HelpSection int
+
+const (
+	HelpParticipant HelpSection = iota
+	HelpInfo
+)
+
+// ChatKind is where a command may be sent.
+type ChatKind int
+
+const (
+	ChatAny     ChatKind = iota
+	ChatGroup            // groups and channels, the command works on their event
+	ChatPrivate          // the private chat with the bot
+)
+
+const (
+	GroupOnlyMsg   = "Эта команда работает только в группе, где проходит событие."
+	PrivateOnlyMsg = "Эта команда работает только в личном чате с ботом."
+)
+
+type Command struct {
+	Handler     CommandHandler
+	Middlewares []Middleware
+	Access      Access
+	Chat        ChatKind
+	Description string // shown in the command menu
+
+	// for /help: the arguments, a longer description than Description if
+	// needed and, for commands anyone may run, the section listing them
+	Args    string
+	Help    string
+	Section HelpSection
+
+	// CustomAuth means the handler checks Access itself, like /open which
+	// can target another chat
+	CustomAuth bool
+
+	// KeepOutput exempts the command from auto-deletion
+	KeepOutput bool
+
+	// Core commands can't be turned off with /disable
+	Core bool
+
+	// Reply is where respond sends the output when the command is sent to a
+	// group, commands sent to the bot privately are answered there
+	Reply ReplyTarget
+}
+
+// keepCommands drops the commands not in names, BOT_COMMANDS. Core commands
+// stay, a bot can't work without them.
+func (bot *Bot) keepCommands(names []string) error {
+	keep := map[string]bool{}
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !strings.HasPrefix(name, "/") {
+			name = "/" + name
+		}
+		if !hasCommand(bot.commands, name) {
+			return fmt.Errorf("no command %s", name)
+		}
+		keep[name] = true
+	}
+	for name, command := range bot.commands {
+		if !keep[name] && !command.Core {
+			delete(bot.commands, name)
+		}
+	}
+	return nil
+}
+
+// builtinCommands is the command set of bot, the handlers bound to it.
+func (bot *Bot) builtinCommands() map[string]Command {
+	return map[string]Command{
+		"/open": {Handler: bot.eventOpen, Access: AccessChatAdmin, CustomAuth: true, Description: "Создать событие",
+			Args: "[канал]", Help: "Создать событие, с каналом — из личного чата с ботом"},
+		"/close": {Handler: bot.eventClose, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Закрыть событие",
+			Help: "Закрыть регистрацию на событие"},
+		"/setinfo": {Handler: bot.setEventInfo, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Добавить информацию о событии",
+			Args: "<ключ>=<значение>", Help: "Добавить к событию заметку, например взнос или ссылку на регламент, пустое значение — удалить"},
+		"/setcap": {Handler: bot.setCapacity, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Изменить число мест",
+			Args: "<n> [force]", Help: "Изменить число мест текущего события, force — перевести лишних в лист ожидания"},
+		"/merge": {Handler: bot.mergeEvents, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Объединить два события",
+			Args: "<из> <в>", Help: "Перенести участников одного события в другое"},
+		"/closeall": {Handler: bot.closeAll, Chat: ChatGroup, Access: AccessChatAdmin, KeepOutput: true, Description: "Закрыть все события канала"},
+		"/poll": {Handler: bot.eventPoll, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Опрос «кто придёт»",
+			Help: "Опрос «кто придёт» по текущему событию"},
+		"/schedule": {Handler: bot.scheduleEvent, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Настроить еженедельное событие",
+			Args: "[off]", Help: "Настроить еженедельное событие, off — удалить расписание"},
+		"/notify": {Handler: bot.notifyMembers, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Разослать сообщение участникам",
+			Help: "Разослать сообщение участникам события"},
+		"/history": {Handler: bot.history, Chat: ChatGroup, Section: HelpInfo, Reply: ReplyPrivate, Description: "История событий",
+			Args: "[номер события]", Help: "Показать историю проводимых событий, с номером — состав участников события"},
+		"/log": {Handler: bot.eventLog, Chat: ChatGroup, Access: AccessObserver, Description: "Журнал действий по событию",
+			Args: "<номер>"},
+		"/calendar": {Handler: bot.showCalendar, Chat: ChatGroup, Section: HelpInfo, Description: "Календарь событий",
+			Args: "[reset]", Help: "Ссылка на календарь событий канала для подписки, reset — новая ссылка (для администраторов)"},
+		"/token": {Handler: bot.eventByToken, Section: HelpInfo, Description: "Событие по коду",
+			Args: "[код]", Help: "Найти событие по коду из ссылки или QR-кода, без кода в канале — ссылка на текущее событие"},
+		"/start": {Handler: bot.start, Core: true},
+		"/goto": {Handler: bot.gotoEvent, Chat: ChatGroup, Section: HelpInfo, Description: "Ссылка на сообщение события",
+			Args: "[номер события]", Help: "Ссылка на последнее сообщение события в канале, без номера — на все активные события"},
+		"/show": {Handler: bot.eventShow, Chat: ChatGroup, KeepOutput: true, Section: HelpInfo, Description: "Показать текущее событие",
+			Help: "Показать текущее событие и список зарегистрированных участников"},
+		"/register": {Handler: bot.register, Chat: ChatGroup, Description: "Зарегистрироваться на событие",
+			Help: "Зарегистрировать участника на текущее событие"},
+		"/reactreg": {Handler: bot.reactionRegistration, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Регистрация реакцией",
+			Args: "[off]", Help: "Опубликовать сообщение, реакция " + reaction_emoji + " на которое регистрирует, off — выключить"},
+		"/unregister": {Handler: bot.unregister, Chat: ChatGroup, Description: "Отменить регистрацию"},
+		"/editreg": {Handler: bot.editRegistration, Chat: ChatGroup, Description: "Исправить данные регистрации",
+			Help: "Исправить свои данные регистрации"},
+		"/confirm": {Handler: bot.confirmClaim, Description: "Подтвердить освободившееся место",
+			Help: "Подтвердить место, освободившееся в листе ожидания"},
+		"/cancel": {Handler: bot.cancelCommands, Core: true, Description: "Прервать свою команду",
+			Help: "Прервать свою выполняющуюся команду"},
+		"/optout": {Handler: bot.optOut, Description: "Не присылать уведомления",
+			Help: "Не присылать уведомления в личные сообщения"},
+		"/optin": {Handler: bot.optIn, Description: "Присылать уведомления",
+			Help: "Снова присылать уведомления"},
+		"/member": {Handler: bot.showMember, Chat: ChatGroup, Access: AccessChatAdmin, Reply: ReplyPrivate, Description: "Данные участника",
+			Args: "<номер или имя>", Help: "Все данные участника и его записи в журнале"},
+		"/markpaid": {Handler: bot.markPaid, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Отметить оплату",
+			Args: "<номер|имя> [сумма|-]", Help: "Отметить, что участник оплатил участие, \"-\" снимает отметку (при /set fees on)"},
+		"/unpaid": {Handler: bot.listUnpaid, Chat: ChatGroup, Access: AccessChatAdmin, Reply: ReplyPrivate, Description: "Кто не оплатил",
+			Help: "Список участников, не оплативших участие, и сумма оплат"},
+		"/remove": {Handler: bot.removeMember, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Удалить участника по номеру или имени",
+			Args: "<номер|имя>", Help: "Удалить участника по номеру из /show или по части имени"},
+		"/kick": {Handler: bot.kickMember, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Удалить автора сообщения из события",
+			Help: "Ответом на сообщение участника: удалить его из события"},
+		"/bump": {Handler: bot.bumpWaitlisted, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Поднять участника в листе ожидания",
+			Args: "<номер> [приоритет]"},
+		"/ban": {Handler: bot.banUser, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Закрыть пользователю доступ к боту",
+			Args: "[id]", Help: "Ответом на сообщение или по id: бот перестанет выполнять команды пользователя в этом канале"},
+		"/unban": {Handler: bot.unbanUser, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Вернуть пользователю доступ к боту",
+			Args: "[id]"},
+		"/import": {Handler: bot.importMembers, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Добавить участников из CSV",
+			Help: "Добавить участников из CSV-файла (Имя,Лицензия)"},
+		"/admins": {Handler: bot.listAdmins, Chat: ChatGroup, KeepOutput: true, Section: HelpInfo, Description: "Администраторы канала",
+			Help: "Показать администраторов канала"},
+		"/whoami":  {Handler: bot.whoAmI, Section: HelpInfo, Reply: ReplyPrivate, Description: "Информация о себе"},
+		"/version": {Handler: bot.versionCmd, Section: HelpInfo, Description: "Версия бота", Help: "Показать версию бота"},
+		// not advertised, see allow_seed
+		"/seed":   {Handler: bot.seedEvent, Chat: ChatGroup, Access: AccessBotAdmin, CustomAuth: true},
+		"/backup": {Handler: bot.backupState, Chat: ChatPrivate, Access: AccessBotAdmin, Description: "Снимок состояния"},
+		"/chats":  {Handler: bot.listChats, Chat: ChatPrivate, Access: AccessBotAdmin, Description: "Чаты с активными событиями"},
+		"/status": {Handler: bot.botStatus, Access: AccessObserver, Description: "Состояние бота"},
+		"/diagnose": {Handler: bot.diagnose, Chat: ChatPrivate, Access: AccessBotAdmin, Description: "Проверить настройку бота",
+			Args: "[канал]", Help: "Проверить webhook, файл состояния, часы сервера и меню команд, с каналом — ещё и права бота в нём"},
+		"/flushprompts": {Handler: bot.flushPrompts, Access: AccessBotAdmin, Description: "Сбросить ожидающие вопросы",
+			Args: "[пользователь|чат]", Help: "Отменить вопросы бота, ждущие ответа: все, одного пользователя или по командам из чата"},
+		"/maintenance": {Handler: bot.setMaintenance, Chat: ChatPrivate, Access: AccessBotAdmin, Description: "Режим обслуживания",
+			Args: "on|off", Help: "Приостановить обработку команд или возобновить её"},
+		"/usage": {Handler: bot.showUsage, Access: AccessObserver, Description: "Статистика команд",
+			Args: "[чат]"},
+		"/help":    {Handler: bot.help, Core: true, Section: HelpInfo, Reply: ReplyPrivate, Description: "Справка"},
+		"/sethelp": {Handler: bot.setHelp, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Изменить текст справки"},
+		"/template": {Handler: bot.saveTemplate, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Сохранить шаблон регистрации",
+			Args: "<название> <поле>, <поле>...", Help: "Сохранить шаблон регистрации, «-» вместо полей — удалить"},
+		"/templates": {Handler: bot.listTemplates, Chat: ChatGroup, Section: HelpInfo, Description: "Шаблоны регистрации"},
+		"/addcmd": {Handler: bot.addCustomCommand, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Добавить свою команду",
+			Args: "<команда> <текст>", Help: "Добавить команду канала, например /rules, отвечающую заданным текстом"},
+		"/delcmd": {Handler: bot.deleteCustomCommand, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Удалить свою команду",
+			Args: "<команда>"},
+		"/config": {Handler: bot.showConfig, Core: true, Chat: ChatGroup, Section: HelpInfo, Description: "Настройки канала",
+			Help: "Показать настройки канала"},
+		"/set": {Handler: bot.setConfig, Core: true, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Изменить настройку канала",
+			Args: "<параметр> <значение>"},
+		"/enable": {Handler: bot.enableCommand, Core: true, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Включить команду",
+			Args: "<команда>", Help: "Снова разрешить команду, отключённую через /disable"},
+		"/disable": {Handler: bot.disableCommand, Core: true, Chat: ChatGroup, Access: AccessChatAdmin, Description: "Отключить команду",
+			Args: "<команда>", Help: "Отключить команду бота в этом канале, она пропадёт из меню и справки"},
+	}
+}
+
+func chain(handler CommandHandler, middlewares ...Middleware) CommandHandler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// build wraps the handler into the common middlewares, the access check and
+// the command's own middlewares.
+func (c Command) build(bot *Bot) CommandHandler {
+	// applied to every command, outermost first
+	middlewares := []Middleware{bot.recoverPanic, bot.logCommand, bot.countUsage}
+	if c.Chat != ChatAny {
+		middlewares = append(middlewares, bot.requireChat(c.Chat))
+	}
+	if !c.CustomAuth {
+		switch c.Access {
+		case AccessChatAdmin:
+			middlewares = append(middlewares, bot.requireAdmin)
+		case AccessObserver:
+			middlewares = append(middlewares, bot.requireObserver)
+		case AccessBotAdmin:
+			middlewares = append(middlewares, bot.requireBotAdmin)
+		}
+	}
+	middlewares = append(middlewares, c.Middlewares...)
+	return chain(c.Handler, middlewares...)
+}
+
+func (bot *Bot) recoverPanic(next CommandHandler) CommandHandler {
+	return func(ctx context.Context, message JsonTable, args []string) {
+		defer func() {
+			if r := recover(); r != nil {
+				stack := string(debug.Stack())
+				bot.messageLogger(message).Error("command panicked", "panic", r, "stack", stack)
+				bot.countFailed(message)
+				bot.alertPanic(message, r, stack)
+			}
+		}()
+		next(ctx, message, args)
+	}
+}
+
+func (bot *Bot) logCommand(next CommandHandler) CommandHandler {
+	return func(ctx context.Context, message JsonTable, args []string) {
+		start := time.Now()
+		next(ctx, message, args)
+		bot.messageLogger(message).Info("command done", "text", getStr(message, "text"),
+			"duration", time.Since(start))
+	}
+}
+
+// requireChat rejects commands sent to the wrong kind of chat, before the
+// access check so that nobody is told they aren't an admin of their DM.
+func (bot *Bot) requireChat(kind ChatKind) Middleware {
+	return func(next CommandHandler) CommandHandler {
+		return func(ctx context.Context, message JsonTable, args []string) {
+			private := isPrivateChat(message)
+			switch {
+			case kind == ChatGroup && private:
+				bot.sendReply(getChatId(message), getThreadId(message), getNum(message, "message_id"), GroupOnlyMsg)
+			case kind == ChatPrivate && !private:
+				bot.replyPrivately(message, PrivateOnlyMsg)
+			default:
+				next(ctx, message, args)
+				return
+			}
+			bot.countDenied(message)
+		}
+	}
+}
+
+// requireAdmin lets only admins of the chat the command was sent to through.
+func (bot *Bot) requireAdmin(next CommandHandler) CommandHandler {
+	return func(ctx context.Context, message JsonTable, args []string) {
+		if bot.authorize(message) {
+			next(ctx, message, args)
+		} else {
+			bot.countDenied(message)
+		}
+	}
+}
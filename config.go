@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SetHelpAsk    = "Введите текст справки для этого канала (или \"-\", чтобы вернуть стандартный):"
+	SetHelpReport = "Текст справки обновлён."
+	SetHelpReset  = "Восстановлен стандартный текст справки."
+
+	ConfigHeader    = "Настройки канала:"
+	ConfigLine      = "`%s` = %s — %s"
+	SetUsage        = "Использование: /set <параметр> <значение>, список параметров — /config"
+	SetUnknownKey   = "Нет параметра %s, список параметров — /config"
+	SetBadValue     = "Неверное значение для `%s`: %s"
+	SetReport       = "`%s` = %s"
+	ConfigOn        = "вкл"
+	ConfigOff       = "выкл"
+	ConfigUnset     = "не задано"
+	ConfigBadBool   = "ожидается on или off"
+	ConfigBadNumber = "ожидается целое число не меньше 0"
+	ConfigBadZone   = "ожидается часовой пояс вида Europe/Moscow"
+	ConfigBadLocale = "ожидается ru или en"
+	ConfigBadPage   = "ожидается число от 1 до %d"
+)
+
+// ChatConfig holds per-chat settings changed by chat admins. The zero value
+// is the default for every field.
+type ChatConfig struct {
+	HelpText            string `json:",omitempty"`
+	ConfirmRegistration bool   `json:",omitempty"`
+	AnnounceFreeSlots   bool   `json:",omitempty"`
+	AnnounceEvents      bool   `json:",omitempty"` // echo opened/closed events to the chat
+	ExpireWaitlist      bool   `json:",omitempty"` // drop the waitlist once the event starts
+	PlainAnswers        bool   `json:",omitempty"` // answers to questions needn't be replies, see deliverPlain
+	TrackFees           bool   `json:",omitempty"` // payment marks in /show, /markpaid and /unpaid
+	NotifyOwner         bool   `json:",omitempty"` // DM the owner when an event fills up or empties
+	MinMemberDays       int    `json:",omitempty"` // 0 lets everyone register
+	DefaultCapacity     int    `json:",omitempty"` // offered when opening an event, 0 is unlimited
+	TimeZone            string `json:",omitempty"` // IANA name, the server's zone if empty
+	Locale              string `json:",omitempty"` // see locales, default_locale if empty
+	MaxSlots            int    `json:",omitempty"` // slots one registration may take, asked when above 1
+	HistoryPageSize     int    `json:",omitempty"` // events per /history page, page_size if 0
+	EventIds            string `json:",omitempty"` // event_ids_week or a prefix, global numbers if empty
+	EventSeq            int    `json:",omitempty"` // last number given with the EventIds prefix
+
+	Schedule *ScheduleTemplate `json:",omitempty"`
+
+	// registration templates by name, see templates.go
+	Templates map[string][]string `json:",omitempty"`
+	// the chat's own info commands like /rules, by name with the slash
+	CustomCommands map[string]string `json:",omitempty"`
+	// secret in the link to the chat's calendar feed, see /calendar
+	CalendarToken string `json:",omitempty"`
+	// commands turned off with /disable, by name with the slash
+	DisabledCommands map[string]bool `json:",omitempty"`
+}
+
+// getChatConfig returns a copy of the chat settings, or the defaults if the
+// chat has none.
+func (bot *Bot) getChatConfig(chat_id json.Number) ChatConfig {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	if config, ok := bot.chat_configs[chat_id]; ok {
+		return *config
+	}
+	return ChatConfig{}
+}
+
+func (bot *Bot) updateChatConfig(chat_id json.Number, update func(config *ChatConfig)) {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	config, ok := bot.chat_configs[chat_id]
+	if !ok {
+		config = &ChatConfig{}
+		bot.chat_configs[chat_id] = config
+	}
+	update(config)
+	bot.saveState()
+}
+
+// Location is the time zone event times of the chat are entered in.
+func (c ChatConfig) Location() *time.Location {
+	if c.TimeZone != "" {
+		if loc, err := time.LoadLocation(c.TimeZone); err == nil {
+			return loc
+		}
+	}
+	return time.Local
+}
+
+// ConfigKey is a setting /set can change. Set validates the value before
+// touching the config.
+type ConfigKey struct {
+	Description string
+	Get         func(c *ChatConfig) string
+	Set         func(c *ChatConfig, value string) error
+}
+
+var errBadBool = errors.New(ConfigBadBool)
+var errBadNumber = errors.New(ConfigBadNumber)
+
+func boolKey(description string, field func(c *ChatConfig) *bool) ConfigKey {
+	return ConfigKey{
+		Description: description,
+		Get: func(c *ChatConfig) string {
+			if *field(c) {
+				return ConfigOn
+			}
+			return ConfigOff
+		},
+		Set: func(c *ChatConfig, value string) error {
+			if value != "on" && value != "off" {
+				return errBadBool
+			}
+			*field(c) = value == "on"
+			return nil
+		},
+	}
+}
+
+// intKey is a non-negative number where 0 means the setting is off.
+func intKey(description string, field func(c *ChatConfig) *int) ConfigKey {
+	return ConfigKey{
+		Description: description,
+		Get: func(c *ChatConfig) string {
+			if *field(c) == 0 {
+				return ConfigOff
+			}
+			return strconv.Itoa(*field(c))
+		},
+		Set: func(c *ChatConfig, value string) error {
+			n, err := strconv.Atoi(value)
+			if value == "off" {
+				n, err = 0, nil
+			}
+			if err != nil || n < 0 {
+				return errBadNumber
+			}
+			*field(c) = n
+			return nil
+		},
+	}
+}
+
+var config_keys = map[string]ConfigKey{
+	"regconfirm": boolKey("спрашивать подтверждение при регистрации",
+		func(c *ChatConfig) *bool { return &c.ConfirmRegistration }),
+	"freeslots": boolKey("сообщать об освободившихся местах",
+		func(c *ChatConfig) *bool { return &c.AnnounceFreeSlots }),
+	"announce": boolKey("сообщать в канал об открытии и закрытии событий",
+		func(c *ChatConfig) *bool { return &c.AnnounceEvents }),
+	"waitexpiry": boolKey("очищать лист ожидания, когда событие началось",
+		func(c *ChatConfig) *bool { return &c.ExpireWaitlist }),
+	"plainreply": boolKey("принимать ответ на вопрос бота без «Ответить»",
+		func(c *ChatConfig) *bool { return &c.PlainAnswers }),
+	"fees": boolKey("учитывать оплату участия",
+		func(c *ChatConfig) *bool { return &c.TrackFees }),
+	"ownernotify": boolKey("писать организатору, когда событие заполнилось или опустело",
+		func(c *ChatConfig) *bool { return &c.NotifyOwner }),
+	"minage": intKey("минимум дней в канале для регистрации",
+		func(c *ChatConfig) *int { return &c.MinMemberDays }),
+	"capacity": intKey("число мест по умолчанию для новых событий",
+		func(c *ChatConfig) *int { return &c.DefaultCapacity }),
+	"slots": intKey("сколько мест может занять одна регистрация",
+		func(c *ChatConfig) *int { return &c.MaxSlots }),
+	"historypage": {
+		Description: "событий на странице /history",
+		Get: func(c *ChatConfig) string {
+			return strconv.Itoa(c.historyPageSize())
+		},
+		Set: func(c *ChatConfig, value string) error {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 1 || n > max_history_page {
+				return fmt.Errorf(ConfigBadPage, max_history_page)
+			}
+			c.HistoryPageSize = n
+			return nil
+		},
+	},
+	"timezone": {
+		Description: "часовой пояс времени событий",
+		Get: func(c *ChatConfig) string {
+			if c.TimeZone == "" {
+				return time.Local.String()
+			}
+			return c.TimeZone
+		},
+		Set: func(c *ChatConfig, value string) error {
+			if _, err := time.LoadLocation(value); err != nil || value == "" || value == "Local" {
+				return errors.New(ConfigBadZone)
+			}
+			c.TimeZone = value
+			return nil
+		},
+	},
+	"locale": {
+		Description: "формат дат и чисел для новых событий",
+		Get: func(c *ChatConfig) string {
+			if c.Locale == "" {
+				return default_locale
+			}
+			return c.Locale
+		},
+		Set: func(c *ChatConfig, value string) error {
+			if _, ok := locales[value]; !ok {
+				return errors.New(ConfigBadLocale)
+			}
+			c.Locale = value
+			return nil
+		},
+	},
+	"eventid": {
+		Description: "номера событий: week — по неделе, префикс — PREFIX-001, - — общие номера",
+		Get: func(c *ChatConfig) string {
+			if c.EventIds == "" {
+				return ConfigOff
+			}
+			return c.EventIds
+		},
+		Set: func(c *ChatConfig, value string) error {
+			switch {
+			case value == skip_answer:
+				value = ""
+			case value == event_ids_week:
+			case event_prefix_re.MatchString(value):
+				value = strings.ToUpper(value)
+			default:
+				return errors.New(ConfigBadEventIds)
+			}
+			c.EventIds = value
+			return nil
+		},
+	},
+	"help": {
+		Description: "текст справки, /sethelp для длинного текста, - для стандартного",
+		Get: func(c *ChatConfig) string {
+			if c.HelpText == "" {
+				return ConfigUnset
+			}
+			return escapeMarkdown(c.HelpText)
+		},
+		Set: func(c *ChatConfig, value string) error {
+			if value == skip_answer {
+				value = ""
+			}
+			c.HelpText = value
+			return nil
+		},
+	},
+}
+
+func (bot *Bot) showConfig(ctx context.Context, message JsonTable, args []string) {
+	config := bot.getChatConfig(getChatId(message))
+	names := make([]string, 0, len(config_keys))
+	for name := range config_keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	lines := []string{ConfigHeader}
+	for _, name := range names {
+		key := config_keys[name]
+		lines = append(lines, fmt.Sprintf(ConfigLine, name, key.Get(&config), key.Description))
+	}
+	bot.respond(ctx, message, strings.Join(lines, "\n"))
+}
+
+func (bot *Bot) setConfig(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	if len(args) < 2 {
+		bot.sendReply(chat_id, thread_id, message_id, SetUsage)
+		return
+	}
+	name, value := args[0], strings.Join(args[1:], " ")
+	key, ok := config_keys[name]
+	if !ok {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(SetUnknownKey, escapeMarkdown(name)))
+		return
+	}
+
+	// validated on a copy so a bad value doesn't save anything
+	config := bot.getChatConfig(chat_id)
+	if err := key.Set(&config, value); err != nil {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(SetBadValue, name, err))
+		return
+	}
+	bot.updateChatConfig(chat_id, func(config *ChatConfig) {
+		key.Set(config, value)
+	})
+	bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(SetReport, name, key.Get(&config)))
+}
+
+func (bot *Bot) setHelp(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	user_id := getSenderId(message)
+
+	text, err := bot.askText(ctx, user_id, SetHelpAsk)
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+
+	report := SetHelpReport
+	if text == skip_answer {
+		text, report = "", SetHelpReset
+	}
+	bot.updateChatConfig(chat_id, func(config *ChatConfig) {
+		config.HelpText = text
+	})
+	bot.sendPrivateMessage(user_id, report, false)
+}
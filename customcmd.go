@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	AddCmdUsage     = "Использование: /addcmd <команда> <текст>"
+	AddCmdBadName   = "Имя команды — до 32 латинских букв в нижнем регистре, цифр и _."
+	AddCmdBuiltin   = "Команда /%s уже есть у бота."
+	AddCmdTooMany   = "В канале уже %d своих команд, удалите ненужные через /delcmd."
+	AddCmdReport    = "Команда /%s сохранена."
+	DelCmdUsage     = "Использование: /delcmd <команда>"
+	DelCmdReport    = "Команда /%s удалена."
+	DelCmdNotFound  = "В канале нет команды /%s."
+	CustomCmdHeader = "Свои команды канала: %s"
+
+	max_custom_commands = 20
+)
+
+// Same rules as for bot commands in BotFather.
+var custom_command_name = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// customCommand returns the text of a chat's own command like /rules.
+func (bot *Bot) customCommand(chat_id json.Number, name string) (string, bool) {
+	text, ok := bot.getChatConfig(chat_id).CustomCommands[name]
+	return text, ok
+}
+
+// customCommandHandler replies with the text as is, it is never parsed as
+// Markdown.
+func (bot *Bot) customCommandHandler(text string) Command {
+	return Command{Handler: func(ctx context.Context, message JsonTable, args []string) {
+		bot.sendRawReply(getChatId(message), getThreadId(message), getNum(message, "message_id"), text)
+	}}
+}
+
+// splitWord splits off the first word, keeping the line breaks of the rest.
+func splitWord(text string) (string, string) {
+	text = strings.TrimLeftFunc(text, unicode.IsSpace)
+	i := strings.IndexFunc(text, unicode.IsSpace)
+	if i == -1 {
+		return text, ""
+	}
+	return text[:i], strings.TrimSpace(text[i:])
+}
+
+func replaceCustomCommand(commands map[string]string, name string, text string) map[string]string {
+	copied := map[string]string{}
+	for k, v := range commands {
+		if k != name {
+			copied[k] = v
+		}
+	}
+	if text != "" {
+		copied[name] = text
+	}
+	return copied
+}
+
+// addCustomCommand is "/addcmd <name> <text>", the text may span lines.
+// Without arguments it lists the chat's commands.
+func (bot *Bot) addCustomCommand(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	_, rest := splitWord(getStr(message, "text"))
+	name, text := splitWord(rest)
+	name = strings.TrimPrefix(name, "/")
+	if name == "" || text == "" {
+		usage := AddCmdUsage
+		if names := bot.getChatConfig(chat_id).customCommandNames(); len(names) > 0 {
+			usage += "\n" + fmt.Sprintf(CustomCmdHeader, escapeMarkdown(strings.Join(names, ", ")))
+		}
+		bot.sendReply(chat_id, thread_id, message_id, usage)
+		return
+	}
+	if !custom_command_name.MatchString(name) {
+		bot.sendReply(chat_id, thread_id, message_id, AddCmdBadName)
+		return
+	}
+	if _, builtin := bot.commands["/"+name]; builtin {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(AddCmdBuiltin, escapeMarkdown(name)))
+		return
+	}
+
+	too_many := false
+	bot.updateChatConfig(chat_id, func(config *ChatConfig) {
+		if _, exists := config.CustomCommands["/"+name]; !exists && len(config.CustomCommands) >= max_custom_commands {
+			too_many = true
+			return
+		}
+		config.CustomCommands = replaceCustomCommand(config.CustomCommands, "/"+name, text)
+	})
+	if too_many {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(AddCmdTooMany, max_custom_commands))
+		return
+	}
+	bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(AddCmdReport, escapeMarkdown(name)))
+}
+
+func (bot *Bot) deleteCustomCommand(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	if len(args) != 1 {
+		bot.sendReply(chat_id, thread_id, message_id, DelCmdUsage)
+		return
+	}
+	name := strings.TrimPrefix(args[0], "/")
+	found := false
+	bot.updateChatConfig(chat_id, func(config *ChatConfig) {
+		_, found = config.CustomCommands["/"+name]
+		config.CustomCommands = replaceCustomCommand(config.CustomCommands, "/"+name, "")
+	})
+	text := fmt.Sprintf(DelCmdReport, escapeMarkdown(name))
+	if !found {
+		text = fmt.Sprintf(DelCmdNotFound, escapeMarkdown(name))
+	}
+	bot.sendReply(chat_id, thread_id, message_id, text)
+}
+
+func (c ChatConfig) customCommandNames() []string {
+	names := make([]string, 0, len(c.CustomCommands))
+	for name := range c.CustomCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
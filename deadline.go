@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	EventOpenAskDeadline = "За сколько часов до начала закрыть регистрацию? Введите число часов, дату в формате ДД.ММ.ГГГГ ЧЧ:ММ или \"-\", чтобы принимать регистрации до закрытия события:"
+	EventOpenBadDeadline = "Введите неотрицательное число часов или дату в формате ДД.ММ.ГГГГ ЧЧ:ММ."
+	DeadlineLabel        = "Регистрация до: %s"
+	DeadlinePassedLabel  = "Регистрация закрыта"
+	RegisterClosedMsg    = "Регистрация на событие #%s закрыта %s."
+	RegistrationLocked   = "Регистрация на событие #%s закрыта."
+)
+
+// askDeadline asks when registrations close, as hours before start or a
+// time of its own. Zero when skipped, registrations are open until the event
+// is closed then.
+func (bot *Bot) askDeadline(ctx context.Context, userId json.Number, start time.Time, loc *time.Location) (time.Time, error) {
+	for {
+		text, err := bot.askText(ctx, userId, EventOpenAskDeadline)
+		if err != nil || text == skip_answer {
+			return time.Time{}, err
+		}
+		if hours, err := strconv.Atoi(text); err == nil && hours >= 0 {
+			return start.Add(-time.Duration(hours) * time.Hour), nil
+		}
+		if deadline, err := time.ParseInLocation(event_time_layout, text, loc); err == nil {
+			return deadline, nil
+		}
+		bot.sendPrivateMessage(userId, EventOpenBadDeadline, false)
+	}
+}
+
+// registrationClosed is whether the deadline of the event has passed, even if
+// lockRegistrations hasn't got to it yet.
+func (e *EventInfo) registrationClosed(now time.Time) bool {
+	return e.RegistrationLocked || (!e.RegistrationDeadline.IsZero() && !now.Before(e.RegistrationDeadline))
+}
+
+// closedReason is the answer to registering for an event past its deadline,
+// "" while registrations are open.
+func closedReason(event *EventInfo, now time.Time) string {
+	if !event.registrationClosed(now) {
+		return ""
+	}
+	return fmt.Sprintf(RegisterClosedMsg, event.ref(), event.RegistrationDeadline.Format(localeOf(event.Locale).TimeLayout))
+}
+
+func writeDeadline(b *TextBuilder, event *EventInfo) {
+	switch {
+	case event.RegistrationDeadline.IsZero():
+	case event.registrationClosed(time.Now()):
+		b.Text("\n" + DeadlinePassedLabel)
+	default:
+		b.Text("\n" + fmt.Sprintf(DeadlineLabel, event.RegistrationDeadline.Format(localeOf(event.Locale).TimeLayout)))
+	}
+}
+
+// lockRegistrations marks the events whose deadline passed as locked, once,
+// and tells the chats that asked for announcements.
+func (bot *Bot) lockRegistrations(now time.Time) {
+	var locked []*EventInfo
+	changed := false
+	bot.state_mux.Lock()
+	for _, event := range bot.current_events {
+		if event.RegistrationLocked || event.RegistrationDeadline.IsZero() || now.Before(event.RegistrationDeadline) {
+			continue
+		}
+		event.RegistrationLocked = true
+		changed = true
+		event.audit(nil, audit_locked, "")
+		if config, ok := bot.chat_configs[event.ChatId]; ok && config.AnnounceEvents {
+			locked = append(locked, event)
+		}
+	}
+	if changed {
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+
+	for _, event := range locked {
+		bot.queueMessage(chatMessage(event.ChatId, event.ThreadId, fmt.Sprintf(RegistrationLocked, event.ref())), nil)
+	}
+}
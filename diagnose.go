@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+const (
+	DiagnoseHeader = "Проверка настройки бота"
+	DiagnosePass   = "✅ "
+	DiagnoseFail   = "❌ "
+	DiagnoseHint   = "   → "
+	DiagnoseAllOk  = "Проблем не найдено."
+	DiagnoseFailed = "Проблем: %d."
+
+	CheckWebhook         = "Получение обновлений"
+	CheckWebhookPolling  = "long polling, webhook не установлен"
+	CheckWebhookSet      = "webhook %s"
+	CheckWebhookConflict = "в режиме long polling установлен webhook %s, getUpdates не работает"
+	CheckWebhookForeign  = "установлен webhook %s вместо %s"
+	CheckWebhookError    = "последняя ошибка доставки: %s"
+	HintWebhookConflict  = "Webhook установил другой экземпляр бота с этим токеном. Остановите его и перезапустите бота, он снимет webhook."
+	HintWebhookError     = "Проверьте, что адрес BOT_WEBHOOK_URL доступен из интернета и сертификат действителен."
+	CheckChat            = "Права бота в канале %s"
+	CheckChatOk          = "статус %s"
+	CheckChatAbsent      = "бот не состоит в канале"
+	CheckChatMuted       = "боту запрещено писать в канал"
+	CheckChatDelete      = "нет права удалять сообщения, а автоудаление включено"
+	CheckChatPrivacy     = "бот не видит обычные сообщения, а ответы без «Ответить» включены"
+	HintChatAbsent       = "Добавьте бота в канал."
+	HintChatMuted        = "Снимите с бота ограничения в настройках канала."
+	HintChatDelete       = "Дайте боту право удалять сообщения или отключите автоудаление (BOT_AUTO_DELETE)."
+	HintChatPrivacy      = "Сделайте бота администратором, отключите privacy mode в @BotFather или выключите /set plainreply."
+	CheckState           = "Файл состояния"
+	CheckStateOk         = "читается"
+	CheckStateError      = "не читается: %s"
+	HintState            = "Проверьте права на файл BOT_STATE_FILE. Испорченный файл можно заменить снимком: -restore <файл из /backup>."
+	CheckClock           = "Часы сервера"
+	CheckClockOk         = "расхождение с Telegram %s"
+	CheckClockSkew       = "расходятся с Telegram на %s"
+	HintClock            = "Включите синхронизацию времени (NTP): по часам открываются события по расписанию и истекают сроки."
+	CheckMenu            = "Меню команд"
+	CheckMenuOk          = "совпадает со списком команд"
+	CheckMenuDiffers     = "отличается от списка команд: %s"
+	HintMenu             = "Меню обновляется при запуске, перезапустите бота. Если оно расходится снова, его меняет другой экземпляр бота."
+	CheckFailedCall      = "не удалось проверить: %s"
+	HintFailedCall       = "Повторите /diagnose, если ошибка временная."
+	DiagnoseChatNotFound = "Канал %s не найден."
+)
+
+// how far the local clock may be from Telegram's, the delivery of the
+// message included
+const max_clock_skew = 30 * time.Second
+
+// checkResult is one line of the /diagnose checklist, with what to do about
+// it when it failed.
+type checkResult struct {
+	name   string
+	ok     bool
+	detail string
+	hint   string
+}
+
+func passed(name string, detail string) checkResult {
+	return checkResult{name: name, ok: true, detail: detail}
+}
+
+func failed(name string, detail string, hint string) checkResult {
+	return checkResult{name: name, detail: detail, hint: hint}
+}
+
+func (bot *Bot) checkWebhook() checkResult {
+	info, err := bot.apiCall("getWebhookInfo", JsonTable{})
+	if err != nil {
+		return failed(CheckWebhook, fmt.Sprintf(CheckFailedCall, err), HintFailedCall)
+	}
+	tbl := asTbl(info)
+	url := getStr(tbl, "url")
+	switch {
+	case bot.webhook_url == "" && url != "":
+		return failed(CheckWebhook, fmt.Sprintf(CheckWebhookConflict, url), HintWebhookConflict)
+	case bot.webhook_url == "":
+		return passed(CheckWebhook, CheckWebhookPolling)
+	case url != bot.webhook_url:
+		return failed(CheckWebhook, fmt.Sprintf(CheckWebhookForeign, url, bot.webhook_url), HintWebhookConflict)
+	case getStr(tbl, "last_error_message") != "":
+		return failed(CheckWebhook, fmt.Sprintf(CheckWebhookError, getStr(tbl, "last_error_message")), HintWebhookError)
+	}
+	return passed(CheckWebhook, fmt.Sprintf(CheckWebhookSet, url))
+}
+
+// checkChat looks at what the bot may do in the chat against what the
+// settings have it do there.
+func (bot *Bot) checkChat(chat string) checkResult {
+	name := fmt.Sprintf(CheckChat, chat)
+	chat_id, err := bot.resolveChat(chat)
+	if err != nil {
+		return failed(name, fmt.Sprintf(DiagnoseChatNotFound, chat), HintChatAbsent)
+	}
+	resp, err := bot.apiCall("getChatMember", JsonTable{"chat_id": chat_id, "user_id": bot.Id})
+	if err != nil {
+		return failed(name, fmt.Sprintf(CheckFailedCall, err), HintFailedCall)
+	}
+	member := asTbl(resp)
+	status := getStr(member, "status")
+	switch {
+	case status == "left" || status == "kicked":
+		return failed(name, CheckChatAbsent, HintChatAbsent)
+	case status == "restricted" && member["can_send_messages"] != true:
+		return failed(name, CheckChatMuted, HintChatMuted)
+	case bot.auto_delete_delay > 0 && member["can_delete_messages"] != true:
+		return failed(name, CheckChatDelete, HintChatDelete)
+	}
+	if bot.getChatConfig(chat_id).PlainAnswers && status != "administrator" {
+		me, err := bot.apiCall("getMe", JsonTable{})
+		if err != nil {
+			return failed(name, fmt.Sprintf(CheckFailedCall, err), HintFailedCall)
+		}
+		if asTbl(me)["can_read_all_group_messages"] != true {
+			return failed(name, CheckChatPrivacy, HintChatPrivacy)
+		}
+	}
+	return passed(name, fmt.Sprintf(CheckChatOk, status))
+}
+
+// checkState reads the saved state the way a restart would.
+func (bot *Bot) checkState() checkResult {
+	if _, err := bot.store.Load(); err != nil {
+		return failed(CheckState, fmt.Sprintf(CheckStateError, err), HintState)
+	}
+	return passed(CheckState, CheckStateOk)
+}
+
+// checkClock compares the local time with the date Telegram gave the
+// /diagnose message, which is off by the delivery time at most.
+func checkClock(message JsonTable, now time.Time) checkResult {
+	skew := now.Sub(time.Unix(getInt(message, "date"), 0)).Round(time.Second)
+	if skew.Abs() > max_clock_skew {
+		return failed(CheckClock, fmt.Sprintf(CheckClockSkew, skew), HintClock)
+	}
+	return passed(CheckClock, fmt.Sprintf(CheckClockOk, skew))
+}
+
+// checkMenu compares the command menus Telegram shows with the ones
+// setupCommandMenus would set from the registry.
+func (bot *Bot) checkMenu(user_id json.Number) checkResult {
+	scopes := []struct {
+		scope JsonTable
+		menu  []BotCommand
+	}{
+		{JsonTable{"type": "default"}, bot.menuCommands()},
+		{JsonTable{"type": "all_chat_administrators"}, bot.menuCommands(AccessObserver, AccessChatAdmin)},
+		{JsonTable{"type": "chat", "chat_id": user_id}, bot.menuCommands(AccessObserver, AccessChatAdmin, AccessBotAdmin)},
+	}
+	for _, s := range scopes {
+		resp, err := bot.apiCall("getMyCommands", JsonTable{"scope": s.scope})
+		if err != nil {
+			return failed(CheckMenu, fmt.Sprintf(CheckFailedCall, err), HintFailedCall)
+		}
+		items, _ := resp.(JsonArray)
+		var menu []BotCommand
+		for _, item := range items {
+			tbl := asTbl(item)
+			menu = append(menu, BotCommand{getStr(tbl, "command"), getStr(tbl, "description")})
+		}
+		if !reflect.DeepEqual(menu, s.menu) {
+			return failed(CheckMenu, fmt.Sprintf(CheckMenuDiffers, s.scope["type"]), HintMenu)
+		}
+	}
+	return passed(CheckMenu, CheckMenuOk)
+}
+
+// diagnose runs the self-checks and posts the checklist. "/diagnose <chat>"
+// also checks the bot's rights in that chat, the command itself only works
+// in private.
+func (bot *Bot) diagnose(ctx context.Context, message JsonTable, args []string) {
+	results := []checkResult{
+		bot.checkWebhook(),
+		bot.checkState(),
+		checkClock(message, time.Now()),
+		bot.checkMenu(getSenderId(message)),
+	}
+	if len(args) > 0 {
+		results = append(results, bot.checkChat(args[0]))
+	}
+
+	b := &TextBuilder{}
+	b.Bold(DiagnoseHeader).Line("")
+	problems := 0
+	for _, result := range results {
+		mark := DiagnosePass
+		if !result.ok {
+			mark = DiagnoseFail
+			problems++
+		}
+		b.Line(mark + result.name + ": " + result.detail)
+		if result.hint != "" {
+			b.Line(DiagnoseHint + result.hint)
+		}
+	}
+	if problems == 0 {
+		b.Text(DiagnoseAllOk)
+	} else {
+		b.Text(fmt.Sprintf(DiagnoseFailed, problems))
+	}
+	bot.respondFormatted(ctx, message, b)
+}
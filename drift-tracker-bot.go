@@ -2,13 +2,17 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
 	"strings"
-	"sync"
 	"sync/atomic"
 	"time"
 )
@@ -17,24 +21,140 @@ type JsonAny = interface{}
 type JsonTable = map[string]JsonAny
 type JsonArray = []JsonAny
 
-type TgApiError string
-
-type CommandHandler = func(message JsonTable)
-
-func (e TgApiError) Error() string {
-	return string(e)
-}
+// CommandHandler runs a command. args are the parsed words after the command.
+type CommandHandler = func(ctx context.Context, message JsonTable, args []string)
 
 type MemberRecord struct {
+	Seq     int // stable number within the event, shown by /show
 	Name    string
 	License string
+	UserId  json.Number
+
+	// capacity taken, for members entering several cars; 0 is one slot
+	Slots int `json:",omitempty"`
+
+	// answers to the event's template fields, in order
+	Fields []string `json:",omitempty"`
+
+	// payment for the event, tracked in chats with fees on; Amount is
+	// optional, in whole currency units
+	Paid   bool `json:",omitempty"`
+	Amount int  `json:",omitempty"`
+
+	// waitlist order, higher first; members with equal priority keep the
+	// order they joined in
+	Priority int `json:",omitempty"`
+
+	// set while a member promoted from the waitlist hasn't confirmed yet
+	ClaimDeadline time.Time
+	// set after /unregister, the member may still undo it until then
+	UndoDeadline time.Time `json:",omitzero"`
+}
+
+// EventKey identifies the active event slot: every forum topic of a chat can
+// have its own event. ThreadId is empty for ordinary chats.
+type EventKey struct {
+	ChatId   json.Number
+	ThreadId json.Number
+}
+
+func (k EventKey) MarshalText() ([]byte, error) {
+	if k.ThreadId == "" {
+		return []byte(k.ChatId), nil
+	}
+	return []byte(string(k.ChatId) + "/" + string(k.ThreadId)), nil
+}
+
+func (k *EventKey) UnmarshalText(text []byte) error {
+	chat, thread, _ := strings.Cut(string(text), "/")
+	k.ChatId, k.ThreadId = json.Number(chat), json.Number(thread)
+	return nil
 }
 
 type EventInfo struct {
 	Description string
 	EventId     int
+	Label       string `json:",omitempty"` // shown instead of EventId, see eventid.go
+	ChatId      json.Number
+	ThreadId    json.Number `json:",omitempty"`
+	StartTime   time.Time
+	Location    string
+	Capacity    int // 0 means unlimited
+
+	// formatting of times and numbers, copied from the chat settings when
+	// the event is opened
+	Locale string `json:",omitempty"`
+
+	// registration template, the form asks Fields instead of the license
+	Template string   `json:",omitempty"`
+	Fields   []string `json:",omitempty"`
+
+	// notes like the entry fee, set with /setinfo
+	Info map[string]string `json:",omitempty"`
+
+	// who opened the event or set up its schedule, see owner
+	OwnerId json.Number `json:",omitempty"`
+
+	FullNoticeSent bool `json:",omitempty"`
+	// whether the event had registrations when last counted, so the owner
+	// hears once that it emptied, see ownerNotice
+	HadRegistrations bool `json:",omitempty"`
+	// registrations are refused from then on, open until closed if zero
+	RegistrationDeadline time.Time
+	// set once the deadline passed and lockRegistrations logged it
+	RegistrationLocked bool `json:",omitempty"`
+	// set once the waitlist was dropped at StartTime, so it's done only once
+	WaitlistExpired bool `json:",omitempty"`
 
 	Registrations []MemberRecord
+	Waitlist      []MemberRecord
+	LastSeq       int
+
+	// RSVP poll posted with /poll, not related to registrations
+	PollId      string              `json:",omitempty"`
+	PollAnswers map[json.Number]int `json:",omitempty"`
+
+	// message members react to in order to register, see /reactreg
+	ReactionMessageId json.Number `json:",omitempty"`
+	// latest message showing the event in its chat, for /goto
+	PostMessageId json.Number `json:",omitempty"`
+	// secret of the event's t.me start link, see /token
+	Token string `json:",omitempty"`
+
+	ClosedAt time.Time
+	Log      []AuditEntry `json:",omitempty"`
+}
+
+func (e *EventInfo) nextSeq() int {
+	e.LastSeq++
+	return e.LastSeq
+}
+
+// removeSeq drops the member numbered seq from the registrations or the
+// waitlist. registered reports whether the member held a slot.
+func (e *EventInfo) removeSeq(seq int) (removed MemberRecord, registered bool, found bool) {
+	for i, member := range e.Registrations {
+		if member.Seq == seq {
+			e.Registrations = append(e.Registrations[:i], e.Registrations[i+1:]...)
+			return member, true, true
+		}
+	}
+	for i, member := range e.Waitlist {
+		if member.Seq == seq {
+			e.Waitlist = append(e.Waitlist[:i], e.Waitlist[i+1:]...)
+			return member, false, true
+		}
+	}
+	return MemberRecord{}, false, false
+}
+
+func (e *EventInfo) findMember(user_id json.Number) int {
+	for i, member := range e.Registrations {
+		if member.UserId == user_id {
+			return i
+		}
+	}
+	return -1
 }
 
 const (
@@ -42,32 +162,75 @@ const (
 	tg_api_url    = "http://83.220.168.42:8090/bot"
 	update_freq   = 0.5 // hz
 	updates_limit = 10
-)
 
-const (
-	AuthorizeErrorMsg       = "Вы должны обладать правами администратора для выполнения данной команды."
-	EventOpenAskDescription = "Введите описание планируемого события:"
-	EventOpenAlreadyExists  = "В выбранном канале уже есть активное событие. Закройте его для создания нового."
-	EventOpenReport         = "Событие #%d созданно."
-	ReplyTimoutMsg          = "Срок ожидания ответа истёк. Попробуйте выполнить операцию ещё раз."
+	startup_retries     = 5
+	startup_retry_delay = 3 * time.Second
+
+	admins_cache_ttl      = 5 * time.Minute
+	default_reply_timeout = 5 * time.Minute // how long a question waits for its answer
+
+	event_time_layout = "02.01.2006 15:04"
+	skip_answer       = "-"
 )
 
-const HelpMsg = `
-	/open - Создать событие (только для админов канала)
-	/close - Закрыть региcтрацию на событие (только для админов канала)
-	/show - Показать текущее событие и список зарегестрированных участников
-	/history - Показать историю проводимых событий
-	/register - Зарегестрировать участника на текущее событие
-	/unregister - Отменить регистрацию
-`
-
-var (
-	http_client *http.Client
-	bot_url     string
-	bot_name    string
-
-	id_counter     int32
-	current_events = map[json.Number]*EventInfo{}
+const (
+	AuthorizeErrorMsg           = "Вы должны обладать правами администратора для выполнения данной команды."
+	AuthorizeCheckFailedMsg     = "Не удалось проверить ваши права, попробуйте позже."
+	MemberUnknownMsg            = "Я не вижу вашего участия в канале — пожалуйста, сначала напишите любое сообщение в канал."
+	MemberLookupFailedMsg       = "Не удалось получить информацию об участнике, попробуйте позже."
+	EventOpenAskDescription     = "Введите описание планируемого события:"
+	EventOpenAlreadyExists      = "В выбранном канале уже есть активное событие. Закройте его для создания нового."
+	EventOpenAskStartTime       = "Введите дату и время начала в формате ДД.ММ.ГГГГ ЧЧ:ММ (или \"-\", чтобы пропустить):"
+	EventOpenAskLocation        = "Введите место проведения (или \"-\", чтобы пропустить):"
+	EventOpenAskCapacity        = "Введите максимальное число участников (или \"-\" без ограничения):"
+	EventOpenAskCapacityDefault = "Введите максимальное число участников (или \"-\", чтобы оставить %d):"
+	EventOpenBadCapacity        = "Введите положительное целое число."
+	EventOpenBadTime            = "Не удалось разобрать дату, используйте формат ДД.ММ.ГГГГ ЧЧ:ММ."
+	EventPreviewHeader          = "Так событие будет выглядеть в /show:"
+	EventPublishAsk             = "Опубликовать?"
+	EventOpenDiscarded          = "Событие не создано."
+	EventOpenReport             = "Событие #%s созданно."
+	EventOpenAnnounce           = "Открыта регистрация на событие #%s:\n\n%s\n\nРегистрация — /register."
+	EventStartLabel             = "Начало: %s"
+	EventLocationLabel          = "Место: %s"
+	NoActiveEventMsg            = "В этом канале нет активного события."
+	RegisterAskName             = "Введите имя участника:"
+	RegisterAskLicense          = "Введите номер лицензии:"
+	RegisterInProgressMsg       = "Вы уже проходите регистрацию, ответьте на вопросы в личных сообщениях."
+	RegisterConfirmAsk          = "Зарегистрироваться на событие #%s?\nИмя: %s\nЛицензия: %s"
+	RegisterDiscarded           = "Регистрация отменена."
+	RegisterAlreadyMsg          = "Вы уже зарегистрированы на событие #%s."
+	RegisterReport              = "%s зарегистрирован(а) на событие #%s."
+	RegisterConfirmDM           = "Вы зарегистрированы на событие #%s.\n\n%s\n\nВаш номер в списке: %s."
+	WaitlistReport              = "Все места заняты, %s добавлен(а) в лист ожидания события #%s."
+	WaitlistConfirmDM           = "Все места на событие #%s заняты, вы в листе ожидания.\n\n%s\n\nВаш номер в листе ожидания: %s."
+	UnregisterReport            = "%s больше не участвует в событии #%s."
+	UnregisterUndoReport        = "%s больше не участвует в событии #%s. Передумали? Место можно вернуть в течение минуты."
+	NotRegisteredMsg            = "Вы не зарегистрированы на событие #%s."
+	EventShowHeader             = "Событие #%s"
+	EventShowMembers            = "Участники (%s):"
+	EventShowWaitlist           = "Лист ожидания (%d):"
+	EventShowPriority           = " (приоритет %d)"
+	EventShowSlots              = " (мест: %d)"
+	EventShowLeaving            = " (отменяет регистрацию)"
+	RegisterAskSlots            = "Сколько мест занять, от 1 до %d? \"-\" — одно:"
+	RegisterBadSlots            = "Введите число от 1 до %d."
+	RegisterNoRoomMsg           = "На событие #%s всего %d мест."
+	RemoveUsageMsg              = "Использование: /remove <номер участника из /show или часть имени>"
+	RemoveNotFoundMsg           = "Нет участника с номером %d, номера указаны в /show."
+	RemoveNoNameMsg             = "Нет участника «%s», номера и имена указаны в /show."
+	RemovePickAsk               = "Под «%s» подходят несколько участников. Кого удалить из события #%s?"
+	RemoveCancelButton          = "Отмена"
+	RemoveCancelledMsg          = "Удаление отменено."
+	RemoveReport                = "Участник №%d %s удалён из события #%s."
+	KickUsageMsg                = "Ответьте командой /kick на сообщение участника, которого нужно удалить."
+	KickNotRegisteredMsg        = "Этот пользователь не зарегистрирован на событие #%s."
+	StartBotFirstMsg            = "Я не могу написать вам в личные сообщения. Начните чат со мной: %s, затем повторите команду."
+	ReplyTimoutMsg              = "Срок ожидания ответа истёк. Попробуйте выполнить операцию ещё раз."
+	ChatNotFoundMsg             = "Не удалось найти чат %s."
+	AdminsListHeader            = "Администраторы канала:"
+	AdminsCreatorMark           = " (создатель)"
+	AdminsFetchError            = "Не удалось получить список администраторов."
 )
 
 func toJson(obj JsonAny) string {
@@ -107,76 +270,160 @@ func getTbl(v JsonTable, key string) JsonTable {
 	return q
 }
 
-func tgApiCall(tg_func string, msg JsonTable) (JsonAny, error) {
+// asTbl is getTbl for a value itself, such as an API call result.
+func asTbl(v JsonAny) JsonTable {
+	q, _ := v.(JsonTable)
+	return q
+}
+
+func (bot *Bot) apiCall(tg_func string, msg JsonTable) (JsonAny, error) {
 	data, err := json.Marshal(msg)
 	if err != nil {
 		return nil, err
 	}
 
-	log.Printf("Call API func %v\n%s", tg_func, toJson(msg))
-	resp, err := http_client.Post(bot_url+tg_func, "application/json", bytes.NewReader(data))
+	bot.log.Info("Call API func", "api_func", tg_func, "payload", msg)
+	for attempt := 1; ; attempt++ {
+		if err := bot.breaker.Allow(); err != nil {
+			return nil, err
+		}
+		result, sent, err := bot.doApiCall(tg_func, data)
+		bot.breaker.Record(isApiFailure(err))
+		if result, migrated, err := bot.retryMigrated(tg_func, msg, err); migrated {
+			return result, err
+		}
+		if sent || attempt == api_attempts || !bot.shouldRetry(tg_func, err) {
+			return result, err
+		}
+		bot.log.Warn("API call failed, retrying", "api_func", tg_func, "attempt", attempt, "error", err)
+		time.Sleep(api_retry_delay)
+	}
+}
+
+// doApiCall makes a single request. sent is false when the call failed on the
+// transport level and may be retried.
+func (bot *Bot) doApiCall(tg_func string, data []byte) (result JsonAny, sent bool, err error) {
+	resp, err := bot.post(tg_func, data)
 	if err != nil {
-		return nil, err
+		return nil, false, transportError(tg_func, err)
 	}
+	defer resp.Body.Close()
 
 	var respJson JsonAny
 	d := json.NewDecoder(resp.Body)
 	d.UseNumber()
 	if err = d.Decode(&respJson); err != nil {
-		return nil, err
+		return nil, true, transportError(tg_func, err)
 	}
 
 	resp_tbl, ok := respJson.(JsonTable)
 	if ok != true {
-		return nil, TgApiError("non-table response")
+		return nil, true, TgApiError{Description: "non-table response"}
 	}
 
 	ok, hasOk := resp_tbl["ok"].(bool)
 	if hasOk == false {
-		return nil, TgApiError("Bad response status")
+		return nil, true, TgApiError{Description: "Bad response status"}
 	}
 
 	if ok != true {
-		return nil, TgApiError(getStr(resp_tbl, "description"))
+		description := getStr(resp_tbl, "description")
+		parameters := getTbl(resp_tbl, "parameters")
+		if new_id := getNum(parameters, "migrate_to_chat_id"); new_id != "" {
+			return nil, true, &ChatMigratedError{description, new_id}
+		}
+		return nil, true, TgApiError{
+			Code:        int(getInt(resp_tbl, "error_code")),
+			Description: description,
+			RetryAfter:  time.Duration(getInt(parameters, "retry_after")) * time.Second,
+		}
 	}
 
-	return resp_tbl["result"], err
+	return resp_tbl["result"], true, err
 }
 
-func pollMessages(offset int64) []JsonTable {
+// chat_member and message_reaction aren't delivered unless asked for
+// explicitly
+var allowed_updates = []string{"message", "callback_query", "poll_answer", "chat_member", "message_reaction"}
+
+func (bot *Bot) pollMessages(offset int64) []JsonTable {
 	var result []JsonTable
-	resp, err := tgApiCall("getUpdates",
+	resp, err := bot.apiCall("getUpdates",
 		JsonTable{
-			"offset":  offset,
-			"limit":   updates_limit,
-			"timeout": 15,
+			"offset":          offset,
+			"limit":           updates_limit,
+			"timeout":         int(poll_timeout / time.Second),
+			"allowed_updates": allowed_updates,
 		})
 
 	if err != nil {
-		log.Printf("Failed to fetch updates %v\n", err)
+		bot.log.Warn("Failed to fetch updates", "error", err)
 		return result
 	}
 
-	for _, message := range resp.(JsonArray) {
-		result = append(result, message.(JsonTable))
+	updates, ok := resp.(JsonArray)
+	if !ok {
+		bot.log.Warn("Unexpected getUpdates result", "payload", resp)
+		return result
+	}
+	for _, message := range updates {
+		if update := asTbl(message); update != nil {
+			result = append(result, update)
+		} else {
+			bot.log.Warn("Skipping malformed update", "payload", message)
+		}
 	}
 	return result
 }
 
-func sendReply(chat_id interface{}, message_id json.Number, text string) {
-	_, err := tgApiCall("sendMessage",
-		JsonTable{
-			"chat_id":             chat_id,
-			"reply_to_message_id": message_id,
-			"text":                text,
-			"parse_mode":          "Markdown",
-		})
+// sendReply replies to message_id. thread_id is the forum topic the reply
+// belongs to, empty outside of forums.
+func (bot *Bot) sendReply(chat_id interface{}, thread_id json.Number, message_id json.Number, text string) (JsonAny, error) {
+	request := replyRequest(chat_id, thread_id, message_id)
+	request["text"] = text
+	request["parse_mode"] = "Markdown"
+	return bot.postReply(request)
+}
+
+// sendRawReply sends text as is, for output that must not be parsed as
+// Markdown.
+func (bot *Bot) sendRawReply(chat_id interface{}, thread_id json.Number, message_id json.Number, text string) (JsonAny, error) {
+	request := replyRequest(chat_id, thread_id, message_id)
+	request["text"] = text
+	return bot.postReply(request)
+}
+
+// sendFormattedReply is sendReply for text built with entities instead of
+// Markdown.
+func (bot *Bot) sendFormattedReply(chat_id interface{}, thread_id json.Number, message_id json.Number, b *TextBuilder) (JsonAny, error) {
+	request := replyRequest(chat_id, thread_id, message_id)
+	request["text"], request["entities"] = b.Build()
+	return bot.postReply(request)
+}
+
+func replyRequest(chat_id interface{}, thread_id json.Number, message_id json.Number) JsonTable {
+	request := JsonTable{
+		"chat_id":             chat_id,
+		"reply_to_message_id": message_id,
+	}
+	if thread_id != "" {
+		request["message_thread_id"] = thread_id
+	}
+	return request
+}
+
+func (bot *Bot) postReply(request JsonTable) (JsonAny, error) {
+	chat_id, message_id := request["chat_id"], request["reply_to_message_id"].(json.Number)
+	resp, err := bot.apiCall("sendMessage", request)
 	if err != nil {
-		log.Printf("failed to send reply %v", err)
+		bot.log.Warn("failed to send reply", "chat_id", chat_id, "error", err)
+		return nil, err
 	}
+	bot.trackReply(chat_id, message_id, resp)
+	return resp, nil
 }
 
-func sendPrivateMessage(chat_id interface{}, text string, force_reply bool) (JsonAny, error) {
+func (bot *Bot) sendPrivateMessage(chat_id interface{}, text string, force_reply bool) (JsonAny, error) {
 	request := JsonTable{
 		"chat_id":    chat_id,
 		"text":       text,
@@ -188,16 +435,33 @@ func sendPrivateMessage(chat_id interface{}, text string, force_reply bool) (Jso
 			"force_reply": true,
 		}
 	}
+	return bot.postPrivate(request)
+}
+
+// sendRawPrivateMessage is sendPrivateMessage without Markdown parsing.
+func (bot *Bot) sendRawPrivateMessage(chat_id interface{}, text string) (JsonAny, error) {
+	return bot.postPrivate(JsonTable{"chat_id": chat_id, "text": text})
+}
+
+// sendFormattedPrivateMessage is sendPrivateMessage for text built with
+// entities.
+func (bot *Bot) sendFormattedPrivateMessage(chat_id interface{}, b *TextBuilder) (JsonAny, error) {
+	request := JsonTable{"chat_id": chat_id}
+	request["text"], request["entities"] = b.Build()
+	return bot.postPrivate(request)
+}
 
-	resp, err := tgApiCall("sendMessage", request)
+func (bot *Bot) postPrivate(request JsonTable) (JsonAny, error) {
+	chat_id := request["chat_id"]
+	resp, err := bot.apiCall("sendMessage", request)
 	if err != nil {
-		log.Printf("failed to send reply %v", err)
+		bot.log.Warn("failed to send private message", "chat_id", chat_id, "error", err)
 	}
 	return resp, err
 }
 
-func isUserAdmin(user_id json.Number, chat_id json.Number) (bool, error) {
-	resp, err := tgApiCall("getChatMember",
+func (bot *Bot) isUserAdmin(user_id json.Number, chat_id json.Number) (bool, error) {
+	resp, err := bot.apiCall("getChatMember",
 		JsonTable{
 			"chat_id": chat_id,
 			"user_id": user_id,
@@ -207,254 +471,1198 @@ func isUserAdmin(user_id json.Number, chat_id json.Number) (bool, error) {
 		return false, err
 	}
 
-	status := getStr(resp.(JsonTable), "status")
+	status := getStr(asTbl(resp), "status")
 	if status == "creator" || status == "administrator" {
 		return true, nil
 	}
 	return false, nil
 }
 
+// isChatMember reports whether user_id is in chat_id, false if that can't
+// be checked.
+func (bot *Bot) isChatMember(user_id json.Number, chat_id json.Number) bool {
+	resp, err := bot.apiCall("getChatMember", JsonTable{"chat_id": chat_id, "user_id": user_id})
+	if err != nil {
+		return false
+	}
+	status := getStr(asTbl(resp), "status")
+	return status != "left" && status != "kicked"
+}
+
+type adminsCacheEntry struct {
+	admins  JsonArray
+	fetched time.Time
+}
+
+// getChatAdmins returns the chat administrators, cached for admins_cache_ttl.
+func (bot *Bot) getChatAdmins(chat_id json.Number) (JsonArray, error) {
+	bot.admins_cache_mux.Lock()
+	entry, ok := bot.admins_cache[chat_id]
+	bot.admins_cache_mux.Unlock()
+	if ok && time.Since(entry.fetched) < admins_cache_ttl {
+		return entry.admins, nil
+	}
+
+	resp, err := bot.apiCall("getChatAdministrators", JsonTable{"chat_id": chat_id})
+	if err != nil {
+		return nil, err
+	}
+	admins, _ := resp.(JsonArray)
+
+	bot.admins_cache_mux.Lock()
+	bot.admins_cache[chat_id] = adminsCacheEntry{admins: admins, fetched: time.Now()}
+	bot.admins_cache_mux.Unlock()
+	return admins, nil
+}
+
+var markdownEscaper = strings.NewReplacer("_", "\\_", "*", "\\*", "`", "\\`", "[", "\\[")
+
+func escapeMarkdown(text string) string {
+	return markdownEscaper.Replace(text)
+}
+
+func userDisplayName(user JsonTable) string {
+	name := strings.TrimSpace(getStr(user, "first_name") + " " + getStr(user, "last_name"))
+	if name == "" {
+		name = "@" + getStr(user, "username")
+	}
+	return name
+}
+
+// resolveChat turns a numeric chat id or a public @username into a chat id.
+func (bot *Bot) resolveChat(chat string) (json.Number, error) {
+	resp, err := bot.apiCall("getChat", JsonTable{"chat_id": chat})
+	if err != nil {
+		return "", err
+	}
+	return getNum(asTbl(resp), "id"), nil
+}
+
+func isPrivateChat(message JsonTable) bool {
+	return getStr(getTbl(message, "chat"), "type") == "private"
+}
+
 func getChatId(message JsonTable) json.Number {
 	return getNum(getTbl(message, "chat"), "id")
 }
 
+// getThreadId returns the forum topic of message, or an empty number for
+// chats without topics.
+func getThreadId(message JsonTable) json.Number {
+	if message["is_topic_message"] != true {
+		return ""
+	}
+	return getNum(message, "message_thread_id")
+}
+
+// getSenderId returns the user that sent message. Messages posted on behalf
+// of a chat (anonymous admins) carry sender_chat instead, and the chat id is
+// returned so that questions and replies to the sender go to that chat.
 func getSenderId(message JsonTable) json.Number {
+	if sender_chat := getTbl(message, "sender_chat"); sender_chat != nil {
+		return getNum(sender_chat, "id")
+	}
 	return getNum(getTbl(message, "from"), "id")
 }
 
-func authorize(message JsonTable) bool {
-	chat_id := getChatId(message)
+// isAnonymousAdmin reports whether message was posted by an admin hiding
+// behind the group's own identity.
+func isAnonymousAdmin(message JsonTable) bool {
+	sender_chat := getTbl(message, "sender_chat")
+	return sender_chat != nil && getNum(sender_chat, "id") == getChatId(message)
+}
+
+func (bot *Bot) authorize(message JsonTable) bool {
+	return bot.authorizeIn(message, getChatId(message))
+}
+
+// authorizeIn checks that the sender of message is an admin of chat_id, which
+// may differ from the chat the message was sent to.
+func (bot *Bot) authorizeIn(message JsonTable, chat_id json.Number) bool {
+	if isAnonymousAdmin(message) && chat_id == getChatId(message) {
+		return true
+	}
+
 	user_id := getSenderId(message)
-	auth_ok, _ := isUserAdmin(user_id, chat_id)
+	auth_ok, err := bot.isUserAdmin(user_id, chat_id)
 	if auth_ok {
 		return true
 	}
 
-	sendPrivateMessage(user_id, AuthorizeErrorMsg, false)
+	command, _ := parseCommand(getStr(message, "text"))
+	bot.messageLogger(message).Info("command denied", "command", command, "error", err)
+	switch {
+	case isMemberNotFound(err):
+		bot.replyPrivately(message, MemberUnknownMsg)
+	case err != nil:
+		bot.replyPrivately(message, AuthorizeCheckFailedMsg)
+	default:
+		bot.replyPrivately(message, bot.deny_message)
+	}
 	return false
 }
 
-var reply_hub = map[json.Number]chan JsonAny{}
-var reply_hub_mux = sync.Mutex{}
+// replyPrivately DMs the sender of message. Users who never started the bot
+// can't be DMed, they get a short-lived reply in the chat instead.
+func (bot *Bot) replyPrivately(message JsonTable, text string) {
+	if _, err := bot.sendPrivateMessage(getSenderId(message), text, false); err == nil || isPrivateChat(message) {
+		return
+	}
+	chat_id := getChatId(message)
+	resp, err := bot.sendReply(chat_id, getThreadId(message), getNum(message, "message_id"), text)
+	if err == nil {
+		bot.deleteAfter(messageRef{chat_id, getNum(asTbl(resp), "message_id")}, ephemeral_reply_ttl)
+	}
+}
+
+// isMemberNotFound reports whether a getChatMember call failed because
+// Telegram doesn't know the user in that chat, as opposed to a generic failure.
+func isMemberNotFound(err error) bool {
+	return errors.Is(err, ErrNotFound)
+}
+
+// isDmForbidden reports whether a private message failed because the user
+// never started the bot or blocked it.
+func isDmForbidden(err error) bool {
+	return errors.Is(err, ErrForbidden)
+}
 
-func waitForReply(message_id json.Number) (JsonAny, error) {
-	reply_hub_mux.Lock()
-	ch, ok := reply_hub[message_id]
-	if ok == false {
-		ch = make(chan JsonAny)
-		reply_hub[message_id] = ch
+// waitForReply waits for user_id to answer the message_id prompt. Returns
+// ErrReplyTimeout if they don't, or the cause of ctx ending: ErrShuttingDown,
+// after telling the user to retry, ErrCancelled or ErrCommandTimeout.
+func (bot *Bot) waitForReply(ctx context.Context, user_id json.Number, message_id json.Number) (JsonAny, error) {
+	var chat_id json.Number
+	if origin := commandMessage(ctx); origin != nil {
+		chat_id = getChatId(origin)
 	}
-	reply_hub_mux.Unlock()
+	ch := bot.waiter(user_id, chat_id, message_id)
+	defer bot.stopWaiting(message_id)
 
 	select {
-	case message := <-ch:
-		reply_hub_mux.Lock()
-		delete(reply_hub, message_id)
-		reply_hub_mux.Unlock()
+	case message, ok := <-ch:
+		if !ok {
+			bot.sendPrivateMessage(user_id, PromptFlushedMsg, false)
+			return nil, ErrPromptFlushed
+		}
 		return message, nil
-	case <-time.After(5 * time.Minute):
-		reply_hub_mux.Lock()
-		delete(reply_hub, message_id)
-		reply_hub_mux.Unlock()
-		return nil, TgApiError("Reply timeout")
+	case <-time.After(bot.reply_timeout):
+		return nil, ErrReplyTimeout
+	case <-ctx.Done():
+		err := context.Cause(ctx)
+		if errors.Is(err, ErrShuttingDown) {
+			bot.sendPrivateMessage(user_id, bot.shutdown_message, false)
+		}
+		return nil, err
 	}
+}
 
-	return nil, nil
+// isReplyCommand tells a command sent as a reply, like /kick, from an answer
+// to one of the bot's questions.
+func (bot *Bot) isReplyCommand(message JsonTable) bool {
+	if !strings.HasPrefix(getStr(message, "text"), "/") {
+		return false
+	}
+	if name, _, _ := bot.commandName(message); name == "/cancel" {
+		// answering a prompt with /cancel aborts the command asking it
+		return true
+	}
+	return !bot.isWaiting(getNum(getTbl(message, "reply_to_message"), "message_id"))
 }
 
-func processReply(message JsonTable) {
-	reply_message_id := getNum(getTbl(message, "reply_to_message"), "message_id")
-	reply_hub_mux.Lock()
-	ch, ok := reply_hub[reply_message_id]
-	reply_hub_mux.Unlock()
-	if ok {
-		ch <- message
-	} else {
-		sendPrivateMessage(getSenderId(message), ReplyTimoutMsg, false)
+func (bot *Bot) processReply(message JsonTable) {
+	reply_to := getTbl(message, "reply_to_message")
+	if bot.deliverReply(getNum(reply_to, "message_id"), message) {
+		return
+	}
+	if getSenderId(reply_to) == bot.Id {
+		// only our own prompts can expire, replies to other messages in a
+		// group (where anonymous admins get their prompts) are just chatter
+		bot.sendPrivateMessage(getSenderId(message), ReplyTimoutMsg, false)
 	}
 }
 
-func askQuestion(userId json.Number, question string) (JsonAny, error) {
-	resp, err := sendPrivateMessage(userId, question, true)
+func (bot *Bot) askQuestion(ctx context.Context, userId json.Number, question string) (JsonAny, error) {
+	resp, err := bot.sendPrivateMessage(userId, question, true)
 	if err != nil {
+		// otherwise the command just stops, with no clue why
+		if origin := commandMessage(ctx); origin != nil && !isPrivateChat(origin) && isDmForbidden(err) {
+			bot.sendReply(getChatId(origin), getThreadId(origin), getNum(origin, "message_id"),
+				fmt.Sprintf(StartBotFirstMsg, escapeMarkdown("@"+bot.Name)))
+		}
 		return nil, err
 	}
 
-	message_id := getNum(resp.(JsonTable), "message_id")
-	return waitForReply(message_id)
+	message_id := getNum(asTbl(resp), "message_id")
+	if origin := commandMessage(ctx); origin != nil && bot.getChatConfig(getChatId(origin)).PlainAnswers {
+		bot.acceptPlain(message_id)
+	}
+	answer, err := bot.waitForReply(ctx, userId, message_id)
+	if err == nil {
+		// the answer is being worked on, usually until the next question
+		bot.sendTyping(userId, "")
+	}
+	return answer, err
 }
 
-func eventOpen(message JsonTable) {
-	if !authorize(message) {
-		return
+func (bot *Bot) askText(ctx context.Context, userId json.Number, question string) (string, error) {
+	answer, err := bot.askQuestion(ctx, userId, question)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(getStr(asTbl(answer), "text")), nil
+}
+
+func (bot *Bot) askStartTime(ctx context.Context, userId json.Number, loc *time.Location) (time.Time, error) {
+	for {
+		text, err := bot.askText(ctx, userId, EventOpenAskStartTime)
+		if err != nil || text == skip_answer {
+			return time.Time{}, err
+		}
+		start, err := time.ParseInLocation(event_time_layout, text, loc)
+		if err == nil {
+			return start, nil
+		}
+		bot.sendPrivateMessage(userId, EventOpenBadTime, false)
+	}
+}
+
+// askCapacity returns fallback, the chat's default, when the question is
+// skipped.
+func (bot *Bot) askCapacity(ctx context.Context, userId json.Number, fallback int) (int, error) {
+	question := EventOpenAskCapacity
+	if fallback > 0 {
+		question = fmt.Sprintf(EventOpenAskCapacityDefault, fallback)
+	}
+	for {
+		text, err := bot.askText(ctx, userId, question)
+		if err != nil || text == skip_answer {
+			return fallback, err
+		}
+		capacity, err := strconv.Atoi(text)
+		if err == nil && capacity > 0 {
+			return capacity, nil
+		}
+		bot.sendPrivateMessage(userId, EventOpenBadCapacity, false)
+	}
+}
+
+func (bot *Bot) askSlots(ctx context.Context, userId json.Number, max_slots int) (int, error) {
+	question := fmt.Sprintf(RegisterAskSlots, max_slots)
+	for {
+		text, err := bot.askText(ctx, userId, question)
+		if err != nil || text == skip_answer {
+			return 1, err
+		}
+		slots, err := strconv.Atoi(text)
+		if err == nil && slots >= 1 && slots <= max_slots {
+			return slots, nil
+		}
+		bot.sendPrivateMessage(userId, fmt.Sprintf(RegisterBadSlots, max_slots), false)
+	}
+}
+
+func formatEventDetails(event *EventInfo) string {
+	b := &TextBuilder{}
+	writeEventDetails(b, event)
+	return b.Markdown()
+}
+
+func writeEventDetails(b *TextBuilder, event *EventInfo) {
+	b.Text(event.Description)
+	if !event.StartTime.IsZero() {
+		b.Text("\n" + fmt.Sprintf(EventStartLabel, event.StartTime.Format(localeOf(event.Locale).TimeLayout)))
+	}
+	if event.Location != "" {
+		b.Text("\n" + fmt.Sprintf(EventLocationLabel, event.Location))
+	}
+	writeDeadline(b, event)
+	if event.Template != "" {
+		b.Text("\n" + fmt.Sprintf(TemplateLabel, event.Template+": "+strings.Join(event.Fields, ", ")))
+	}
+}
+
+// addEvent makes event the active one for key, assigning it a new id.
+// Returns false if key already has an active event. Must be called with
+// state_mux held.
+func (bot *Bot) addEvent(key EventKey, event *EventInfo) bool {
+	if _, ok := bot.current_events[key]; ok {
+		return false
 	}
+	event.EventId = int(atomic.AddInt32(&bot.id_counter, 1))
+	bot.assignLabel(event)
+	bot.current_events[key] = event
+	return true
+}
+
+func (bot *Bot) eventOpen(ctx context.Context, message JsonTable, args []string) {
 	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
 	user_id := getSenderId(message)
 
-	_, ok := current_events[chat_id]
+	// "/open <chat>" from a private chat opens the event in that chat
+	if len(args) > 0 {
+		target, err := bot.resolveChat(args[0])
+		if err != nil {
+			bot.messageLogger(message).Warn("failed to resolve chat", "chat", args[0], "error", err)
+			bot.sendPrivateMessage(user_id, fmt.Sprintf(ChatNotFoundMsg, escapeMarkdown(args[0])), false)
+			return
+		}
+		chat_id, thread_id = target, ""
+	}
+	if !bot.authorizeIn(message, chat_id) {
+		return
+	}
+
+	bot.state_mux.Lock()
+	_, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	bot.state_mux.Unlock()
 	if ok {
-		sendPrivateMessage(user_id, EventOpenAlreadyExists, false)
+		bot.sendPrivateMessage(user_id, EventOpenAlreadyExists, false)
+		return
+	}
+
+	desc, err := bot.askFiltered(ctx, user_id, EventOpenAskDescription)
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	bot.messageLogger(message).Info("eventOpen reply", "description", desc)
+
+	config := bot.getChatConfig(chat_id)
+	start, err := bot.askStartTime(ctx, user_id, config.Location())
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+
+	var deadline time.Time
+	if !start.IsZero() {
+		if deadline, err = bot.askDeadline(ctx, user_id, start, config.Location()); err != nil {
+			bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+			return
+		}
+	}
+
+	location, err := bot.askText(ctx, user_id, EventOpenAskLocation)
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	if location == skip_answer {
+		location = ""
+	}
+
+	capacity, err := bot.askCapacity(ctx, user_id, config.DefaultCapacity)
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
 		return
 	}
 
-	answer, err := askQuestion(user_id, EventOpenAskDescription)
+	template, fields, err := bot.askTemplate(ctx, user_id, config)
 	if err != nil {
-		log.Printf("Failed to get answer: %v", err)
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
 		return
 	}
 
-	desc := getStr(answer.(JsonTable), "text")
-	log.Printf("eventOpen reply: %v", desc)
 	newEvent := EventInfo{}
 	newEvent.Description = desc
-	newEvent.EventId = int(atomic.AddInt32(&id_counter, 1))
-	current_events[chat_id] = &newEvent
+	newEvent.ChatId = chat_id
+	newEvent.ThreadId = thread_id
+	newEvent.StartTime = start
+	newEvent.RegistrationDeadline = deadline
+	newEvent.Location = location
+	newEvent.Capacity = capacity
+	newEvent.Template = template
+	newEvent.Locale = config.Locale
+	newEvent.Fields = fields
+	newEvent.OwnerId = user_id
+
+	bot.state_mux.Lock()
+	preview := EventPreviewHeader + "\n\n" + bot.formatEventBody(&newEvent) + "\n\n" + EventPublishAsk
+	bot.state_mux.Unlock()
+	publish, err := bot.askConfirmation(ctx, user_id, preview)
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	if !publish {
+		bot.sendPrivateMessage(user_id, EventOpenDiscarded, false)
+		return
+	}
 
-	sendPrivateMessage(user_id, fmt.Sprintf(EventOpenReport, newEvent.EventId), false)
+	bot.state_mux.Lock()
+	if !bot.addEvent(EventKey{chat_id, thread_id}, &newEvent) {
+		bot.state_mux.Unlock()
+		bot.sendPrivateMessage(user_id, EventOpenAlreadyExists, false)
+		return
+	}
+	newEvent.audit(message, audit_created, "")
+	details := formatEventDetails(&newEvent)
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	bot.sendPrivateMessage(user_id, fmt.Sprintf(EventOpenReport, newEvent.ref()), false)
+	if config.AnnounceEvents {
+		bot.queueMessage(chatMessage(chat_id, thread_id, fmt.Sprintf(EventOpenAnnounce, newEvent.ref(), details)), func(result JsonAny, err error) {
+			if err == nil {
+				bot.rememberPost(&newEvent, result)
+			}
+		})
+	}
 }
 
-func eventClose(message JsonTable) {
-	if !authorize(message) {
-		return
+// writeMembers lists members the way /show does, with fees their payment
+// status.
+func writeMembers(b *TextBuilder, members []MemberRecord, fees bool) {
+	for _, member := range members {
+		b.Text(fmt.Sprintf("\n%d. %s", member.Seq, member.Name))
+		if info := member.info(); info != "" {
+			b.Text(" — " + info)
+		}
+		if fees {
+			b.Text(feeMark(member))
+		}
+		if !member.UndoDeadline.IsZero() {
+			b.Text(EventShowLeaving)
+		}
+		if member.Slots > 1 {
+			b.Text(fmt.Sprintf(EventShowSlots, member.Slots))
+		}
+		if member.Priority > 0 {
+			b.Text(fmt.Sprintf(EventShowPriority, member.Priority))
+		}
 	}
+}
 
-	//chat_id := getChatId(message)
-	user_id := getSenderId(message)
+// formatEvent renders an event the way /show displays it. Must be called with
+// state_mux held.
+func (bot *Bot) formatEvent(event *EventInfo) string {
+	b := &TextBuilder{}
+	bot.writeEvent(b, event)
+	return b.Markdown()
+}
+
+func (bot *Bot) formatEventBody(event *EventInfo) string {
+	b := &TextBuilder{}
+	bot.writeEventBody(b, event)
+	return b.Markdown()
+}
+
+func (bot *Bot) writeEvent(b *TextBuilder, event *EventInfo) {
+	b.Bold(fmt.Sprintf(EventShowHeader, event.ref())).Text("\n")
+	bot.writeEventBody(b, event)
+}
 
-	replyKeyboardMarkup := JsonTable{
-		"keyboard":        [][]string{{"YES"}, {"NO"}},
-		"resize_keyboard": true,
-		"selective":       true,
-		"force_reply":     true,
+func (bot *Bot) writeEventBody(b *TextBuilder, event *EventInfo) {
+	writeEventDetails(b, event)
+	writeEventInfo(b, event)
+
+	locale := localeOf(event.Locale)
+	count := locale.Number(event.takenSlots())
+	if event.Capacity > 0 {
+		count += "/" + locale.Number(event.Capacity)
+	}
+	fees := bot.feesEnabled(event.ChatId)
+	b.Text("\n\n" + fmt.Sprintf(EventShowMembers, count))
+	writeMembers(b, event.Registrations, fees)
+	if fees && len(event.Registrations) > 0 {
+		b.Text("\n" + feeTotals(event))
 	}
 
-	request := JsonTable{
-		"chat_id":      user_id,
-		"text":         "Yes/No",
-		"parse_mode":   "Markdown",
-		"reply_markup": replyKeyboardMarkup,
+	if len(event.Waitlist) > 0 {
+		b.Text("\n\n" + fmt.Sprintf(EventShowWaitlist, len(event.Waitlist)))
+		writeMembers(b, event.Waitlist, false)
+	}
+	if event.PollId != "" {
+		b.Text("\n\n" + formatPollTally(event))
 	}
+}
 
-	resp, err := tgApiCall("sendMessage", request)
-	if err != nil {
-		log.Printf("failed to send reply %v", err)
+func (bot *Bot) eventShow(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	b := &TextBuilder{}
+	if ok {
+		bot.writeEvent(b, event)
+	} else {
+		b.Text(NoActiveEventMsg)
 	}
+	bot.state_mux.Unlock()
 
-	log.Printf("<%s", toJson(resp))
-	message_id := getNum(resp.(JsonTable), "message_id")
-	log.Printf("wait for reply to %d", message_id)
-	reply, err := waitForReply(message_id)
+	if resp, err := bot.sendFormattedReply(chat_id, thread_id, message_id, b); err == nil && ok {
+		bot.rememberPost(event, resp)
+	}
+}
 
-	log.Printf("got reply to message %d", message_id)
-	log.Println(toJson(reply))
+// removeMember lets an admin drop a participant by the number /show displays
+// or by part of the name. A name matching several members is resolved by
+// asking the admin privately which one.
+func (bot *Bot) removeMember(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
 
-	request = JsonTable{
-		"chat_id": user_id,
-		"text":    "remove_keyboard",
-		"reply_markup": JsonTable{
-			"remove_keyboard": true,
-		},
+	if len(args) == 0 {
+		bot.sendReply(chat_id, thread_id, message_id, RemoveUsageMsg)
+		return
 	}
-	resp, err = tgApiCall("sendMessage", request)
+	query := strings.Join(args, " ")
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	if !ok {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	seq, err := strconv.Atoi(query)
+	var candidates []MemberRecord
 	if err != nil {
-		log.Println(err)
+		candidates = event.findMemberByQuery(query)
 	}
+	bot.state_mux.Unlock()
 
-	/*
-		_, ok := current_events[chat_id]
-		if !ok {
-			sendPrivateMessage(user_id, EventOpenAlreadyExists, false)
+	if err != nil {
+		switch len(candidates) {
+		case 0:
+			bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(RemoveNoNameMsg, escapeMarkdown(query)))
 			return
+		case 1:
+			seq = candidates[0].Seq
+		default:
+			question := fmt.Sprintf(RemovePickAsk, escapeMarkdown(query), event.ref())
+			if seq, err = bot.askMember(ctx, getSenderId(message), question, candidates); err != nil {
+				bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+				return
+			}
+			if seq == 0 {
+				bot.sendPrivateMessage(getSenderId(message), RemoveCancelledMsg, false)
+				return
+			}
 		}
-	*/
+	}
+
+	bot.state_mux.Lock()
+	// the event could have been closed while the admin was picking
+	if bot.current_events[EventKey{chat_id, thread_id}] != event {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	removed, promoted, notice, found := bot.dropMember(message, event, seq)
+	bot.state_mux.Unlock()
+	if !found {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(RemoveNotFoundMsg, seq))
+		return
+	}
+
+	bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(RemoveReport, seq, escapeMarkdown(removed.Name), event.ref()))
+	bot.notifyPromotions(promoted)
+	bot.announceCapacity(event, notice)
 }
 
-func eventShow(message JsonTable) {
+// kickMember removes the author of the message the admin replied to.
+func (bot *Bot) kickMember(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	// in forum topics messages that aren't replies point to the topic start
+	reply_to := getTbl(message, "reply_to_message")
+	target := getSenderId(reply_to)
+	if target == "" || target == bot.Id || hasKey(reply_to, "forum_topic_created") {
+		bot.sendReply(chat_id, thread_id, message_id, KickUsageMsg)
+		return
+	}
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	if !ok {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	var removed MemberRecord
+	var promoted []promotion
+	var notice string
+	found := false
+	if record := event.memberRecord(target); record != nil {
+		removed, promoted, notice, found = bot.dropMember(message, event, record.Seq)
+	}
+	bot.state_mux.Unlock()
+	if !found {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(KickNotRegisteredMsg, event.ref()))
+		return
+	}
+
+	bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(RemoveReport, removed.Seq, escapeMarkdown(removed.Name), event.ref()))
+	bot.notifyPromotions(promoted)
+	bot.announceCapacity(event, notice)
+}
+
+// dropMember removes the member numbered seq on behalf of an admin and
+// offers a freed slot to the waitlist. Must be called with state_mux held.
+func (bot *Bot) dropMember(message JsonTable, event *EventInfo, seq int) (removed MemberRecord, promoted []promotion, notice string, found bool) {
+	removed, registered, found := event.removeSeq(seq)
+	if !found {
+		return removed, nil, "", false
+	}
+	event.audit(message, audit_removed, removed.Name)
+	if registered {
+		promoted = promoteNext(event, "")
+	}
+	notice = bot.capacityNotice(event)
+	bot.saveState()
+	return removed, promoted, notice, true
 }
 
-func history(message JsonTable) {
+type registrationKey struct {
+	chat_id json.Number
+	user_id json.Number
 }
 
-func register(message JsonTable) {
+func (bot *Bot) lockRegistration(chat_id json.Number, user_id json.Number) bool {
+	key := registrationKey{chat_id, user_id}
+	bot.registration_locks_mux.Lock()
+	defer bot.registration_locks_mux.Unlock()
+	if bot.registration_locks[key] {
+		return false
+	}
+	bot.registration_locks[key] = true
+	return true
+}
+
+func (bot *Bot) unlockRegistration(chat_id json.Number, user_id json.Number) {
+	bot.registration_locks_mux.Lock()
+	delete(bot.registration_locks, registrationKey{chat_id, user_id})
+	bot.registration_locks_mux.Unlock()
+}
+
+func (bot *Bot) register(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	user_id := getSenderId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	if !bot.lockRegistration(chat_id, user_id) {
+		bot.sendReply(chat_id, thread_id, message_id, RegisterInProgressMsg)
+		return
+	}
+	defer bot.unlockRegistration(chat_id, user_id)
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	registered := ok && (event.findMember(user_id) != -1 || event.findWaiting(user_id) != -1)
+	var closed string
+	if ok {
+		closed = closedReason(event, time.Now())
+	}
+	bot.state_mux.Unlock()
+	if !ok {
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	if registered {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(RegisterAlreadyMsg, event.ref()))
+		return
+	}
+	if closed != "" {
+		bot.sendReply(chat_id, thread_id, message_id, closed)
+		return
+	}
+	if reason := bot.memberAgeBlock(chat_id, user_id); reason != "" {
+		bot.sendReply(chat_id, thread_id, message_id, reason)
+		return
+	}
+
+	name, err := bot.askFiltered(ctx, user_id, RegisterAskName)
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	var license string
+	var values []string
+	if len(event.Fields) > 0 {
+		values, err = bot.askFields(ctx, user_id, event.Fields)
+	} else {
+		license, err = bot.askText(ctx, user_id, RegisterAskLicense)
+	}
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+
+	config := bot.getChatConfig(chat_id)
+	slots := 1
+	if config.MaxSlots > 1 {
+		if slots, err = bot.askSlots(ctx, user_id, config.MaxSlots); err != nil {
+			bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+			return
+		}
+		if event.Capacity > 0 && slots > event.Capacity {
+			// would wait in the waitlist forever
+			bot.sendPrivateMessage(user_id, fmt.Sprintf(RegisterNoRoomMsg, event.ref(), event.Capacity), false)
+			return
+		}
+	}
+
+	if config.ConfirmRegistration {
+		question := fmt.Sprintf(RegisterConfirmAsk, event.ref(), escapeMarkdown(name), escapeMarkdown(license))
+		if len(event.Fields) > 0 {
+			var answers strings.Builder
+			for i, field := range event.Fields {
+				answers.WriteString("\n" + escapeMarkdown(field) + ": " + escapeMarkdown(values[i]))
+			}
+			question = fmt.Sprintf(RegisterConfirmFmt, event.ref(), escapeMarkdown(name), answers.String())
+		}
+		confirmed, err := bot.askConfirmation(ctx, user_id, question)
+		if err != nil || !confirmed {
+			bot.sendPrivateMessage(user_id, RegisterDiscarded, false)
+			return
+		}
+	}
+
+	bot.state_mux.Lock()
+	// the event could have been closed while we were waiting for answers
+	if bot.current_events[EventKey{chat_id, thread_id}] != event {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	if event.findMember(user_id) != -1 || event.findWaiting(user_id) != -1 {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(RegisterAlreadyMsg, event.ref()))
+		return
+	}
+	// the deadline could have passed while the user was answering
+	if closed := closedReason(event, time.Now()); closed != "" {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, closed)
+		return
+	}
+	record := MemberRecord{
+		Seq:     event.nextSeq(),
+		Name:    name,
+		License: license,
+		UserId:  user_id,
+		Fields:  values,
+	}
+	if slots > 1 {
+		record.Slots = slots
+	}
+	waitlisted := !event.fits(record)
+	var position int
+	if waitlisted {
+		position = event.addToWaitlist(record)
+		event.audit(message, audit_waitlisted, name)
+	} else {
+		event.Registrations = append(event.Registrations, record)
+		position = len(event.Registrations)
+		event.audit(message, audit_registered, name)
+	}
+	details := formatEventDetails(event)
+	notice := bot.capacityNotice(event)
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	report, confirmation := RegisterReport, RegisterConfirmDM
+	if waitlisted {
+		report, confirmation = WaitlistReport, WaitlistConfirmDM
+	}
+	bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(report, escapeMarkdown(name), event.ref()))
+	if bot.confirm_dm {
+		// the channel reply above is enough if the user can't be DMed
+		bot.sendNotification(user_id, fmt.Sprintf(confirmation, event.ref(), details, localeOf(event.Locale).Ordinal(position)))
+	}
+	bot.announceCapacity(event, notice)
 }
 
-func unregister(message JsonTable) {
+// dropUser removes the user's registration or waitlist entry and offers a
+// freed slot to the waitlist. Must be called with state_mux held.
+func (e *EventInfo) dropUser(user_id json.Number) (removed MemberRecord, promoted []promotion, found bool) {
+	if i := e.findMember(user_id); i != -1 {
+		removed = e.Registrations[i]
+		e.Registrations = append(e.Registrations[:i], e.Registrations[i+1:]...)
+		return removed, promoteNext(e, ""), true
+	}
+	if i := e.findWaiting(user_id); i != -1 {
+		removed = e.Waitlist[i]
+		e.Waitlist = append(e.Waitlist[:i], e.Waitlist[i+1:]...)
+		return removed, nil, true
+	}
+	return removed, nil, false
 }
 
-func help(message JsonTable) {
-	sendPrivateMessage(getSenderId(message), HelpMsg, false)
+func (bot *Bot) unregister(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	user_id := getSenderId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	if !ok {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+
+	// the record keeps its place until undo_window passes, see undo.go
+	record := event.memberRecord(user_id)
+	if record == nil {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(NotRegisteredMsg, event.ref()))
+		return
+	}
+	leaving := !record.UndoDeadline.IsZero()
+	if leaving {
+		// a second /unregister doesn't wait for the window
+		record.UndoDeadline = time.Now()
+	} else {
+		record.UndoDeadline = time.Now().Add(undo_window)
+		event.audit(message, audit_unregistered, record.Name)
+	}
+	name, seq := record.Name, record.Seq
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	if leaving {
+		bot.purgeUnregistered(time.Now())
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(UnregisterReport, escapeMarkdown(name), event.ref()))
+		return
+	}
+	bot.offerUndo(message, event, seq, fmt.Sprintf(UnregisterUndoReport, escapeMarkdown(name), event.ref()))
 }
 
-func whoAmI(message JsonTable) {
-	chat_id := getNum(getTbl(message, "chat"), "id")
+func (bot *Bot) listAdmins(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
 	message_id := getNum(message, "message_id")
-	resp, err := tgApiCall("getChatMember",
+
+	bot.sendTyping(chat_id, thread_id)
+	admins, err := bot.getChatAdmins(chat_id)
+	if err != nil {
+		bot.messageLogger(message).Warn("failed to get chat admins", "error", err)
+		bot.sendReply(chat_id, thread_id, message_id, AdminsFetchError)
+		return
+	}
+
+	lines := []string{AdminsListHeader}
+	for _, admin := range admins {
+		member, _ := admin.(JsonTable)
+		user := getTbl(member, "user")
+		if user == nil || user["is_bot"] == true {
+			continue
+		}
+		line := "• " + escapeMarkdown(userDisplayName(user))
+		if getStr(member, "status") == "creator" {
+			line += AdminsCreatorMark
+		}
+		lines = append(lines, line)
+	}
+	bot.sendReply(chat_id, thread_id, message_id, strings.Join(lines, "\n"))
+}
+
+func (bot *Bot) whoAmI(ctx context.Context, message JsonTable, args []string) {
+	resp, err := bot.apiCall("getChatMember",
 		JsonTable{
-			"chat_id": chat_id,
-			"user_id": getNum(getTbl(message, "from"), "id"),
+			"chat_id": getChatId(message),
+			"user_id": getSenderId(message),
 		})
-	if err == nil {
-		sendReply(chat_id, message_id, "```\n"+toJson(resp)+"```")
-	} else {
-		log.Printf("failed to get chat member %v", err)
+	switch {
+	case err == nil:
+		bot.respondFormatted(ctx, message, (&TextBuilder{}).Pre(toJson(resp), "json"))
+	case isMemberNotFound(err):
+		bot.respond(ctx, message, MemberUnknownMsg)
+	default:
+		bot.messageLogger(message).Warn("failed to get chat member", "error", err)
+		bot.respond(ctx, message, MemberLookupFailedMsg)
 	}
 }
 
-var commandHandlers = map[string]CommandHandler{
-	"/open":       eventOpen,
-	"/close":      eventClose,
-	"/history":    history,
-	"/show":       eventShow,
-	"/register":   register,
-	"/unregister": register,
-	"/whoami":     whoAmI,
-	"/help":       help,
+// commandName parses the command of a message, dropping the "@bot" suffix.
+// ok is false for commands addressed to another bot.
+func (bot *Bot) commandName(message JsonTable) (name string, args []string, ok bool) {
+	// files are sent with the command in the caption
+	name, args = parseCommand(getStr(message, "text") + getStr(message, "caption"))
+	if i := strings.Index(name, "@"); i != -1 {
+		if name[i+1:] != bot.Name {
+			return "", nil, false
+		}
+		name = name[:i]
+	}
+	return resolveAlias(name), args, true
 }
 
-func handleMessage(messageObj JsonTable) {
+func (bot *Bot) handleMessage(messageObj JsonTable) {
+	if callback := getTbl(messageObj, "callback_query"); callback != nil {
+		bot.processCallback(callback)
+		return
+	}
+	if answer := getTbl(messageObj, "poll_answer"); answer != nil {
+		bot.processPollAnswer(answer)
+		return
+	}
+	if update := getTbl(messageObj, "chat_member"); update != nil {
+		bot.processMemberUpdate(update)
+		return
+	}
+	if update := getTbl(messageObj, "message_reaction"); update != nil {
+		bot.processReaction(update)
+		return
+	}
+
 	message := getTbl(messageObj, "message")
-	if hasKey(message, "reply_to_message") {
-		processReply(message)
+	if new_id := getNum(message, "migrate_to_chat_id"); new_id != "" {
+		bot.migrateChat(getChatId(message), new_id)
+		return
+	}
+	if sender := getSenderId(message); sender != "" && sender == bot.Id {
+		bot.log.Debug("ignoring own message", "update_id", getNum(messageObj, "update_id"))
+		return
+	}
+	bot.trackChat(message)
+	bot.trackJoins(message)
+	if hasKey(message, "reply_to_message") && !bot.isReplyCommand(message) {
+		bot.processReply(message)
+	} else if isPrivateChat(message) && !strings.HasPrefix(getStr(message, "text"), "/") &&
+		bot.deliverPlain(getSenderId(message), message) {
+		return
 	} else if hasKey(message, "chat") {
-		log.Printf(toJson(messageObj))
-		text := getStr(message, "text")
+		bot.messageLogger(message).Info("incoming message", "payload", messageObj)
+		text, args, ok := bot.commandName(message)
+		if !ok {
+			return
+		}
+		bot.messageLogger(message).Info("command", "text", text)
 
-		i := strings.Index(text, "@")
-		if i != -1 {
-			botName := text[i+1:]
-			if botName != bot_name {
+		command, ok := bot.commands[text]
+		if !ok {
+			custom, found := bot.customCommand(getChatId(message), text)
+			if !found {
 				return
 			}
-			text = text[:i]
+			command = bot.customCommandHandler(custom)
+		}
+		if text != "/maintenance" && bot.isInMaintenance() {
+			// the update is still consumed, so nothing backs up meanwhile
+			bot.replyPrivately(message, bot.maintenance_message)
+			return
 		}
-		log.Printf("text %s", text)
+		if bot.isBanned(getChatId(message), getSenderId(message)) {
+			bot.messageLogger(message).Info("ignoring banned user", "text", text)
+			bot.replyPrivately(message, BannedMsg)
+			return
+		}
+		if bot.isDisabled(getChatId(message), text) {
+			bot.replyPrivately(message, fmt.Sprintf(CommandDisabled, escapeMarkdown(text)))
+			return
+		}
+		bot.runTransient(message, args, command)
+	}
+}
 
-		handler, ok := commandHandlers[text]
-		if ok {
-			handler(message)
+// newTransport builds the HTTP transport used for API calls. BOT_PROXY takes
+// precedence over the standard HTTPS_PROXY/HTTP_PROXY/NO_PROXY variables.
+// Supported proxy schemes are http, https, socks5 and socks5h.
+func newTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxy := os.Getenv("BOT_PROXY")
+	if proxy == "" {
+		return transport, nil
+	}
+
+	proxy_url, err := url.Parse(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BOT_PROXY: %w", err)
+	}
+	switch proxy_url.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme %q", proxy_url.Scheme)
+	}
+
+	slog.Info("Using proxy", "proxy", proxy_url.Redacted())
+	transport.Proxy = http.ProxyURL(proxy_url)
+	return transport, nil
+}
+
+// getMe fetches bot info, retrying a few times so a slow proxy or network
+// doesn't kill the bot on startup.
+func (bot *Bot) getMe() (JsonAny, error) {
+	var err error
+	for attempt := 1; attempt <= startup_retries; attempt++ {
+		var me JsonAny
+		if me, err = bot.apiCall("getMe", JsonTable{}); err == nil {
+			return me, nil
+		}
+		bot.log.Warn("getMe failed", "attempt", attempt, "retries", startup_retries, "error", err)
+		if attempt < startup_retries {
+			time.Sleep(startup_retry_delay)
 		}
 	}
+	return nil, err
 }
 
 func main() {
-	bot_token := os.Getenv("BOT_TOKEN")
-	bot_url = tg_api_url + bot_token + "/"
-	log.Printf("Bot url is %s", bot_url)
-	http_client = &http.Client{}
+	restore_path := flag.String("restore", "", "replace the saved state with a /backup `snapshot` before starting")
+	flag.Parse()
+	setupLogging()
+	slog.Info("Starting", "version", versionString())
 
-	me, err := tgApiCall("getMe", JsonTable{})
+	slog.Info("Bot url is " + tg_api_url + "<token>/")
+	transport, err := newTransport()
 	if err != nil {
-		log.Fatalf("Failed to get bot info: %v", err)
+		fatal("Failed to configure HTTP transport", "error", err)
+	}
+	if err = tuneTransport(transport); err != nil {
+		fatal("Failed to configure HTTP transport", "error", err)
 	}
-	log.Print(toJson(me))
-	bot_name = getStr(me.(JsonTable), "username")
+	client, long_client := newClients(transport)
 
-	updatesOffset := int64(0)
-	for {
-		for _, message := range pollMessages(updatesOffset) {
-			go handleMessage(message)
-			updatesOffset = getInt(message, "update_id") + 1
+	if tokens := os.Getenv("BOT_TOKENS"); tokens != "" {
+		if *restore_path != "" {
+			fatal("-restore works on a single bot, run it with BOT_TOKEN and that bot's BOT_STATE_FILE")
+		}
+		specs, err := parseBots(tokens)
+		if err != nil {
+			fatal("Invalid BOT_TOKENS", "error", err)
+		}
+		runBots(specs, client, long_client)
+		return
+	}
+
+	bot := newBot(tg_api_url, os.Getenv("BOT_TOKEN"), client, long_client)
+	bot.configure(os.Getenv)
+	publishStatus([]*Bot{bot})
+	go bot.run(*restore_path)
+	waitForShutdown([]*Bot{bot})
+}
+
+// configure applies the BOT_* settings looked up with getenv.
+func (bot *Bot) configure(getenv func(key string) string) {
+	var err error
+	if err := validateCommands(bot.commands); err != nil {
+		bot.fatal("Invalid command table", "error", err)
+	}
+	if value := getenv("BOT_COMMANDS"); value != "" {
+		if err := bot.keepCommands(strings.Split(value, ",")); err != nil {
+			bot.fatal("Invalid BOT_COMMANDS", "error", err)
+		}
+	}
+	if dir := getenv("BOT_FILES_DIR"); dir != "" {
+		bot.files_dir = dir
+	}
+	if dir := getenv("BOT_BACKUP_DIR"); dir != "" {
+		bot.backup_dir = dir
+	}
+	if bot.headers, err = parseHeaders(getenv("BOT_API_HEADERS")); err != nil {
+		bot.fatal("Invalid BOT_API_HEADERS", "error", err)
+	}
+	if bot.send_retry_policy, err = parseRetryPolicy(getenv("BOT_SEND_RETRY")); err != nil {
+		bot.fatal("Invalid BOT_SEND_RETRY", "error", err)
+	}
+	bot.confirm_dm = getenv("BOT_CONFIRM_DM") != "0"
+	bot.send_typing = getenv("BOT_TYPING") != "0"
+	bot.allow_seed = getenv("BOT_ALLOW_SEED") == "1"
+	if msg := getenv("BOT_DENY_MESSAGE"); msg != "" {
+		bot.deny_message = msg
+	}
+	if msg := getenv("BOT_SHUTDOWN_MESSAGE"); msg != "" {
+		bot.shutdown_message = msg
+	}
+	if msg := getenv("BOT_MAINTENANCE_MESSAGE"); msg != "" {
+		bot.maintenance_message = msg
+	}
+	bot.alert_chat = json.Number(getenv("BOT_ALERT_CHAT"))
+	bot.bot_admins = parseUserIds(getenv("BOT_ADMINS"))
+	bot.bot_observers = parseUserIds(getenv("BOT_OBSERVERS"))
+	bot.banned_words = parseBannedWords(getenv("BOT_BANNED_WORDS"))
+	if value := getenv("BOT_UPDATE_WORKERS"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			bot.fatal("Invalid BOT_UPDATE_WORKERS", "value", value)
 		}
+		bot.update_workers = make(chan struct{}, n)
+	}
+	if delay := getenv("BOT_AUTO_DELETE"); delay != "" {
+		if bot.auto_delete_delay, err = time.ParseDuration(delay); err != nil {
+			bot.fatal("Invalid BOT_AUTO_DELETE", "error", err)
+		}
+	}
+
+	state_file := getenv("BOT_STATE_FILE")
+	if state_file == "" {
+		state_file = default_state_file
+	}
+	bot.store = &FileStore{Path: state_file}
+	bot.outbox_file = getenv("BOT_OUTBOX_FILE")
+	bot.leader_lock_path = getenv("BOT_LOCK_FILE")
+	bot.health_addr = getenv("BOT_HEALTH_ADDR")
+	bot.api_token = getenv("BOT_API_TOKEN")
+	bot.calendar_url = getenv("BOT_CALENDAR_URL")
+	bot.webhook_url = getenv("BOT_WEBHOOK_URL")
+	bot.webhook_secret = getenv("BOT_WEBHOOK_SECRET")
+	bot.webhook_addr = getenv("BOT_WEBHOOK_ADDR")
+}
+
+// run brings a configured bot up: it waits for the leader lock if there is
+// one, loads the state, optionally replaced by the snapshot in restore_path,
+// and starts taking updates.
+func (bot *Bot) run(restore_path string) {
+	if bot.health_addr != "" {
+		bot.startHealthServer(bot.health_addr)
+	}
+
+	me, err := bot.getMe()
+	if err != nil {
+		bot.fatal("Failed to get bot info", "error", err)
+	}
+	bot.log.Info("Bot info", "payload", me)
+	bot.identify(me)
+
+	// a standby loads the state only once it takes over, the leader keeps
+	// changing it until then
+	if bot.leader_lock_path != "" {
+		if err := bot.acquireLeadership(bot.leader_lock_path); err != nil {
+			bot.fatal("Failed to acquire leader lock", "file", bot.leader_lock_path, "error", err)
+		}
+	}
+	if restore_path != "" {
+		if err := bot.restoreSnapshot(restore_path); err != nil {
+			bot.fatal("Failed to restore snapshot", "file", restore_path, "error", err)
+		}
+	}
+	if err := bot.loadState(); err != nil {
+		bot.fatal("Failed to load state", "error", err)
+	}
+	if bot.outbox, err = newOutbox(bot.outbox_file, bot.log); err != nil {
+		bot.fatal("Failed to load outbox", "error", err)
+	}
+	go bot.outbox.run(bot)
+	bot.setupCommandMenus()
+	go bot.claimWatcher()
+	go bot.scheduler()
+
+	if err := bot.setupWebhook(); err != nil {
+		bot.fatal("Failed to set up webhook", "error", err)
+	}
+	if bot.webhook_url != "" {
+		bot.serveWebhook()
+	} else {
+		go bot.pollUpdates()
+	}
+}
+
+func (bot *Bot) pollUpdates() {
+	updatesOffset := int64(0)
+	for !bot.isShuttingDown() {
+		updatesOffset = bot.dispatchUpdates(bot.pollMessages(updatesOffset), updatesOffset)
 		time.Sleep((1000 / update_freq) * time.Millisecond)
 	}
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+func TestMemberSeq(t *testing.T) {
+	// "+name" registers, "~name" joins the waitlist, "-N" removes member N
+	tests := []struct {
+		name          string
+		steps         []string
+		registrations []int
+		waitlist      []int
+	}{
+		{"numbered in order", []string{"+a", "+b", "+c"}, []int{1, 2, 3}, nil},
+		{"freed number not reused", []string{"+a", "+b", "-2", "+c"}, []int{1, 3}, nil},
+		{"last number freed", []string{"+a", "-1", "+b", "-2", "+c"}, []int{3}, nil},
+		{"order kept after removal", []string{"+a", "+b", "+c", "-1", "+d"}, []int{2, 3, 4}, nil},
+		{"shared with the waitlist", []string{"+a", "~b", "+c", "-2", "~d"}, []int{1, 3}, []int{4}},
+		{"unknown number", []string{"+a", "-5", "+b"}, []int{1, 2}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			event := &EventInfo{}
+			for _, step := range test.steps {
+				switch step[0] {
+				case '+':
+					event.Registrations = append(event.Registrations, MemberRecord{Seq: event.nextSeq(), Name: step[1:]})
+				case '~':
+					event.Waitlist = append(event.Waitlist, MemberRecord{Seq: event.nextSeq(), Name: step[1:]})
+				case '-':
+					seq, _ := strconv.Atoi(step[1:])
+					removed, _, found := event.removeSeq(seq)
+					if found && removed.Seq != seq {
+						t.Fatalf("%s removed member %d", step, removed.Seq)
+					}
+				}
+			}
+			if got := seqs(event.Registrations); !reflect.DeepEqual(got, test.registrations) {
+				t.Errorf("registrations %v, want %v", got, test.registrations)
+			}
+			if got := seqs(event.Waitlist); !reflect.DeepEqual(got, test.waitlist) {
+				t.Errorf("waitlist %v, want %v", got, test.waitlist)
+			}
+		})
+	}
+}
+
+func TestRemoveSeq(t *testing.T) {
+	event := &EventInfo{}
+	event.Registrations = []MemberRecord{{Seq: event.nextSeq(), Name: "a"}}
+	event.Waitlist = []MemberRecord{{Seq: event.nextSeq(), Name: "b"}}
+
+	if removed, registered, found := event.removeSeq(2); !found || registered || removed.Name != "b" {
+		t.Errorf("removeSeq(2) = %v, %v, %v; want b from the waitlist", removed, registered, found)
+	}
+	if removed, registered, found := event.removeSeq(1); !found || !registered || removed.Name != "a" {
+		t.Errorf("removeSeq(1) = %v, %v, %v; want a from the registrations", removed, registered, found)
+	}
+	if _, _, found := event.removeSeq(1); found {
+		t.Error("removeSeq(1) found a member removed already")
+	}
+}
+
+// Numbers survive a restart: loading the state must not renumber members
+// after a removal left a gap.
+func TestMemberSeqReload(t *testing.T) {
+	t.Parallel()
+	bot, _ := newTestBot(t)
+	event := &EventInfo{EventId: 1, ChatId: "1"}
+	for _, name := range []string{"a", "b", "c"} {
+		event.Registrations = append(event.Registrations, MemberRecord{Seq: event.nextSeq(), Name: name})
+	}
+	event.removeSeq(1)
+
+	bot.state_mux.Lock()
+	bot.current_events[EventKey{ChatId: "1"}] = event
+	bot.saveState()
+	bot.state_mux.Unlock()
+	if err := bot.loadState(); err != nil {
+		t.Fatal(err)
+	}
+
+	bot.state_mux.Lock()
+	loaded := bot.current_events[EventKey{ChatId: "1"}]
+	bot.state_mux.Unlock()
+	if got := seqs(loaded.Registrations); !reflect.DeepEqual(got, []int{2, 3}) {
+		t.Errorf("registrations %v after reload, want [2 3]", got)
+	}
+	if seq := loaded.nextSeq(); seq != 4 {
+		t.Errorf("next number %d after reload, want 4", seq)
+	}
+}
+
+func seqs(list []MemberRecord) []int {
+	var result []int
+	for _, member := range list {
+		result = append(result, member.Seq)
+	}
+	return result
+}
+
+// getUpdates answering null, an object or an array of junk is an empty
+// batch: nothing panics and the offset stays where it was.
+func TestPollMalformedResult(t *testing.T) {
+	for _, result := range []string{`null`, `{}`, `"updates"`, `[null,1,"x",[]]`} {
+		t.Run(result, func(t *testing.T) {
+			t.Parallel()
+			bot, api := newTestBot(t)
+			api.answer("getUpdates", result)
+
+			updates := bot.pollMessages(7)
+			if len(updates) != 0 {
+				t.Errorf("bot.pollMessages returned %v", updates)
+			}
+			if offset := bot.dispatchUpdates(updates, 7); offset != 7 {
+				t.Errorf("offset moved to %d", offset)
+			}
+			if calls := api.sent("getUpdates"); len(calls) != 1 || getInt(calls[0], "offset") != 7 {
+				t.Errorf("getUpdates called with %v", calls)
+			}
+		})
+	}
+}
+
+// Imported members have no user id, their position is found by number.
+func TestAddToWaitlistPosition(t *testing.T) {
+	event := &EventInfo{}
+	for i, priority := range []int{0, 0, 1, 0} {
+		member := MemberRecord{Seq: event.nextSeq(), Name: strconv.Itoa(i), Priority: priority}
+		want := []int{1, 2, 1, 4}[i]
+		if position := event.addToWaitlist(member); position != want {
+			t.Errorf("member %d with priority %d at position %d, want %d", i, priority, position, want)
+		}
+	}
+	if got := seqs(event.Waitlist); !reflect.DeepEqual(got, []int{3, 1, 2, 4}) {
+		t.Errorf("waitlist %v, want [3 1 2 4]", got)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+)
+
+const (
+	EditRegAskName    = "Имя участника сейчас: %s\nВведите новое имя или \"-\", чтобы оставить как есть:"
+	EditRegAskLicense = "Номер лицензии сейчас: %s\nВведите новый номер или \"-\", чтобы оставить как есть:"
+	EditRegReport     = "Регистрация на событие #%s обновлена: %s — %s."
+	EditRegUnchanged  = "Данные регистрации не изменились."
+)
+
+// memberRecord returns the registration or waitlist entry of the user. Must
+// be called with state_mux held.
+func (e *EventInfo) memberRecord(user_id json.Number) *MemberRecord {
+	if i := e.findMember(user_id); i != -1 {
+		return &e.Registrations[i]
+	}
+	if i := e.findWaiting(user_id); i != -1 {
+		return &e.Waitlist[i]
+	}
+	return nil
+}
+
+// editRegistration lets a registered user correct their own details,
+// keeping their place and number.
+func (bot *Bot) editRegistration(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	user_id := getSenderId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	if !bot.lockRegistration(chat_id, user_id) {
+		bot.sendReply(chat_id, thread_id, message_id, RegisterInProgressMsg)
+		return
+	}
+	defer bot.unlockRegistration(chat_id, user_id)
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	var current MemberRecord
+	found := false
+	if ok {
+		if record := event.memberRecord(user_id); record != nil {
+			current, found = *record, true
+		}
+	}
+	bot.state_mux.Unlock()
+	if !ok {
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	if !found {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(NotRegisteredMsg, event.ref()))
+		return
+	}
+
+	name, err := bot.askFiltered(ctx, user_id, fmt.Sprintf(EditRegAskName, escapeMarkdown(current.Name)))
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	if name == skip_answer {
+		name = current.Name
+	}
+	license := current.License
+	values := slices.Clone(current.Fields)
+	if len(event.Fields) > 0 {
+		values = append(values, make([]string, len(event.Fields)-len(values))...)
+		for i, field := range event.Fields {
+			value, err := bot.askText(ctx, user_id, fmt.Sprintf(EditRegAskField, escapeMarkdown(field), escapeMarkdown(values[i])))
+			if err != nil {
+				bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+				return
+			}
+			if value != skip_answer {
+				values[i] = value
+			}
+		}
+	} else {
+		license, err = bot.askText(ctx, user_id, fmt.Sprintf(EditRegAskLicense, escapeMarkdown(current.License)))
+		if err != nil {
+			bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+			return
+		}
+		if license == skip_answer {
+			license = current.License
+		}
+	}
+	edited := MemberRecord{Name: name, License: license, Fields: values}
+	if name == current.Name && edited.info() == current.info() {
+		bot.sendPrivateMessage(user_id, EditRegUnchanged, false)
+		return
+	}
+
+	bot.state_mux.Lock()
+	// the event could have been closed, or the user removed, meanwhile
+	var record *MemberRecord
+	if bot.current_events[EventKey{chat_id, thread_id}] == event {
+		record = event.memberRecord(user_id)
+	}
+	if record == nil {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(NotRegisteredMsg, event.ref()))
+		return
+	}
+	event.audit(message, audit_edited, fmt.Sprintf("%s — %s → %s — %s",
+		record.Name, record.info(), name, edited.info()))
+	record.Name, record.License, record.Fields = name, license, edited.Fields
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	bot.sendReply(chat_id, thread_id, message_id,
+		fmt.Sprintf(EditRegReport, event.ref(), escapeMarkdown(name), escapeMarkdown(edited.info())))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"unicode/utf16"
+)
+
+type textSpan struct {
+	kind  string // entity type, empty for plain text
+	text  string
+	extra JsonTable
+}
+
+// TextBuilder accumulates message text together with its formatting, so user
+// supplied text never has to be escaped. Build produces the text and the
+// entities array for sendMessage, Markdown renders the same message for the
+// places that still send Markdown.
+type TextBuilder struct {
+	spans []textSpan
+}
+
+func (b *TextBuilder) Text(text string) *TextBuilder {
+	b.spans = append(b.spans, textSpan{text: text})
+	return b
+}
+
+func (b *TextBuilder) Line(text string) *TextBuilder {
+	return b.Text(text + "\n")
+}
+
+func (b *TextBuilder) Bold(text string) *TextBuilder {
+	b.spans = append(b.spans, textSpan{kind: "bold", text: text})
+	return b
+}
+
+func (b *TextBuilder) Code(text string) *TextBuilder {
+	b.spans = append(b.spans, textSpan{kind: "code", text: text})
+	return b
+}
+
+func (b *TextBuilder) Pre(text string, language string) *TextBuilder {
+	span := textSpan{kind: "pre", text: text}
+	if language != "" {
+		span.extra = JsonTable{"language": language}
+	}
+	b.spans = append(b.spans, span)
+	return b
+}
+
+// Mention links text to a user, which also works for users without a
+// username.
+func (b *TextBuilder) Mention(text string, user_id json.Number) *TextBuilder {
+	b.spans = append(b.spans, textSpan{kind: "text_mention", text: text,
+		extra: JsonTable{"user": JsonTable{"id": user_id}}})
+	return b
+}
+
+func utf16Len(text string) int {
+	return len(utf16.Encode([]rune(text)))
+}
+
+// Build returns the text and its entities. Offsets are in UTF-16 code units
+// as the Bot API expects.
+func (b *TextBuilder) Build() (string, JsonArray) {
+	var text strings.Builder
+	entities := JsonArray{}
+	offset := 0
+	for _, span := range b.spans {
+		length := utf16Len(span.text)
+		if span.kind != "" && length > 0 {
+			entity := JsonTable{"type": span.kind, "offset": offset, "length": length}
+			for k, v := range span.extra {
+				entity[k] = v
+			}
+			entities = append(entities, entity)
+		}
+		text.WriteString(span.text)
+		offset += length
+	}
+	return text.String(), entities
+}
+
+// Markdown renders the message for parse_mode Markdown. Markdown can't escape
+// inside entities, so formatted spans should not contain user input.
+func (b *TextBuilder) Markdown() string {
+	var text strings.Builder
+	for _, span := range b.spans {
+		switch span.kind {
+		case "bold":
+			text.WriteString("*" + span.text + "*")
+		case "code":
+			text.WriteString("`" + span.text + "`")
+		case "pre":
+			text.WriteString("```\n" + span.text + "\n```")
+		case "text_mention":
+			user_id := getNum(span.extra["user"].(JsonTable), "id")
+			text.WriteString("[" + escapeMarkdown(span.text) + "](tg://user?id=" + string(user_id) + ")")
+		default:
+			text.WriteString(escapeMarkdown(span.text))
+		}
+	}
+	return text.String()
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// Kinds of failures callers act on, test for them with errors.Is.
+var (
+	ErrForbidden   = errors.New("forbidden")    // blocked by the user, kicked from the chat, no rights
+	ErrRateLimited = errors.New("rate limited") // see TgApiError.RetryAfter
+	ErrNotFound    = errors.New("not found")    // no such chat, user or message
+	ErrTimeout     = errors.New("timeout")      // the request didn't complete in time
+)
+
+// TgApiError is a call the API answered with ok=false.
+type TgApiError struct {
+	Code        int // error_code, 0 for malformed responses
+	Description string
+	RetryAfter  time.Duration // for ErrRateLimited
+}
+
+func (e TgApiError) Error() string {
+	return e.Description
+}
+
+// Unwrap maps the error code, and for 400 the description, to a sentinel.
+func (e TgApiError) Unwrap() error {
+	switch e.Code {
+	case 403:
+		return ErrForbidden
+	case 429:
+		return ErrRateLimited
+	case 404:
+		return ErrNotFound
+	case 400:
+		description := strings.ToLower(e.Description)
+		if strings.Contains(description, "not found") || strings.Contains(description, "participant_id_invalid") {
+			return ErrNotFound
+		}
+	}
+	return nil
+}
+
+// transportError wraps an error of the HTTP request for tg_func, marking
+// timeouts with ErrTimeout.
+func transportError(tg_func string, err error) error {
+	var net_err net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &net_err) && net_err.Timeout()) {
+		return fmt.Errorf("%s: %w: %w", tg_func, ErrTimeout, err)
+	}
+	return fmt.Errorf("%s: %w", tg_func, err)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const ConfigBadEventIds = "ожидается week, префикс из латинских букв или - для номеров"
+
+// event_ids_week labels events by the ISO week they start in, like 2024W15
+const event_ids_week = "week"
+
+var event_prefix_re = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9]{0,9}$`)
+
+// ref is how the event is shown to users and referred to in commands: its
+// label if the chat gives events one, the global number otherwise.
+func (e *EventInfo) ref() string {
+	if e.Label != "" {
+		return e.Label
+	}
+	return strconv.Itoa(e.EventId)
+}
+
+// labelTaken reports whether an event of the chat, active or closed, is
+// already shown as label. Must be called with state_mux held.
+func (bot *Bot) labelTaken(chat_id json.Number, label string) bool {
+	for _, events := range [][]*EventInfo{bot.chatEvents(chat_id), bot.chat_history[chat_id]} {
+		for _, event := range events {
+			if strings.EqualFold(event.ref(), label) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// assignLabel gives a new event the label the chat's EventIds setting asks
+// for, adding -2, -3... if it's taken. Must be called with state_mux held.
+func (bot *Bot) assignLabel(event *EventInfo) {
+	config, ok := bot.chat_configs[event.ChatId]
+	if !ok || config.EventIds == "" {
+		return
+	}
+	var label string
+	if config.EventIds == event_ids_week {
+		start := event.StartTime
+		if start.IsZero() {
+			start = time.Now()
+		}
+		year, week := start.In(config.Location()).ISOWeek()
+		label = fmt.Sprintf("%dW%02d", year, week)
+	} else {
+		config.EventSeq++
+		label = fmt.Sprintf("%s-%03d", config.EventIds, config.EventSeq)
+	}
+	unique := label
+	for n := 2; bot.labelTaken(event.ChatId, unique); n++ {
+		unique = fmt.Sprintf("%s-%d", label, n)
+	}
+	event.Label = unique
+}
+
+func matchRef(events []*EventInfo, ref string) *EventInfo {
+	ref = strings.TrimPrefix(ref, "#")
+	for _, event := range events {
+		if strings.EqualFold(event.ref(), ref) || strconv.Itoa(event.EventId) == ref {
+			return event
+		}
+	}
+	return nil
+}
+
+// findEventRef looks an event of the chat up by what users see of it, the
+// label or the number, with or without #. Active events come first. Must be
+// called with state_mux held.
+func (bot *Bot) findEventRef(chat_id json.Number, ref string) *EventInfo {
+	if event := bot.findActiveRef(chat_id, ref); event != nil {
+		return event
+	}
+	return matchRef(bot.chat_history[chat_id], ref)
+}
+
+// findActiveRef is findEventRef among the active events only.
+func (bot *Bot) findActiveRef(chat_id json.Number, ref string) *EventInfo {
+	return matchRef(bot.chatEvents(chat_id), ref)
+}
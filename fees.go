@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	FeePaidMark     = " 💰"
+	FeeUnpaidMark   = " ⏳"
+	FeeTotals       = "Оплатили: %d из %d"
+	FeeTotalsAmount = ", собрано: %s"
+
+	FeesOffMsg       = "Учёт оплаты в канале выключен, включите его: /set fees on"
+	MarkPaidUsage    = "Использование: /markpaid <номер или часть имени> [сумма], \"-\" вместо суммы снимает отметку."
+	MarkPaidNoName   = "В событии нет участника \"%s\"."
+	MarkPaidPickAsk  = "Кто из участников события #%s оплатил?"
+	MarkPaidCancel   = "Отметка об оплате отменена."
+	MarkPaidNotFound = "В событии нет участника с номером %d."
+	MarkPaidReport   = "%d. %s — оплачено в событии #%s."
+	MarkUnpaidReport = "%d. %s — отметка об оплате снята в событии #%s."
+	UnpaidHeader     = "Не оплатили участие в событии #%s:"
+	UnpaidNobody     = "Все участники события #%s оплатили участие."
+)
+
+// feesEnabled is whether the chat tracks payments. Must be called with
+// state_mux held.
+func (bot *Bot) feesEnabled(chat_id json.Number) bool {
+	config, ok := bot.chat_configs[chat_id]
+	return ok && config.TrackFees
+}
+
+// memberBySeq returns the registration or waitlist entry numbered seq.
+func (e *EventInfo) memberBySeq(seq int) *MemberRecord {
+	for _, list := range [][]MemberRecord{e.Registrations, e.Waitlist} {
+		for i := range list {
+			if list[i].Seq == seq {
+				return &list[i]
+			}
+		}
+	}
+	return nil
+}
+
+func feeMark(member MemberRecord) string {
+	if !member.Paid {
+		return FeeUnpaidMark
+	}
+	if member.Amount > 0 {
+		return FeePaidMark + " " + strconv.Itoa(member.Amount)
+	}
+	return FeePaidMark
+}
+
+// feeTotals counts the registered members who paid and what they paid.
+func feeTotals(event *EventInfo) string {
+	paid, amount := 0, 0
+	for _, member := range event.Registrations {
+		if member.Paid {
+			paid++
+			amount += member.Amount
+		}
+	}
+	text := fmt.Sprintf(FeeTotals, paid, len(event.Registrations))
+	if amount > 0 {
+		text += fmt.Sprintf(FeeTotalsAmount, localeOf(event.Locale).Number(amount))
+	}
+	return text
+}
+
+// parseFee splits "/markpaid" arguments into the member and the amount,
+// which comes last if given. paid is false for "-".
+func parseFee(args []string) (query string, amount int, paid bool) {
+	last := args[len(args)-1]
+	if len(args) > 1 {
+		if last == skip_answer {
+			return strings.Join(args[:len(args)-1], " "), 0, false
+		}
+		if n, err := strconv.Atoi(last); err == nil && n >= 0 {
+			return strings.Join(args[:len(args)-1], " "), n, true
+		}
+	}
+	return strings.Join(args, " "), 0, true
+}
+
+func (bot *Bot) markPaid(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	if len(args) == 0 {
+		bot.sendReply(chat_id, thread_id, message_id, MarkPaidUsage)
+		return
+	}
+	query, amount, paid := parseFee(args)
+
+	bot.state_mux.Lock()
+	enabled := bot.feesEnabled(chat_id)
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	var candidates []MemberRecord
+	if ok {
+		candidates = event.findMemberByQuery(query)
+	}
+	bot.state_mux.Unlock()
+	switch {
+	case !enabled:
+		bot.sendReply(chat_id, thread_id, message_id, FeesOffMsg)
+		return
+	case !ok:
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+
+	var seq int
+	switch len(candidates) {
+	case 0:
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(MarkPaidNoName, escapeMarkdown(query)))
+		return
+	case 1:
+		seq = candidates[0].Seq
+	default:
+		var err error
+		if seq, err = bot.askMember(ctx, getSenderId(message), fmt.Sprintf(MarkPaidPickAsk, event.ref()), candidates); err != nil {
+			bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+			return
+		}
+		if seq == 0 {
+			bot.sendPrivateMessage(getSenderId(message), MarkPaidCancel, false)
+			return
+		}
+	}
+
+	bot.state_mux.Lock()
+	// the event could have been closed or replaced while the admin was picking
+	if bot.current_events[EventKey{chat_id, thread_id}] != event {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	member := event.memberBySeq(seq)
+	var name string
+	if member != nil {
+		member.Paid, member.Amount = paid, amount
+		name = member.Name
+		event.audit(message, audit_paid, strings.TrimSpace(name+feeMark(*member)))
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+	if member == nil {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(MarkPaidNotFound, seq))
+		return
+	}
+
+	report := MarkPaidReport
+	if !paid {
+		report = MarkUnpaidReport
+	}
+	bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(report, seq, escapeMarkdown(name), event.ref()))
+}
+
+// listUnpaid lists the registered members of the active event who haven't
+// paid, with the totals.
+func (bot *Bot) listUnpaid(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+
+	bot.state_mux.Lock()
+	enabled := bot.feesEnabled(chat_id)
+	event, ok := bot.current_events[EventKey{chat_id, getThreadId(message)}]
+	var lines []string
+	if ok {
+		for _, member := range event.Registrations {
+			if !member.Paid {
+				lines = append(lines, fmt.Sprintf(MemberCandidate, member.Seq, escapeMarkdown(member.Name)))
+			}
+		}
+		if len(lines) == 0 {
+			lines = append(lines, fmt.Sprintf(UnpaidNobody, event.ref()))
+		} else {
+			lines = append([]string{fmt.Sprintf(UnpaidHeader, event.ref())}, lines...)
+		}
+		lines = append(lines, feeTotals(event))
+	}
+	bot.state_mux.Unlock()
+
+	switch {
+	case !enabled:
+		bot.respond(ctx, message, FeesOffMsg)
+	case !ok:
+		bot.respond(ctx, message, NoActiveEventMsg)
+	default:
+		bot.respond(ctx, message, strings.Join(lines, "\n"))
+	}
+}
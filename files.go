@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// Telegram doesn't let bots download files bigger than 20MB anyway.
+const max_file_size = 20 << 20
+
+// downloadFile fetches an uploaded file by its file_id. Telegram first
+// resolves the id into a file_path with getFile, the content is then served
+// from a separate URL.
+func (bot *Bot) downloadFile(file_id string) ([]byte, error) {
+	resp, err := bot.apiCall("getFile", JsonTable{"file_id": file_id})
+	if err != nil {
+		return nil, err
+	}
+	file_path := getStr(asTbl(resp), "file_path")
+	if file_path == "" {
+		return nil, TgApiError{Description: "getFile returned no file_path"}
+	}
+
+	download, err := bot.getFile(file_path)
+	if err != nil {
+		return nil, transportError("file download", err)
+	}
+	defer download.Body.Close()
+	switch download.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("file download failed: %w", ErrNotFound)
+	default:
+		return nil, fmt.Errorf("file download failed: %s", download.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(download.Body, max_file_size+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > max_file_size {
+		return nil, fmt.Errorf("file is larger than %d bytes", max_file_size)
+	}
+	return data, nil
+}
+
+// saveFile downloads a file into files_dir under name and returns its path.
+func (bot *Bot) saveFile(file_id string, name string) (string, error) {
+	data, err := bot.downloadFile(file_id)
+	if err != nil {
+		return "", err
+	}
+	if err = os.MkdirAll(bot.files_dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(bot.files_dir, filepath.Base(name))
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+)
+
+const FilterRejectMsg = "Текст содержит недопустимые слова, введите другой вариант."
+
+func parseBannedWords(list string) []string {
+	var words []string
+	for _, word := range strings.Split(list, ",") {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// bannedWord returns the first banned word found in text, ignoring case.
+func (bot *Bot) bannedWord(text string) string {
+	lower := strings.ToLower(text)
+	for _, word := range bot.banned_words {
+		if strings.Contains(lower, word) {
+			return word
+		}
+	}
+	return ""
+}
+
+// askFiltered asks a question until the answer passes the word filter.
+func (bot *Bot) askFiltered(ctx context.Context, user_id json.Number, question string) (string, error) {
+	for {
+		text, err := bot.askText(ctx, user_id, question)
+		if err != nil {
+			return "", err
+		}
+		word := bot.bannedWord(text)
+		if word == "" {
+			return text, nil
+		}
+		bot.log.Warn("input rejected by word filter", "user_id", user_id, "word", word, "text", text)
+		bot.sendPrivateMessage(user_id, FilterRejectMsg, false)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+const (
+	PromptFlushedMsg = "Вопрос бота отменён администратором. Повторите команду, если она ещё нужна."
+	FlushUsage       = "Использование: /flushprompts [id пользователя или чата]"
+	FlushReport      = "Сброшено вопросов: %d."
+)
+
+var ErrPromptFlushed = errors.New("prompt flushed")
+
+var chat_or_user_re = regexp.MustCompile(`^-?[0-9]+$`)
+
+// flushPrompts is the remedy for prompts nobody can answer any more, short
+// of a restart. With an id only the prompts of that user, or asked by
+// commands from that chat, are flushed.
+func (bot *Bot) flushPrompts(ctx context.Context, message JsonTable, args []string) {
+	user_id := getSenderId(message)
+	matching := func(p *Prompt) bool { return true }
+	switch {
+	case len(args) == 0:
+	case len(args) == 1 && chat_or_user_re.MatchString(args[0]):
+		id := json.Number(args[0])
+		matching = func(p *Prompt) bool { return p.user_id == id || p.chat_id == id }
+	default:
+		bot.sendPrivateMessage(user_id, FlushUsage, false)
+		return
+	}
+
+	flushed := bot.closePrompts(matching)
+	bot.messageLogger(message).Warn("prompts flushed", "count", flushed, "scope", args)
+	bot.replyPrivately(message, fmt.Sprintf(FlushReport, flushed))
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// fuzz_seeds are updates of the wrong shape: null where an object belongs,
+// chat and from of the wrong type, numbers sent as strings.
+var fuzz_seeds = []string{
+	`null`,
+	`{}`,
+	`{"update_id":1,"message":null}`,
+	`{"update_id":1,"message":{"chat":null,"from":null,"text":"/list"}}`,
+	`{"update_id":1,"message":{"chat":"1","from":"2","text":"/list"}}`,
+	`{"update_id":1,"message":{"chat":[1],"from":true,"text":"/register"}}`,
+	`{"update_id":1,"message":{"chat":{"id":"1","type":"group"},"from":{"id":"2"},"text":"/list"}}`,
+	`{"update_id":"1","message":{"message_id":"5","chat":{"id":1,"type":"private"},"from":{"id":2},"text":"/help"}}`,
+	`{"update_id":1,"message":{"chat":{"id":1,"type":"private"},"from":{"id":2},"text":7}}`,
+	`{"update_id":1,"message":{"chat":{"id":1},"from":{"id":2},"text":"hi","reply_to_message":{"message_id":"3","from":{"id":1000}}}}`,
+	`{"update_id":1,"message":{"chat":{"id":1},"from":{"id":2},"text":"hi","reply_to_message":"3"}}`,
+	`{"update_id":1,"message":{"chat":{"id":1},"migrate_to_chat_id":"2"}}`,
+	`{"update_id":1,"message":{"chat":{"id":1},"new_chat_members":[null,1,{"id":"2"}]}}`,
+	`{"update_id":1,"callback_query":{"id":1,"from":"2","data":7,"message":null}}`,
+	`{"update_id":1,"callback_query":{"id":"1","from":{"id":2},"data":"page:log:x","message":{"chat":{"id":"1"}}}}`,
+	`{"update_id":1,"poll_answer":{"poll_id":5,"user":null,"option_ids":"0"}}`,
+	`{"update_id":1,"chat_member":{"chat":{"id":1},"new_chat_member":"x","old_chat_member":null}}`,
+	`{"update_id":1,"message_reaction":{"chat":{"id":1},"message_id":"9","user":{"id":"2"},"new_reaction":{}}}`,
+}
+
+func decodeFuzz(data []byte) (JsonAny, bool) {
+	var v JsonAny
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return v, d.Decode(&v) == nil
+}
+
+func FuzzAccessors(f *testing.F) {
+	for _, seed := range fuzz_seeds {
+		f.Add([]byte(seed), "message")
+		f.Add([]byte(seed), "update_id")
+	}
+	bot := &Bot{Name: "test_bot"}
+	f.Fuzz(func(t *testing.T, data []byte, key string) {
+		v, ok := decodeFuzz(data)
+		if !ok {
+			return
+		}
+		tbl := asTbl(v)
+		for _, tbl := range []JsonTable{tbl, getTbl(tbl, key), getTbl(tbl, "message")} {
+			for k := range tbl {
+				checkAccessors(t, tbl, k)
+			}
+			checkAccessors(t, tbl, key)
+
+			// the message accessors on whatever is there
+			getChatId(tbl)
+			getThreadId(tbl)
+			getSenderId(tbl)
+			isPrivateChat(tbl)
+			bot.commandName(tbl)
+		}
+	})
+}
+
+// checkAccessors checks that each accessor returns the value under key when
+// it has the type asked for, and the zero value otherwise.
+func checkAccessors(t *testing.T, tbl JsonTable, key string) {
+	value, present := tbl[key]
+	if hasKey(tbl, key) != present {
+		t.Errorf("hasKey(%q) = %v for %v", key, hasKey(tbl, key), tbl)
+	}
+	if s, _ := value.(string); getStr(tbl, key) != s {
+		t.Errorf("getStr(%q) = %q for %#v", key, getStr(tbl, key), value)
+	}
+	n, _ := value.(json.Number)
+	if getNum(tbl, key) != n {
+		t.Errorf("getNum(%q) = %q for %#v", key, getNum(tbl, key), value)
+	}
+	if i, _ := n.Int64(); getInt(tbl, key) != i {
+		t.Errorf("getInt(%q) = %d for %#v", key, getInt(tbl, key), value)
+	}
+	if sub, ok := value.(JsonTable); ok != (getTbl(tbl, key) != nil) || len(sub) != len(getTbl(tbl, key)) {
+		t.Errorf("getTbl(%q) = %v for %#v", key, getTbl(tbl, key), value)
+	}
+}
+
+func FuzzHandleMessage(f *testing.F) {
+	for _, seed := range fuzz_seeds {
+		f.Add([]byte(seed))
+	}
+	bot, _ := newTestBot(f)
+	f.Fuzz(func(t *testing.T, data []byte) {
+		v, ok := decodeFuzz(data)
+		if !ok {
+			return
+		}
+		update := asTbl(v)
+		failed := failedCommands(bot)
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			bot.handleMessage(update)
+		}()
+		// a command asking something gets its prompts flushed instead of
+		// waiting for an answer nobody sends
+		for {
+			select {
+			case <-done:
+				if failedCommands(bot) != failed {
+					t.Fatalf("a command panicked on %s", data)
+				}
+				return
+			case <-time.After(time.Millisecond):
+				bot.closePrompts(func(p *Prompt) bool { return true })
+			}
+		}
+	})
+}
+
+// failedCommands counts the commands that panicked, recoverPanic only logs
+// them.
+func failedCommands(bot *Bot) int {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	failed := 0
+	for _, chats := range bot.command_usage {
+		for _, counter := range chats {
+			failed += counter.Failed
+		}
+	}
+	return failed
+}
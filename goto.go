@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	GotoLink     = "Событие #%s: %s"
+	GotoNoEvent  = "Нет события %s."
+	GotoNoEvents = "В этом канале нет активных событий."
+	GotoNoPost   = "У события #%s нет сообщения в канале, /show опубликует его."
+	GotoDeleted  = "Сообщение события #%s удалено, /show опубликует его заново."
+	GotoNoLinks  = "Telegram не даёт ссылок на сообщения обычных групп, только супергрупп и каналов."
+)
+
+// rememberPost records the message resp as the event's post in its chat,
+// the one /goto links to.
+func (bot *Bot) rememberPost(event *EventInfo, resp JsonAny) {
+	message_id := getNum(asTbl(resp), "message_id")
+	if message_id == "" {
+		return
+	}
+	bot.state_mux.Lock()
+	event.PostMessageId = message_id
+	bot.saveState()
+	bot.state_mux.Unlock()
+}
+
+// chatLink is what t.me links to messages of chat start with. Only
+// supergroups and channels have them: public ones by username, private ones
+// by the id without its -100 prefix, which only members can open.
+func chatLink(chat JsonTable) (string, bool) {
+	if username := getStr(chat, "username"); username != "" {
+		return "https://t.me/" + username, true
+	}
+	if id, ok := strings.CutPrefix(getNum(chat, "id").String(), "-100"); ok {
+		return "https://t.me/c/" + id, true
+	}
+	return "", false
+}
+
+// postExists asks Telegram whether the bot's own message is still there.
+// Editing a message to what it already is fails as "not modified" when it
+// exists and as "not found" when it was deleted. Event posts have no
+// keyboard, which this would remove.
+func (bot *Bot) postExists(chat_id json.Number, message_id json.Number) bool {
+	_, err := bot.apiCall("editMessageReplyMarkup", JsonTable{
+		"chat_id":    chat_id,
+		"message_id": message_id,
+	})
+	return !errors.Is(err, ErrNotFound)
+}
+
+// gotoEvent replies with a link to the post of an event, or of every active
+// event of the chat without arguments.
+func (bot *Bot) gotoEvent(ctx context.Context, message JsonTable, args []string) {
+	chat := getTbl(message, "chat")
+	chat_id := getChatId(message)
+
+	var events []*EventInfo
+	bot.state_mux.Lock()
+	if len(args) > 0 {
+		if event := bot.findEventRef(chat_id, args[0]); event != nil {
+			events = append(events, event)
+		}
+	} else {
+		events = bot.chatEvents(chat_id)
+		sort.Slice(events, func(i, j int) bool { return events[i].EventId < events[j].EventId })
+	}
+	type post struct {
+		event      *EventInfo
+		ref        string
+		message_id json.Number
+	}
+	posts := make([]post, 0, len(events))
+	for _, event := range events {
+		posts = append(posts, post{event, event.ref(), event.PostMessageId})
+	}
+	bot.state_mux.Unlock()
+
+	switch {
+	case len(posts) == 0 && len(args) > 0:
+		bot.respond(ctx, message, fmt.Sprintf(GotoNoEvent, escapeMarkdown(args[0])))
+		return
+	case len(posts) == 0:
+		bot.respond(ctx, message, GotoNoEvents)
+		return
+	}
+	base, ok := chatLink(chat)
+	if !ok {
+		bot.respond(ctx, message, GotoNoLinks)
+		return
+	}
+
+	var lines []string
+	for _, p := range posts {
+		if p.message_id == "" {
+			lines = append(lines, fmt.Sprintf(GotoNoPost, p.ref))
+			continue
+		}
+		if !bot.postExists(chat_id, p.message_id) {
+			bot.state_mux.Lock()
+			if p.event.PostMessageId == p.message_id {
+				p.event.PostMessageId = ""
+				bot.saveState()
+			}
+			bot.state_mux.Unlock()
+			lines = append(lines, fmt.Sprintf(GotoDeleted, p.ref))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf(GotoLink, p.ref, base+"/"+p.message_id.String()))
+	}
+	bot.respondRaw(ctx, message, strings.Join(lines, "\n"))
+}
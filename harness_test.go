@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const test_bot_id = json.Number("1000")
+
+func TestMain(m *testing.M) {
+	// every API call is logged, which buries the test output
+	flag.Parse()
+	if !testing.Verbose() {
+		slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	}
+	os.Exit(m.Run())
+}
+
+// apiRequest is a call the bot made to fakeApi.
+type apiRequest struct {
+	Method  string
+	Request JsonTable
+}
+
+// fakeApi stands in for the Bot API. It records the calls and answers each
+// with the result set for its method, a message with a fresh message_id by
+// default.
+type fakeApi struct {
+	server *httptest.Server
+
+	mux     sync.Mutex
+	calls   []apiRequest
+	results map[string]string // raw JSON by method
+	errors  map[string]string // whole responses of the methods that fail
+	last_id int
+}
+
+func newFakeApi(t testing.TB) *fakeApi {
+	api := &fakeApi{results: map[string]string{}, errors: map[string]string{}}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serve))
+	t.Cleanup(api.server.Close)
+	return api
+}
+
+func (api *fakeApi) serve(w http.ResponseWriter, r *http.Request) {
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+	request := JsonTable{}
+	d := json.NewDecoder(r.Body)
+	d.UseNumber()
+	d.Decode(&request)
+
+	api.mux.Lock()
+	api.calls = append(api.calls, apiRequest{method, request})
+	result, ok := api.results[method]
+	if !ok {
+		api.last_id++
+		result = `{"message_id":` + strconv.Itoa(api.last_id) + `,"chat":{"id":1}}`
+	}
+	response := `{"ok":true,"result":` + result + `}`
+	if failure, ok := api.errors[method]; ok {
+		response = failure
+	}
+	api.mux.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	io.WriteString(w, response)
+}
+
+// answer makes calls of method return result, raw JSON.
+func (api *fakeApi) answer(method string, result string) {
+	api.mux.Lock()
+	api.results[method] = result
+	api.mux.Unlock()
+}
+
+// fail makes calls of method fail with the API error code and description.
+func (api *fakeApi) fail(method string, code int, description string) {
+	data, _ := json.Marshal(JsonTable{"ok": false, "error_code": code, "description": description})
+	api.mux.Lock()
+	api.errors[method] = string(data)
+	api.mux.Unlock()
+}
+
+// sent returns the requests of the method calls made so far.
+func (api *fakeApi) sent(method string) []JsonTable {
+	api.mux.Lock()
+	defer api.mux.Unlock()
+	var requests []JsonTable
+	for _, call := range api.calls {
+		if call.Method == method {
+			requests = append(requests, call.Request)
+		}
+	}
+	return requests
+}
+
+// newTestBot starts a bot talking to a fakeApi, with empty state kept in a
+// temporary directory. Its outbox isn't running, queued sends stay queued.
+func newTestBot(t testing.TB) (*Bot, *fakeApi) {
+	api := newFakeApi(t)
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	t.Cleanup(transport.CloseIdleConnections)
+
+	client, long_client := newClients(transport)
+	bot := newBot(api.server.URL+"/bot", "test", client, long_client)
+	bot.Name, bot.Id = "test_bot", test_bot_id
+	bot.store = &FileStore{Path: filepath.Join(t.TempDir(), "state.json")}
+	if err := bot.loadState(); err != nil {
+		t.Fatal(err)
+	}
+	bot.outbox, _ = newOutbox("", bot.log)
+	return bot, api
+}
+
+// waitFor polls cond until it holds, failing the test after a second.
+func waitFor(t testing.TB, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
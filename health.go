@@ -0,0 +1,45 @@
+package main
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+)
+
+// healthz reports whether the bot can currently reach the Telegram API.
+func (bot *Bot) healthz(w http.ResponseWriter, r *http.Request) {
+	status := JsonTable{
+		"status":  "ok",
+		"breaker": bot.breaker.State().String(),
+		"stats":   bot.statusSnapshot(),
+	}
+	if bot.leader_lock_path != "" {
+		status["leader"] = bot.is_leader.Load()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if bot.breaker.State() == BreakerOpen {
+		status["status"] = "degraded"
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// startHealthServer serves /healthz, the expvar metrics on /debug/vars and,
+// with api_token set, the read-only API and with calendar_url the calendar
+// feeds.
+func (bot *Bot) startHealthServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", bot.healthz)
+	mux.Handle("/debug/vars", expvar.Handler())
+	if bot.api_token != "" {
+		bot.registerApi(mux)
+	}
+	if bot.calendar_url != "" {
+		mux.HandleFunc("GET /calendar/{id}/{token}", bot.calendarFeed)
+	}
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			bot.log.Error("health server failed", "addr", addr, "error", err)
+		}
+	}()
+}
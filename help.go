@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"sort"
+)
+
+const (
+	HelpParticipantHeader = "Участникам"
+	HelpInfoHeader        = "Информация"
+	HelpAdminHeader       = "Администраторам"
+)
+
+// helpAccess is what the sender of message may run, up to admin commands of
+// the chat /help was sent to.
+func (bot *Bot) helpAccess(message JsonTable) map[Access]bool {
+	user_id := getSenderId(message)
+	allowed := map[Access]bool{AccessAnyone: true}
+	if bot.isObserver(user_id) {
+		allowed[AccessObserver] = true
+	}
+	if bot.isBotAdmin(user_id) {
+		allowed[AccessBotAdmin] = true
+	}
+	if !isPrivateChat(message) {
+		admin := isAnonymousAdmin(message)
+		if !admin {
+			admin, _ = bot.isUserAdmin(user_id, getChatId(message))
+		}
+		if admin {
+			allowed[AccessObserver] = true
+			allowed[AccessChatAdmin] = true
+		}
+	}
+	return allowed
+}
+
+// helpSection renders the given commands sorted by name, nil if there are
+// none.
+func (bot *Bot) helpSection(header string, disabled map[string]bool, include func(command Command) bool) *TextBuilder {
+	var names []string
+	for name, command := range bot.commands {
+		if include(command) && !disabled[name] && (command.Help != "" || command.Description != "") {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+
+	b := &TextBuilder{}
+	b.Bold(header).Line("")
+	for _, name := range names {
+		command := bot.commands[name]
+		b.Text(name)
+		if command.Args != "" {
+			b.Text(" " + command.Args)
+		}
+		text := command.Help
+		if text == "" {
+			text = command.Description
+		}
+		b.Line(" - " + text)
+	}
+	return b
+}
+
+// help sends one message per section, so it stays under the message size
+// limit however many commands there are. The chat's custom help text
+// replaces the sections anyone sees, admins still get theirs.
+func (bot *Bot) help(ctx context.Context, message JsonTable, args []string) {
+	allowed := bot.helpAccess(message)
+	config := bot.getChatConfig(getChatId(message))
+
+	var sections []*TextBuilder
+	if custom := config.HelpText; custom != "" {
+		if bot.respondRaw(ctx, message, custom) != nil {
+			return
+		}
+	} else {
+		sections = append(sections,
+			bot.helpSection(HelpParticipantHeader, config.DisabledCommands, func(c Command) bool {
+				return c.Access == AccessAnyone && c.Section == HelpParticipant
+			}),
+			bot.helpSection(HelpInfoHeader, config.DisabledCommands, func(c Command) bool {
+				return c.Access == AccessAnyone && c.Section == HelpInfo
+			}))
+	}
+	sections = append(sections, bot.helpSection(HelpAdminHeader, config.DisabledCommands, func(c Command) bool {
+		return c.Access != AccessAnyone && allowed[c.Access]
+	}))
+
+	for _, b := range sections {
+		if b != nil && bot.respondFormatted(ctx, message, b) != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	EventCloseAsk       = "Закрыть событие #%s?"
+	EventCloseCancelled = "Закрытие отменено."
+	EventCloseReport    = "Событие #%s закрыто, участников: %d."
+	CloseAllAsk         = "Закрыть все активные события в канале (%d)?"
+	CloseAllReport      = "Закрыто событий: %d."
+	CloseAllSummaryLine = "#%s %s — участников: %d"
+	NoActiveEventsMsg   = "В канале нет активных событий."
+	HistoryHeader       = "Прошедшие события: %d. Состав участников: /history <номер>"
+	HistoryEmpty        = "В канале ещё не было закрытых событий."
+	HistoryLine         = "#%s %s — участников: %d"
+	HistoryNotFound     = "В истории канала нет события %s."
+)
+
+const (
+	max_history_page  = 30 // summary lines that safely fit in a message
+	history_cache_ttl = time.Minute
+)
+
+// chatEvents returns the active events of a chat. Must be called with
+// state_mux held.
+func (bot *Bot) chatEvents(chat_id json.Number) []*EventInfo {
+	var events []*EventInfo
+	for _, event := range bot.current_events {
+		if event.ChatId == chat_id {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// archiveEvent moves an active event to the chat history. Returns false if
+// the event is no longer active. Must be called with state_mux held.
+func (bot *Bot) archiveEvent(event *EventInfo) bool {
+	for key, active := range bot.current_events {
+		if active == event {
+			delete(bot.current_events, key)
+			event.ClosedAt = time.Now()
+			bot.chat_history[event.ChatId] = append(bot.chat_history[event.ChatId], event)
+			return true
+		}
+	}
+	return false
+}
+
+func (bot *Bot) eventClose(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	user_id := getSenderId(message)
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	bot.state_mux.Unlock()
+	if !ok {
+		bot.sendPrivateMessage(user_id, NoActiveEventMsg, false)
+		return
+	}
+
+	confirmed, err := bot.askConfirmation(ctx, user_id, fmt.Sprintf(EventCloseAsk, event.ref()))
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	if !confirmed {
+		bot.sendPrivateMessage(user_id, EventCloseCancelled, false)
+		return
+	}
+
+	bot.state_mux.Lock()
+	closed := bot.archiveEvent(event)
+	registered := len(event.Registrations)
+	if closed {
+		event.audit(message, audit_closed, "")
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+
+	if !closed {
+		bot.sendPrivateMessage(user_id, NoActiveEventMsg, false)
+		return
+	}
+	report := fmt.Sprintf(EventCloseReport, event.ref(), registered)
+	bot.sendPrivateMessage(user_id, report, false)
+	if bot.getChatConfig(chat_id).AnnounceEvents {
+		bot.queueMessage(chatMessage(chat_id, thread_id, report), nil)
+	}
+}
+
+func (bot *Bot) closeAll(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	user_id := getSenderId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	bot.state_mux.Lock()
+	count := len(bot.chatEvents(chat_id))
+	bot.state_mux.Unlock()
+	if count == 0 {
+		bot.sendPrivateMessage(user_id, NoActiveEventsMsg, false)
+		return
+	}
+
+	confirmed, err := bot.askConfirmation(ctx, user_id, fmt.Sprintf(CloseAllAsk, count))
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	if !confirmed {
+		bot.sendPrivateMessage(user_id, EventCloseCancelled, false)
+		return
+	}
+
+	closed := 0
+	lines := []string{}
+	bot.state_mux.Lock()
+	for _, event := range bot.chatEvents(chat_id) {
+		if bot.archiveEvent(event) {
+			event.audit(message, audit_closed, "")
+			closed++
+			lines = append(lines, fmt.Sprintf(CloseAllSummaryLine,
+				event.ref(), escapeMarkdown(event.Description), len(event.Registrations)))
+		}
+	}
+	if closed > 0 {
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+
+	report := fmt.Sprintf(CloseAllReport, closed)
+	if closed > 0 {
+		bot.sendReply(chat_id, thread_id, message_id, report+"\n"+strings.Join(lines, "\n"))
+	}
+	bot.sendPrivateMessage(user_id, report, false)
+}
+
+func (c ChatConfig) historyPageSize() int {
+	if c.HistoryPageSize > 0 {
+		return c.HistoryPageSize
+	}
+	return page_size
+}
+
+// renderHistory lists the closed events of the chat arg, newest first, one
+// summary line each.
+func (bot *Bot) renderHistory(user_id json.Number, arg string) (string, []string) {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	events := bot.chat_history[json.Number(arg)]
+	if len(events) == 0 {
+		return HistoryEmpty, nil
+	}
+
+	lines := make([]string, 0, len(events))
+	for i := len(events) - 1; i >= 0; i-- {
+		event := events[i]
+		summary, _, _ := strings.Cut(event.Description, "\n")
+		summary = escapeMarkdown(strings.TrimSpace(summary))
+		if !event.StartTime.IsZero() {
+			summary = event.StartTime.Format(localeOf(event.Locale).TimeLayout) + " " + summary
+		}
+		lines = append(lines, fmt.Sprintf(HistoryLine, event.ref(), summary, len(event.Registrations)))
+	}
+	return fmt.Sprintf(HistoryHeader, len(events)), lines
+}
+
+// history sends the closed events of the chat privately in pages, or with
+// an event given the whole of it with its registrations.
+func (bot *Bot) history(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	user_id := getSenderId(message)
+
+	if len(args) == 0 {
+		bot.sendPaged(user_id, user_id, "history", string(chat_id))
+		return
+	}
+
+	b := &TextBuilder{}
+	bot.state_mux.Lock()
+	if event := matchRef(bot.chat_history[chat_id], args[0]); event != nil {
+		bot.writeEvent(b, event)
+	} else {
+		b.Text(fmt.Sprintf(HistoryNotFound, args[0]))
+	}
+	bot.state_mux.Unlock()
+	bot.respondFormatted(ctx, message, b)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+)
+
+const (
+	poll_timeout = 15 * time.Second // long poll timeout passed to getUpdates
+
+	// sends and other calls answer quickly, a hung one shouldn't block the
+	// handler forever
+	api_timeout = 30 * time.Second
+	// long polls and file downloads
+	long_timeout = poll_timeout + 30*time.Second
+
+	default_max_conns    = 32 // per host, bounds bursts of sends
+	default_idle_conns   = 16 // kept open per host between bursts
+	default_idle_timeout = 90 * time.Second
+)
+
+// newClients returns the client for API calls and the one for long polls and
+// downloads, both over transport.
+func newClients(transport *http.Transport) (client *http.Client, long_client *http.Client) {
+	return &http.Client{Transport: transport, Timeout: api_timeout},
+		&http.Client{Transport: transport, Timeout: long_timeout}
+}
+
+// tuneTransport sets up connection reuse for the API host. The default of two
+// idle connections per host makes every burst of sends beyond two open new
+// connections. BOT_HTTP_MAX_CONNS, BOT_HTTP_IDLE_CONNS and
+// BOT_HTTP_IDLE_TIMEOUT override the defaults.
+func tuneTransport(transport *http.Transport) error {
+	transport.MaxConnsPerHost = default_max_conns
+	transport.MaxIdleConnsPerHost = default_idle_conns
+	transport.MaxIdleConns = default_idle_conns * 4
+	transport.IdleConnTimeout = default_idle_timeout
+
+	for name, field := range map[string]*int{
+		"BOT_HTTP_MAX_CONNS":  &transport.MaxConnsPerHost,
+		"BOT_HTTP_IDLE_CONNS": &transport.MaxIdleConnsPerHost,
+	} {
+		if value := os.Getenv(name); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid %s: %q", name, value)
+			}
+			*field = n
+		}
+	}
+	if value := os.Getenv("BOT_HTTP_IDLE_TIMEOUT"); value != "" {
+		timeout, err := time.ParseDuration(value)
+		if err != nil || timeout < 0 {
+			return fmt.Errorf("invalid BOT_HTTP_IDLE_TIMEOUT: %q", value)
+		}
+		transport.IdleConnTimeout = timeout
+	}
+	transport.MaxIdleConns = max(transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	return nil
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTuneTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		env          map[string]string
+		ok           bool
+		max_conns    int
+		idle_conns   int
+		idle_timeout time.Duration
+	}{
+		{"defaults", nil, true, default_max_conns, default_idle_conns, default_idle_timeout},
+		{"overridden", map[string]string{"BOT_HTTP_MAX_CONNS": "8", "BOT_HTTP_IDLE_CONNS": "4", "BOT_HTTP_IDLE_TIMEOUT": "1m"}, true, 8, 4, time.Minute},
+		{"zero is unlimited", map[string]string{"BOT_HTTP_MAX_CONNS": "0", "BOT_HTTP_IDLE_TIMEOUT": "0s"}, true, 0, default_idle_conns, 0},
+		{"negative max conns", map[string]string{"BOT_HTTP_MAX_CONNS": "-1"}, false, 0, 0, 0},
+		{"negative idle conns", map[string]string{"BOT_HTTP_IDLE_CONNS": "-4"}, false, 0, 0, 0},
+		{"negative idle timeout", map[string]string{"BOT_HTTP_IDLE_TIMEOUT": "-30s"}, false, 0, 0, 0},
+		{"max conns not a number", map[string]string{"BOT_HTTP_MAX_CONNS": "many"}, false, 0, 0, 0},
+		{"idle conns fractional", map[string]string{"BOT_HTTP_IDLE_CONNS": "1.5"}, false, 0, 0, 0},
+		{"idle timeout without unit", map[string]string{"BOT_HTTP_IDLE_TIMEOUT": "90"}, false, 0, 0, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, name := range []string{"BOT_HTTP_MAX_CONNS", "BOT_HTTP_IDLE_CONNS", "BOT_HTTP_IDLE_TIMEOUT"} {
+				t.Setenv(name, test.env[name])
+			}
+			transport := &http.Transport{}
+			err := tuneTransport(transport)
+			if (err == nil) != test.ok {
+				t.Fatalf("tuneTransport error %v, want ok %v", err, test.ok)
+			}
+			if !test.ok {
+				return
+			}
+			if transport.MaxConnsPerHost != test.max_conns || transport.MaxIdleConnsPerHost != test.idle_conns ||
+				transport.IdleConnTimeout != test.idle_timeout {
+				t.Errorf("got max %d, idle %d, timeout %s", transport.MaxConnsPerHost, transport.MaxIdleConnsPerHost, transport.IdleConnTimeout)
+			}
+			if transport.MaxIdleConns < transport.MaxIdleConnsPerHost {
+				t.Errorf("MaxIdleConns %d below the per host %d", transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+			}
+		})
+	}
+}
+
+// BenchmarkSendBurst sends bursts of concurrent calls, like a broadcast,
+// and reports how many connections each burst opened. The default transport
+// keeps two idle connections, so every burst reconnects.
+func BenchmarkSendBurst(b *testing.B) {
+	const burst = 50
+	for _, tuned := range []bool{false, true} {
+		name := "default"
+		if tuned {
+			name = "tuned"
+		}
+		b.Run(name, func(b *testing.B) {
+			var conns atomic.Int64
+			server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				io.WriteString(w, `{"ok":true,"result":{"message_id":1}}`)
+			}))
+			server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			server.Start()
+			defer server.Close()
+
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			if tuned {
+				if err := tuneTransport(transport); err != nil {
+					b.Fatal(err)
+				}
+			}
+			defer transport.CloseIdleConnections()
+			client, long_client := newClients(transport)
+			sender := newBot(server.URL+"/bot", "test", client, long_client)
+			data := []byte(`{"chat_id":1,"text":"hi"}`)
+
+			b.ResetTimer()
+			for range b.N {
+				var wg sync.WaitGroup
+				for range burst {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						resp, err := sender.post("sendMessage", data)
+						if err != nil {
+							b.Error(err)
+							return
+						}
+						io.Copy(io.Discard, resp.Body)
+						resp.Body.Close()
+					}()
+				}
+				wg.Wait()
+			}
+			b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"unicode"
+)
+
+const (
+	import_max_rows    = 500
+	import_max_license = 32
+	import_max_errors  = 20 // reported line by line, the rest only counted
+)
+
+const (
+	ImportUsage      = "Отправьте CSV-файл (Имя,Лицензия) с подписью /import или ответьте /import на сообщение с файлом."
+	ImportFailedMsg  = "Не удалось прочитать файл: %s"
+	ImportTooMany    = "В файле больше %d строк."
+	ImportReport     = "Импорт в событие #%s: добавлено %d, пропущено дубликатов %d, ошибок %d."
+	ImportLineError  = "Строка %d: %s"
+	ImportMoreErrors = "…и ещё ошибок: %d"
+	ImportBadColumns = "ожидается два столбца: имя и лицензия"
+	ImportNoName     = "не указано имя"
+	ImportBadLicense = "недопустимый номер лицензии"
+	ImportBannedWord = "недопустимое слово"
+)
+
+func validLicense(license string) bool {
+	if license == "" || len(license) > import_max_license {
+		return false
+	}
+	for _, r := range license {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != ' ' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// parseMembers reads "name,license" rows. A header row is skipped. Rows that
+// don't make a valid record are reported by their line number.
+func (bot *Bot) parseMembers(data []byte) ([]MemberRecord, []string, error) {
+	r := csv.NewReader(bytes.NewReader(data))
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+
+	var members []MemberRecord
+	var problems []string
+	for {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			var parse_err *csv.ParseError
+			if !errors.As(err, &parse_err) {
+				return nil, nil, err
+			}
+			problems = append(problems, fmt.Sprintf(ImportLineError, parse_err.Line, parse_err.Err))
+			continue
+		}
+		line, _ := r.FieldPos(0)
+		if line == 1 && len(row) == 2 && strings.EqualFold(row[0], "name") {
+			continue
+		}
+		if len(members)+len(problems) >= import_max_rows {
+			return nil, nil, fmt.Errorf(ImportTooMany, import_max_rows)
+		}
+
+		var problem string
+		if len(row) != 2 {
+			problem = ImportBadColumns
+		} else {
+			name, license := strings.TrimSpace(row[0]), strings.TrimSpace(row[1])
+			switch {
+			case name == "":
+				problem = ImportNoName
+			case !validLicense(license):
+				problem = ImportBadLicense
+			case bot.bannedWord(name) != "":
+				problem = ImportBannedWord
+			default:
+				members = append(members, MemberRecord{Name: name, License: license})
+			}
+		}
+		if problem != "" {
+			problems = append(problems, fmt.Sprintf(ImportLineError, line, problem))
+		}
+	}
+	return members, problems, nil
+}
+
+// importMembers adds the participants listed in an uploaded CSV file to the
+// active event. Imported members have no Telegram account attached, so they
+// get no DMs and can't unregister themselves.
+func (bot *Bot) importMembers(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	user_id := getSenderId(message)
+
+	document := getTbl(message, "document")
+	if document == nil {
+		document = getTbl(getTbl(message, "reply_to_message"), "document")
+	}
+	if document == nil {
+		bot.sendPrivateMessage(user_id, ImportUsage, false)
+		return
+	}
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	bot.state_mux.Unlock()
+	if !ok {
+		bot.sendPrivateMessage(user_id, NoActiveEventMsg, false)
+		return
+	}
+
+	bot.sendTyping(user_id, "")
+	data, err := bot.downloadFile(getStr(document, "file_id"))
+	if err != nil {
+		bot.messageLogger(message).Warn("failed to download import file", "error", err)
+		bot.sendPrivateMessage(user_id, fmt.Sprintf(ImportFailedMsg, escapeMarkdown(err.Error())), false)
+		return
+	}
+	members, problems, err := bot.parseMembers(data)
+	if err != nil {
+		bot.sendPrivateMessage(user_id, fmt.Sprintf(ImportFailedMsg, escapeMarkdown(err.Error())), false)
+		return
+	}
+
+	added, skipped := 0, 0
+	bot.state_mux.Lock()
+	if bot.current_events[EventKey{chat_id, thread_id}] != event {
+		bot.state_mux.Unlock()
+		bot.sendPrivateMessage(user_id, NoActiveEventMsg, false)
+		return
+	}
+	for _, member := range members {
+		if event.hasDuplicate(member) {
+			skipped++
+			continue
+		}
+		member.Seq = event.nextSeq()
+		if event.isFull() {
+			event.addToWaitlist(member)
+		} else {
+			event.Registrations = append(event.Registrations, member)
+		}
+		added++
+	}
+	if added > 0 {
+		event.audit(message, audit_imported, fmt.Sprintf("%d", added))
+	}
+	notice := bot.capacityNotice(event)
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	lines := []string{fmt.Sprintf(ImportReport, event.ref(), added, skipped, len(problems))}
+	for i, problem := range problems {
+		if i == import_max_errors {
+			lines = append(lines, fmt.Sprintf(ImportMoreErrors, len(problems)-i))
+			break
+		}
+		lines = append(lines, escapeMarkdown(problem))
+	}
+	bot.sendPrivateMessage(user_id, strings.Join(lines, "\n"), false)
+	bot.announceCapacity(event, notice)
+}
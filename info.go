@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	max_info_entries = 10
+	max_info_value   = 300 // characters
+)
+
+const (
+	EventInfoHeader = "Информация:"
+	SetInfoUsage    = "Использование: /setinfo <ключ>=<значение>, например /setinfo взнос=1000 ₽. Пустое значение удаляет ключ."
+	SetInfoBadKey   = "Ключ — до 20 букв, цифр, _ или -."
+	SetInfoTooLong  = "Значение длиннее %d символов."
+	SetInfoTooMany  = "У события уже %d ключей, удалите ненужный."
+	SetInfoReport   = "Событие #%s: %s = %s"
+	SetInfoRemoved  = "Событие #%s: %s удалён."
+	SetInfoNoKey    = "У события #%s нет ключа %s."
+)
+
+var info_key_re = regexp.MustCompile(`^[\p{L}\p{N}_-]{1,20}$`)
+
+// writeEventInfo lists the notes of the event, sorted by key.
+func writeEventInfo(b *TextBuilder, event *EventInfo) {
+	if len(event.Info) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(event.Info))
+	for key := range event.Info {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	b.Text("\n\n" + EventInfoHeader)
+	for _, key := range keys {
+		b.Text("\n").Bold(key).Text(": " + event.Info[key])
+	}
+}
+
+// setEventInfo attaches a note like the entry fee or a link to the rules to
+// the active event, /show lists them.
+func (bot *Bot) setEventInfo(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	key, value, ok := strings.Cut(strings.Join(args, " "), "=")
+	key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+	if !ok {
+		bot.sendReply(chat_id, thread_id, message_id, SetInfoUsage)
+		return
+	}
+	if !info_key_re.MatchString(key) {
+		bot.sendReply(chat_id, thread_id, message_id, SetInfoBadKey)
+		return
+	}
+	if utf8.RuneCountInString(value) > max_info_value {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(SetInfoTooLong, max_info_value))
+		return
+	}
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	if !ok {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	_, exists := event.Info[key]
+	var report string
+	switch {
+	case value == "" && !exists:
+		report = fmt.Sprintf(SetInfoNoKey, event.ref(), escapeMarkdown(key))
+	case value == "":
+		delete(event.Info, key)
+		event.audit(message, audit_edited, key)
+		report = fmt.Sprintf(SetInfoRemoved, event.ref(), escapeMarkdown(key))
+		bot.saveState()
+	case !exists && len(event.Info) >= max_info_entries:
+		report = fmt.Sprintf(SetInfoTooMany, len(event.Info))
+	default:
+		if event.Info == nil {
+			event.Info = map[string]string{}
+		}
+		event.Info[key] = value
+		event.audit(message, audit_edited, key+" = "+value)
+		report = fmt.Sprintf(SetInfoReport, event.ref(), escapeMarkdown(key), escapeMarkdown(value))
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+
+	bot.sendReply(chat_id, thread_id, message_id, report)
+}
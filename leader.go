@@ -0,0 +1,41 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+const leader_retry_interval = 5 * time.Second
+
+// acquireLeadership blocks until this instance holds the leader lock.
+func (bot *Bot) acquireLeadership(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	for waiting := false; ; waiting = true {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, syscall.EWOULDBLOCK) {
+			f.Close()
+			return err
+		}
+		if !waiting {
+			bot.log.Info("another instance is the leader, standing by", "lock_file", path)
+		}
+		time.Sleep(leader_retry_interval)
+	}
+
+	// the pid is only there to help operators find the leader
+	f.Truncate(0)
+	fmt.Fprintf(f, "%d\n", os.Getpid())
+	bot.leader_lock = f
+	bot.is_leader.Store(true)
+	bot.log.Info("acquired leader lock", "lock_file", path)
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Locale formats the times and numbers of an event's messages. The message
+// texts themselves stay Russian.
+type Locale struct {
+	TimeLayout string
+	Thousands  string // digit group separator, none if empty
+	Ordinal    func(n int) string
+
+	// command names in the language, to the command they run. Telegram only
+	// lists latin names in the menu, these are for typing.
+	Commands map[string]string
+}
+
+const default_locale = "ru"
+
+var locales = map[string]Locale{
+	// the output the bot always had
+	"ru": {TimeLayout: event_time_layout, Ordinal: strconv.Itoa, Commands: map[string]string{
+		"/регистрация": "/register",
+		"/отписаться":  "/unregister",
+		"/подтвердить": "/confirm",
+		"/список":      "/show",
+		"/история":     "/history",
+		"/справка":     "/help",
+		"/отмена":      "/cancel",
+	}},
+	"en": {TimeLayout: "Jan 2, 2006 3:04 PM", Thousands: ",", Ordinal: englishOrdinal},
+}
+
+// resolveAlias returns the command a localized name like /регистрация stands
+// for, or name itself. Aliases of every locale work in every chat.
+func resolveAlias(name string) string {
+	lower := strings.ToLower(name)
+	for _, locale := range locales {
+		if command, ok := locale.Commands[lower]; ok {
+			return command
+		}
+	}
+	return name
+}
+
+// localeOf returns the named locale, the default one if unknown or empty.
+func localeOf(name string) Locale {
+	if locale, ok := locales[name]; ok {
+		return locale
+	}
+	return locales[default_locale]
+}
+
+func (l Locale) Number(n int) string {
+	digits := strconv.Itoa(n)
+	if l.Thousands == "" {
+		return digits
+	}
+	sign := ""
+	if n < 0 {
+		sign, digits = "-", digits[1:]
+	}
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteString(l.Thousands)
+		}
+		b.WriteRune(d)
+	}
+	return sign + b.String()
+}
+
+// englishOrdinal is 1st, 2nd, 3rd, 4th, ..., 11th, 12th, 13th, 21st.
+func englishOrdinal(n int) string {
+	suffix := "th"
+	if n%100 < 11 || n%100 > 13 {
+		switch n % 10 {
+		case 1:
+			suffix = "st"
+		case 2:
+			suffix = "nd"
+		case 3:
+			suffix = "rd"
+		}
+	}
+	return strconv.Itoa(n) + suffix
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+)
+
+// setupLogging installs the default logger. LOG_FORMAT=json switches to JSON
+// lines for log aggregation, anything else keeps the human-readable text
+// format. LOG_LEVEL=debug|info|warn|error sets the minimum level. Messages
+// logged through the standard log package end up in the same handler.
+func setupLogging() {
+	options := &slog.HandlerOptions{}
+	if level := os.Getenv("LOG_LEVEL"); level != "" {
+		var l slog.Level
+		if err := l.UnmarshalText([]byte(level)); err == nil {
+			options.Level = l
+		}
+	}
+
+	var handler slog.Handler
+	if os.Getenv("LOG_FORMAT") == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, options)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// messageLogger returns a logger annotated with the chat and sender of message.
+func (bot *Bot) messageLogger(message JsonTable) *slog.Logger {
+	return bot.log.With("chat_id", getChatId(message), "user_id", getSenderId(message))
+}
+
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// fatal is fatal logged with the bot's logger, so a process running several
+// bots tells which one failed.
+func (bot *Bot) fatal(msg string, args ...any) {
+	bot.log.Error(msg, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+)
+
+const (
+	MaintenanceMsg   = "Бот на обслуживании, команды временно не выполняются. Попробуйте позже."
+	MaintenanceUsage = "Использование: /maintenance on|off"
+	MaintenanceOn    = "Режим обслуживания включён, команды не выполняются."
+	MaintenanceOff   = "Режим обслуживания выключен."
+)
+
+func (bot *Bot) isInMaintenance() bool {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	return bot.maintenance
+}
+
+func (bot *Bot) setMaintenance(ctx context.Context, message JsonTable, args []string) {
+	user_id := getSenderId(message)
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		bot.sendPrivateMessage(user_id, MaintenanceUsage, false)
+		return
+	}
+
+	bot.state_mux.Lock()
+	bot.maintenance = args[0] == "on"
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	bot.messageLogger(message).Warn("maintenance mode changed", "on", args[0] == "on")
+	text := MaintenanceOff
+	if args[0] == "on" {
+		text = MaintenanceOn
+	}
+	bot.sendPrivateMessage(user_id, text, false)
+}
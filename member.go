@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	MemberUsage       = "Использование: /member <номер или часть имени>"
+	MemberNotFound    = "Нет участника «%s» в событии #%s."
+	MemberAmbiguous   = "Под «%s» подходят несколько участников, укажите номер:"
+	MemberCandidate   = "№%d %s"
+	MemberHeader      = "Участник №%d события #%s"
+	MemberName        = "Имя: "
+	MemberLicense     = "Лицензия: "
+	MemberUserId      = "Telegram id: "
+	MemberNoTelegram  = "не указан (добавлен администратором)"
+	MemberRegistered  = "Зарегистрирован"
+	MemberWaiting     = "В листе ожидания, позиция %d"
+	MemberUnconfirmed = "Ждём подтверждения до %s"
+	MemberSlots       = "Мест: %d"
+	MemberPriority    = "Приоритет: %d"
+	MemberSince       = "Записался: %s"
+	MemberLogHeader   = "Журнал:"
+)
+
+// findMemberByQuery looks the member up by number or, failing that, by a
+// case-insensitive part of the name. Returns every match for a name that
+// isn't unique. Must be called with state_mux held.
+func (e *EventInfo) findMemberByQuery(query string) []MemberRecord {
+	members := append(append([]MemberRecord{}, e.Registrations...), e.Waitlist...)
+	if seq, err := strconv.Atoi(query); err == nil {
+		for _, member := range members {
+			if member.Seq == seq {
+				return []MemberRecord{member}
+			}
+		}
+		return nil
+	}
+	var found []MemberRecord
+	query = strings.ToLower(query)
+	for _, member := range members {
+		if strings.Contains(strings.ToLower(member.Name), query) {
+			found = append(found, member)
+		}
+	}
+	return found
+}
+
+// askMember DMs a button per candidate and returns the Seq of the one picked,
+// 0 if the user cancelled.
+func (bot *Bot) askMember(ctx context.Context, user_id json.Number, question string, candidates []MemberRecord) (int, error) {
+	var rows [][]InlineButton
+	for _, member := range candidates {
+		rows = append(rows, []InlineButton{{fmt.Sprintf(MemberCandidate, member.Seq, member.Name), strconv.Itoa(member.Seq)}})
+	}
+	rows = append(rows, []InlineButton{{RemoveCancelButton, confirm_no}})
+	resp, err := bot.apiCall("sendMessage", JsonTable{
+		"chat_id":      user_id,
+		"text":         question,
+		"parse_mode":   "Markdown",
+		"reply_markup": inlineKeyboard(rows...),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	message_id := getNum(asTbl(resp), "message_id")
+	answer, err := bot.waitForReply(ctx, user_id, message_id)
+	bot.removeKeyboard(user_id, message_id)
+	if err != nil {
+		return 0, err
+	}
+	seq, _ := strconv.Atoi(getStr(asTbl(answer), "data"))
+	return seq, nil
+}
+
+// memberLog picks the log entries about the member: actions they took
+// themselves and actions naming them. Must be called with state_mux held.
+func (e *EventInfo) memberLog(member MemberRecord) []AuditEntry {
+	var entries []AuditEntry
+	for _, entry := range e.Log {
+		if (member.UserId != "" && entry.ActorId == member.UserId) || strings.Contains(entry.Details, member.Name) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// writeMemberCard renders everything stored about the member. Must be called
+// with state_mux held.
+func writeMemberCard(b *TextBuilder, event *EventInfo, member MemberRecord) {
+	b.Bold(fmt.Sprintf(MemberHeader, member.Seq, event.ref())).Line("")
+	b.Text(MemberName).Line(member.Name)
+	if len(event.Fields) > 0 {
+		for i, field := range event.Fields {
+			value := ""
+			if i < len(member.Fields) {
+				value = member.Fields[i]
+			}
+			b.Line(field + ": " + value)
+		}
+	} else if member.License != "" {
+		b.Text(MemberLicense).Line(member.License)
+	}
+	b.Text(MemberUserId)
+	if member.UserId != "" {
+		b.Code(string(member.UserId)).Line("")
+	} else {
+		b.Line(MemberNoTelegram)
+	}
+
+	// by number, imported members have no user id
+	status := MemberRegistered
+	for i, waiting := range event.Waitlist {
+		if waiting.Seq == member.Seq {
+			status = fmt.Sprintf(MemberWaiting, i+1)
+		}
+	}
+	b.Line(status)
+	if !member.ClaimDeadline.IsZero() {
+		b.Line(fmt.Sprintf(MemberUnconfirmed, member.ClaimDeadline.Format(event_time_layout)))
+	}
+	if member.Slots > 1 {
+		b.Line(fmt.Sprintf(MemberSlots, member.Slots))
+	}
+	if member.Priority > 0 {
+		b.Line(fmt.Sprintf(MemberPriority, member.Priority))
+	}
+
+	entries := event.memberLog(member)
+	for _, entry := range entries {
+		if entry.Action == audit_registered || entry.Action == audit_waitlisted {
+			b.Line(fmt.Sprintf(MemberSince, entry.Time.Format(event_time_layout)))
+			break
+		}
+	}
+	if len(entries) > 0 {
+		b.Text("\n").Line(MemberLogHeader)
+		for _, entry := range entries {
+			line := entry.Time.Format(event_time_layout) + " " + audit_labels[entry.Action]
+			if entry.Details != "" {
+				line += ": " + entry.Details
+			}
+			b.Line(line)
+		}
+	}
+}
+
+// showMember sends an admin the full record of one member of the active
+// event, for the check-in desk.
+func (bot *Bot) showMember(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+
+	if len(args) == 0 {
+		bot.respond(ctx, message, MemberUsage)
+		return
+	}
+	query := strings.Join(args, " ")
+
+	b := &TextBuilder{}
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	if !ok {
+		bot.state_mux.Unlock()
+		bot.respond(ctx, message, NoActiveEventMsg)
+		return
+	}
+	found := event.findMemberByQuery(query)
+	switch len(found) {
+	case 0:
+		b.Text(fmt.Sprintf(MemberNotFound, query, event.ref()))
+	case 1:
+		writeMemberCard(b, event, found[0])
+	default:
+		b.Text(fmt.Sprintf(MemberAmbiguous, query))
+		for _, member := range found {
+			b.Text("\n" + fmt.Sprintf(MemberCandidate, member.Seq, member.Name))
+		}
+	}
+	bot.state_mux.Unlock()
+
+	bot.respondFormatted(ctx, message, b)
+}
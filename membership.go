@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	MinAgeBlocked = "Регистрация доступна участникам канала, состоящим в нём не меньше %d дн. Вы сможете зарегистрироваться после %s."
+)
+
+func (bot *Bot) recordJoin(chat_id json.Number, user_id json.Number, date int64) {
+	if chat_id == "" || user_id == "" {
+		return
+	}
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	if bot.join_dates[chat_id] == nil {
+		bot.join_dates[chat_id] = map[json.Number]time.Time{}
+	}
+	bot.join_dates[chat_id][user_id] = time.Unix(date, 0)
+	bot.saveState()
+}
+
+func (bot *Bot) forgetJoin(chat_id json.Number, user_id json.Number) {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	if _, ok := bot.join_dates[chat_id][user_id]; ok {
+		delete(bot.join_dates[chat_id], user_id)
+		bot.saveState()
+	}
+}
+
+// processMemberUpdate tracks a chat_member update. Only delivered when the
+// bot is a chat admin.
+func (bot *Bot) processMemberUpdate(update JsonTable) {
+	chat_id := getNum(getTbl(update, "chat"), "id")
+	member := getTbl(update, "new_chat_member")
+	user_id := getNum(getTbl(member, "user"), "id")
+	was := getStr(getTbl(update, "old_chat_member"), "status")
+	switch getStr(member, "status") {
+	case "member", "restricted":
+		if was == "left" || was == "kicked" {
+			bot.recordJoin(chat_id, user_id, getInt(update, "date"))
+		}
+	case "left", "kicked":
+		bot.forgetJoin(chat_id, user_id)
+	}
+}
+
+// trackJoins records users from a "new members" service message, for chats
+// where the bot isn't an admin and gets no chat_member updates.
+func (bot *Bot) trackJoins(message JsonTable) {
+	joined, _ := message["new_chat_members"].(JsonArray)
+	for _, user := range joined {
+		if user, ok := user.(JsonTable); ok {
+			bot.recordJoin(getChatId(message), getNum(user, "id"), getInt(message, "date"))
+		}
+	}
+}
+
+// joinedAt reports when the user became a chat member, if known.
+func (bot *Bot) joinedAt(chat_id json.Number, user_id json.Number) (time.Time, bool) {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	joined, ok := bot.join_dates[chat_id][user_id]
+	return joined, ok
+}
+
+// memberAgeBlock returns the explanation if the chat's minimum membership
+// rule stops the user from registering, or "" if they may register.
+func (bot *Bot) memberAgeBlock(chat_id json.Number, user_id json.Number) string {
+	days := bot.getChatConfig(chat_id).MinMemberDays
+	if days <= 0 {
+		return ""
+	}
+	joined, ok := bot.joinedAt(chat_id, user_id)
+	allowed := joined.AddDate(0, 0, days)
+	if !ok || !time.Now().Before(allowed) {
+		return ""
+	}
+	return fmt.Sprintf(MinAgeBlocked, days, allowed.Format(event_time_layout))
+}
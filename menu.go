@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+)
+
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+// menuCommands lists the described commands that need at most the given
+// access, sorted by name.
+func (bot *Bot) menuCommands(access ...Access) []BotCommand {
+	allowed := map[Access]bool{AccessAnyone: true}
+	for _, a := range access {
+		allowed[a] = true
+	}
+	var menu []BotCommand
+	for name, command := range bot.commands {
+		if command.Description != "" && allowed[command.Access] {
+			menu = append(menu, BotCommand{strings.TrimPrefix(name, "/"), command.Description})
+		}
+	}
+	sort.Slice(menu, func(i, j int) bool { return menu[i].Command < menu[j].Command })
+	return menu
+}
+
+// withoutDisabled drops the commands a chat turned off from menu.
+func withoutDisabled(menu []BotCommand, disabled map[string]bool) []BotCommand {
+	var kept []BotCommand
+	for _, command := range menu {
+		if !disabled["/"+command.Command] {
+			kept = append(kept, command)
+		}
+	}
+	return kept
+}
+
+func (bot *Bot) setMyCommands(scope JsonTable, menu []BotCommand) {
+	if _, err := bot.apiCall("setMyCommands", JsonTable{"commands": menu, "scope": scope}); err != nil {
+		bot.log.Warn("failed to set command menu", "scope", scope["type"], "error", err)
+	}
+}
+
+// setupCommandMenus registers the command menus so that everyone only sees
+// the commands they may run: members the public ones, chat admins also the
+// admin and read-only commands, observers in their private chat the read-only
+// ones and bot operators there all of them.
+func (bot *Bot) setupCommandMenus() {
+	bot.setMyCommands(JsonTable{"type": "default"}, bot.menuCommands())
+	bot.setMyCommands(JsonTable{"type": "all_chat_administrators"}, bot.menuCommands(AccessObserver, AccessChatAdmin))
+	for user_id := range bot.bot_observers {
+		if !bot.bot_admins[user_id] {
+			bot.setMyCommands(JsonTable{"type": "chat", "chat_id": user_id}, bot.menuCommands(AccessObserver))
+		}
+	}
+	for user_id := range bot.bot_admins {
+		bot.setMyCommands(JsonTable{"type": "chat", "chat_id": user_id}, bot.menuCommands(AccessObserver, AccessChatAdmin, AccessBotAdmin))
+	}
+
+	bot.state_mux.Lock()
+	var chats []json.Number
+	for chat_id, config := range bot.chat_configs {
+		if len(config.DisabledCommands) > 0 {
+			chats = append(chats, chat_id)
+		}
+	}
+	bot.state_mux.Unlock()
+	for _, chat_id := range chats {
+		bot.setupChatMenus(chat_id)
+	}
+}
+
+// setupChatMenus gives a chat with disabled commands menus of its own, for
+// members and for admins. Once nothing is disabled they are removed and the
+// chat falls back to the bot-wide menus.
+func (bot *Bot) setupChatMenus(chat_id json.Number) {
+	disabled := bot.getChatConfig(chat_id).DisabledCommands
+	scopes := []struct {
+		scope JsonTable
+		menu  []BotCommand
+	}{
+		{JsonTable{"type": "chat", "chat_id": chat_id}, bot.menuCommands()},
+		{JsonTable{"type": "chat_administrators", "chat_id": chat_id}, bot.menuCommands(AccessObserver, AccessChatAdmin)},
+	}
+	for _, s := range scopes {
+		if len(disabled) == 0 {
+			if _, err := bot.apiCall("deleteMyCommands", JsonTable{"scope": s.scope}); err != nil {
+				bot.log.Warn("failed to delete command menu", "scope", s.scope["type"], "chat_id", chat_id, "error", err)
+			}
+			continue
+		}
+		bot.setMyCommands(s.scope, withoutDisabled(s.menu, disabled))
+	}
+}
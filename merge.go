@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	MergeUsage    = "Использование: /merge <номер события-источника> <номер события-получателя>"
+	MergeNotFound = "В этом канале нет активного события #%s."
+	MergeSameMsg  = "Нельзя объединить событие само с собой."
+	MergeAsk      = "Перенести всех участников события #%s в #%s и закрыть #%s?"
+	MergeReport   = "Событие #%s объединено с #%s: перенесено %d, пропущено дубликатов %d."
+)
+
+// findChatEvent returns the active event of the chat with the given id. Must
+// be called with state_mux held.
+func (bot *Bot) findChatEvent(chat_id json.Number, event_id int) *EventInfo {
+	for _, event := range bot.chatEvents(chat_id) {
+		if event.EventId == event_id {
+			return event
+		}
+	}
+	return nil
+}
+
+// hasDuplicate reports whether the event already has this user or license.
+func (e *EventInfo) hasDuplicate(member MemberRecord) bool {
+	for _, list := range [][]MemberRecord{e.Registrations, e.Waitlist} {
+		for _, existing := range list {
+			if (member.UserId != "" && existing.UserId == member.UserId) ||
+				(member.License != "" && existing.License == member.License) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mergeInto moves the members of src into dst, registered members first,
+// renumbering them and filling free slots before the waitlist. Must be called
+// with state_mux held.
+func mergeInto(src *EventInfo, dst *EventInfo) (moved int, skipped int) {
+	for _, member := range append(append([]MemberRecord{}, src.Registrations...), src.Waitlist...) {
+		if dst.hasDuplicate(member) {
+			skipped++
+			continue
+		}
+		member.Seq = dst.nextSeq()
+		if !dst.fits(member) {
+			member.ClaimDeadline = time.Time{}
+			dst.addToWaitlist(member)
+		} else {
+			dst.Registrations = append(dst.Registrations, member)
+		}
+		moved++
+	}
+	src.Registrations, src.Waitlist = nil, nil
+	return moved, skipped
+}
+
+func (bot *Bot) mergeEvents(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+	user_id := getSenderId(message)
+
+	if len(args) != 2 {
+		bot.sendReply(chat_id, thread_id, message_id, MergeUsage)
+		return
+	}
+	bot.state_mux.Lock()
+	src, dst := bot.findActiveRef(chat_id, args[0]), bot.findActiveRef(chat_id, args[1])
+	var src_id, dst_id string
+	if src != nil && dst != nil {
+		src_id, dst_id = src.ref(), dst.ref()
+	}
+	bot.state_mux.Unlock()
+	if src == nil {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(MergeNotFound, escapeMarkdown(args[0])))
+		return
+	}
+	if dst == nil {
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(MergeNotFound, escapeMarkdown(args[1])))
+		return
+	}
+	if src == dst {
+		bot.sendReply(chat_id, thread_id, message_id, MergeSameMsg)
+		return
+	}
+
+	confirmed, err := bot.askConfirmation(ctx, user_id, fmt.Sprintf(MergeAsk, src_id, dst_id, src_id))
+	if err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	if !confirmed {
+		bot.sendPrivateMessage(user_id, EventCloseCancelled, false)
+		return
+	}
+
+	bot.state_mux.Lock()
+	// either event could have been closed while waiting for the answer
+	if bot.findActiveRef(chat_id, src_id) != src || bot.findActiveRef(chat_id, dst_id) != dst {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	moved, skipped := mergeInto(src, dst)
+	bot.archiveEvent(src)
+	src.audit(message, audit_merged, fmt.Sprintf("→ #%s", dst_id))
+	src.audit(message, audit_closed, "")
+	dst.audit(message, audit_merged, fmt.Sprintf("← #%s: %d", src_id, moved))
+	notice := bot.capacityNotice(dst)
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(MergeReport, src_id, dst_id, moved, skipped))
+	bot.announceCapacity(dst, notice)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ChatMigratedError is returned when a group has been upgraded to a
+// supergroup and has to be addressed by the new id from now on.
+type ChatMigratedError struct {
+	Description string
+	NewChatId   json.Number
+}
+
+func (e *ChatMigratedError) Error() string {
+	return fmt.Sprintf("%s (migrated to %s)", e.Description, e.NewChatId)
+}
+
+// migrateChat moves everything the bot keeps about old_id over to new_id.
+// The bot may have seen new_id already through another update. Then the
+// events and settings it has for new_id win, the old active event is
+// closed into the history, and membership and usage data are merged.
+func (bot *Bot) migrateChat(old_id json.Number, new_id json.Number) {
+	if old_id == "" || new_id == "" || old_id == new_id {
+		return
+	}
+	bot.log.Warn("chat migrated to supergroup", "chat_id", old_id, "new_chat_id", new_id)
+	conflict := func(what string) {
+		bot.log.Warn("migrated chat already known under the new id, keeping its "+what, "chat_id", old_id, "new_chat_id", new_id)
+	}
+
+	bot.state_mux.Lock()
+	// an old event in the way is archived under old_id, the history moves
+	// over below
+	for key, event := range bot.current_events {
+		if key.ChatId != old_id {
+			continue
+		}
+		new_key := EventKey{new_id, key.ThreadId}
+		if _, ok := bot.current_events[new_key]; ok {
+			conflict("active event")
+			bot.archiveEvent(event)
+			event.audit(nil, audit_closed, "")
+			continue
+		}
+		delete(bot.current_events, key)
+		event.ChatId = new_id
+		bot.current_events[new_key] = event
+	}
+	if config, ok := bot.chat_configs[old_id]; ok {
+		delete(bot.chat_configs, old_id)
+		if _, ok := bot.chat_configs[new_id]; ok {
+			conflict("settings")
+		} else {
+			bot.chat_configs[new_id] = config
+		}
+	}
+	if info, ok := bot.chat_infos[old_id]; ok {
+		delete(bot.chat_infos, old_id)
+		if _, ok := bot.chat_infos[new_id]; !ok {
+			bot.chat_infos[new_id] = info
+		}
+	}
+	if joined, ok := bot.join_dates[old_id]; ok {
+		delete(bot.join_dates, old_id)
+		if bot.join_dates[new_id] == nil {
+			bot.join_dates[new_id] = joined
+		} else {
+			for user_id, date := range joined {
+				// the earlier join is the one that counts for /set minage
+				if known, ok := bot.join_dates[new_id][user_id]; !ok || date.Before(known) {
+					bot.join_dates[new_id][user_id] = date
+				}
+			}
+		}
+	}
+	if banned, ok := bot.chat_bans[old_id]; ok {
+		delete(bot.chat_bans, old_id)
+		if bot.chat_bans[new_id] == nil {
+			bot.chat_bans[new_id] = banned
+		} else {
+			for user_id := range banned {
+				bot.chat_bans[new_id][user_id] = true
+			}
+		}
+	}
+	for _, chats := range bot.command_usage {
+		if counter, ok := chats[old_id]; ok {
+			delete(chats, old_id)
+			if known, ok := chats[new_id]; ok {
+				known.Calls += counter.Calls
+				known.Denied += counter.Denied
+				known.Failed += counter.Failed
+			} else {
+				chats[new_id] = counter
+			}
+		}
+	}
+	if events, ok := bot.chat_history[old_id]; ok {
+		delete(bot.chat_history, old_id)
+		for _, event := range events {
+			event.ChatId = new_id
+		}
+		bot.chat_history[new_id] = append(bot.chat_history[new_id], events...)
+	}
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	bot.admins_cache_mux.Lock()
+	delete(bot.admins_cache, old_id)
+	bot.admins_cache_mux.Unlock()
+}
+
+// retryMigrated repeats a call that failed because its chat_id was migrated.
+// Returns false if err isn't about a migration.
+func (bot *Bot) retryMigrated(tg_func string, msg JsonTable, err error) (JsonAny, bool, error) {
+	var migrated *ChatMigratedError
+	if !errors.As(err, &migrated) {
+		return nil, false, err
+	}
+
+	old_id := json.Number(fmt.Sprint(msg["chat_id"]))
+	if old_id == migrated.NewChatId {
+		return nil, false, err
+	}
+	bot.migrateChat(old_id, migrated.NewChatId)
+
+	retry := JsonTable{}
+	for k, v := range msg {
+		retry[k] = v
+	}
+	retry["chat_id"] = migrated.NewChatId
+	result, err := bot.apiCall(tg_func, retry)
+	return result, true, err
+}
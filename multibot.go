@@ -0,0 +1,138 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// BotSpec is one of the bots listed in BOT_TOKENS.
+type BotSpec struct {
+	Name  string
+	Token string
+}
+
+var bot_name_re = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// files a bot keeps to itself, namespaced by the bot name unless set per bot
+var bot_path_vars = []string{"BOT_STATE_FILE", "BOT_OUTBOX_FILE", "BOT_LOCK_FILE", "BOT_FILES_DIR", "BOT_BACKUP_DIR"}
+
+// addresses can't be shared, a bot only listens on the ones set for it
+var bot_addr_vars = []string{"BOT_HEALTH_ADDR", "BOT_WEBHOOK_ADDR", "BOT_WEBHOOK_URL", "BOT_CALENDAR_URL"}
+
+// parseBots parses BOT_TOKENS, a comma separated list of name=token.
+func parseBots(value string) ([]BotSpec, error) {
+	var specs []BotSpec
+	seen := map[string]bool{}
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		name, token, ok := strings.Cut(item, "=")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !ok || !bot_name_re.MatchString(name) || strings.TrimSpace(token) == "" {
+			return nil, fmt.Errorf("expected name=token, got %q", name)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("bot %s listed twice", name)
+		}
+		seen[name] = true
+		specs = append(specs, BotSpec{name, strings.TrimSpace(token)})
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("no bots listed")
+	}
+	return specs, nil
+}
+
+func namespacePath(path string, name string) string {
+	return filepath.Join(filepath.Dir(path), name+"-"+filepath.Base(path))
+}
+
+// botGetenv looks up the configuration of spec: BOT_<NAME>_* variables
+// override the shared BOT_* ones, paths get the bot name as a prefix and
+// listen addresses are only taken from the bot's own variables.
+func botGetenv(spec BotSpec, lookup func(key string) (string, bool)) func(key string) string {
+	prefix := "BOT_" + strings.ToUpper(spec.Name) + "_"
+	defaults := map[string]string{"BOT_STATE_FILE": default_state_file, "BOT_FILES_DIR": "files", "BOT_BACKUP_DIR": "backups"}
+	return func(key string) string {
+		if key == "BOT_TOKEN" {
+			return spec.Token
+		}
+		if value, ok := lookup(prefix + strings.TrimPrefix(key, "BOT_")); ok {
+			return value
+		}
+		if slices.Contains(bot_addr_vars, key) {
+			return ""
+		}
+		value, _ := lookup(key)
+		if slices.Contains(bot_path_vars, key) {
+			if value == "" {
+				value = defaults[key]
+			}
+			if value != "" {
+				value = namespacePath(value, spec.Name)
+			}
+		}
+		return value
+	}
+}
+
+// checkListeners rejects bots registering the same webhook or listening on
+// the same address, the second listener would fail and stop the process.
+func checkListeners(bots []*Bot) error {
+	taken := map[string]string{}
+	claim := func(bot *Bot, key string) error {
+		if other, ok := taken[key]; ok {
+			return fmt.Errorf("bots %s and %s both use %s", other, bot.instance, key)
+		}
+		taken[key] = bot.instance
+		return nil
+	}
+	for _, bot := range bots {
+		if bot.health_addr != "" {
+			if err := claim(bot, "address "+bot.health_addr); err != nil {
+				return err
+			}
+		}
+		if bot.webhook_url == "" {
+			continue
+		}
+		if err := claim(bot, "webhook "+bot.webhook_url); err != nil {
+			return err
+		}
+		if err := claim(bot, "address "+bot.webhookAddr()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runBots runs every bot of specs in this process, each with its own state,
+// commands, poll loop and breaker. They share the HTTP clients and
+// send_limiter, so together they stay within the rate limits of the server.
+func runBots(specs []BotSpec, client *http.Client, long_client *http.Client) {
+	bots := make([]*Bot, 0, len(specs))
+	for _, spec := range specs {
+		bot := newBot(tg_api_url, spec.Token, client, long_client)
+		bot.instance = spec.Name
+		bot.log = slog.With("bot", spec.Name)
+		bot.breaker.log = bot.log
+		bot.configure(botGetenv(spec, os.LookupEnv))
+		bots = append(bots, bot)
+	}
+	if err := checkListeners(bots); err != nil {
+		fatal("Invalid BOT_TOKENS", "error", err)
+	}
+	publishStatus(bots)
+	for _, bot := range bots {
+		go bot.run("")
+	}
+	waitForShutdown(bots)
+}
@@ -0,0 +1,125 @@
+package main
+
+import "testing"
+
+func TestBotGetenv(t *testing.T) {
+	env := map[string]string{
+		"BOT_ADMINS":             "1",
+		"BOT_DRIFT_ADMINS":       "2",
+		"BOT_DRIFT_TYPING":       "",
+		"BOT_TYPING":             "0",
+		"BOT_OUTBOX_FILE":        "/var/lib/bot/outbox.json",
+		"BOT_DRIFT_LOCK_FILE":    "/run/drift.lock",
+		"BOT_LOCK_FILE":          "/run/bot.lock",
+		"BOT_HEALTH_ADDR":        ":8080",
+		"BOT_DRIFT_WEBHOOK_ADDR": ":8443",
+		"BOT_WEBHOOK_URL":        "https://bot.example.com/hook",
+	}
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	getenv := botGetenv(BotSpec{"drift", "token"}, lookup)
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"BOT_TOKEN", "token"},
+		{"BOT_ADMINS", "2"},
+		{"BOT_TYPING", ""}, // set empty for the bot
+		{"BOT_OBSERVERS", ""},
+		{"BOT_OUTBOX_FILE", "/var/lib/bot/drift-outbox.json"},
+		{"BOT_STATE_FILE", "drift-" + default_state_file},
+		{"BOT_LOCK_FILE", "/run/drift.lock"}, // the bot's own isn't prefixed
+		{"BOT_HEALTH_ADDR", ""},
+		{"BOT_WEBHOOK_ADDR", ":8443"},
+		{"BOT_WEBHOOK_URL", ""}, // a shared one would be registered by every bot
+	}
+	for _, test := range tests {
+		if got := getenv(test.key); got != test.want {
+			t.Errorf("%s = %q, want %q", test.key, got, test.want)
+		}
+	}
+}
+
+// Bots configured from the same environment keep their state apart and
+// offer the commands set for them.
+func TestConfigureBots(t *testing.T) {
+	env := map[string]string{
+		"BOT_ADMINS":                 "1",
+		"BOT_TIME_ATTACK_ADMINS":     "2",
+		"BOT_TIME_ATTACK_COMMANDS":   "open, /close,register",
+		"BOT_DRIFT_HEALTH_ADDR":      ":8081",
+		"BOT_TIME_ATTACK_STATE_FILE": "ta.json",
+	}
+	lookup := func(key string) (string, bool) {
+		value, ok := env[key]
+		return value, ok
+	}
+	drift, _ := newTestBot(t)
+	drift.configure(botGetenv(BotSpec{"drift", "a"}, lookup))
+	time_attack, _ := newTestBot(t)
+	time_attack.configure(botGetenv(BotSpec{"time_attack", "b"}, lookup))
+
+	if path := drift.store.(*FileStore).Path; path != "drift-"+default_state_file {
+		t.Errorf("drift state in %s", path)
+	}
+	if path := time_attack.store.(*FileStore).Path; path != "ta.json" {
+		t.Errorf("time_attack state in %s", path)
+	}
+	if !drift.bot_admins["1"] || drift.bot_admins["2"] || !time_attack.bot_admins["2"] || time_attack.bot_admins["1"] {
+		t.Errorf("admins %v and %v, want 1 and 2", drift.bot_admins, time_attack.bot_admins)
+	}
+	if drift.health_addr != ":8081" || time_attack.health_addr != "" {
+		t.Errorf("health on %q and %q", drift.health_addr, time_attack.health_addr)
+	}
+
+	if len(drift.commands) != len(drift.builtinCommands()) {
+		t.Errorf("drift has %d commands, want all", len(drift.commands))
+	}
+	for name, command := range time_attack.builtinCommands() {
+		_, has := time_attack.commands[name]
+		want := command.Core || name == "/open" || name == "/close" || name == "/register"
+		if has != want {
+			t.Errorf("time_attack has %s: %v, want %v", name, has, want)
+		}
+	}
+}
+
+func TestKeepUnknownCommand(t *testing.T) {
+	bot, _ := newTestBot(t)
+	if err := bot.keepCommands([]string{"open", "reigster"}); err == nil {
+		t.Error("keepCommands accepted /reigster")
+	}
+	if len(bot.commands) != len(bot.builtinCommands()) {
+		t.Error("keepCommands dropped commands after an error")
+	}
+}
+
+func TestCheckListeners(t *testing.T) {
+	type listeners struct{ health, webhook_url, webhook_addr string }
+	tests := []struct {
+		name  string
+		bots  []listeners
+		valid bool
+	}{
+		{"polling", []listeners{{}, {}}, true},
+		{"own addresses", []listeners{{":8081", "https://a/hook", ":8443"}, {":8082", "https://b/hook", ":8444"}}, true},
+		{"same health address", []listeners{{health: ":8081"}, {health: ":8081"}}, false},
+		{"same webhook", []listeners{{"", "https://a/hook", ":8443"}, {"", "https://a/hook", ":8444"}}, false},
+		{"default webhook address", []listeners{{webhook_url: "https://a/hook"}, {webhook_url: "https://b/hook"}}, false},
+		{"health on the webhook address", []listeners{{health: ":8443"}, {webhook_url: "https://b/hook"}}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var bots []*Bot
+			for i, l := range test.bots {
+				bots = append(bots, &Bot{instance: string(rune('a' + i)), health_addr: l.health,
+					webhook_url: l.webhook_url, webhook_addr: l.webhook_addr})
+			}
+			if err := checkListeners(bots); (err == nil) != test.valid {
+				t.Errorf("checkListeners = %v, want valid %v", err, test.valid)
+			}
+		})
+	}
+}
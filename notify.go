@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	OptOutReport = "Вы отписались от уведомлений. Вернуть их можно командой /optin."
+	OptInReport  = "Уведомления включены. Отписаться можно командой /optout."
+)
+
+func (bot *Bot) isOptedOut(user_id json.Number) bool {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	return bot.opted_out[user_id]
+}
+
+// sendNotification DMs an informational message unless the user opted out.
+// Messages the user has to act on are sent with sendPrivateMessage directly.
+func (bot *Bot) sendNotification(user_id json.Number, text string) {
+	if user_id == "" || bot.isOptedOut(user_id) {
+		return
+	}
+	bot.queueMessage(JsonTable{"chat_id": user_id, "text": text, "parse_mode": "Markdown"}, nil)
+}
+
+func (bot *Bot) setOptOut(user_id json.Number, out bool) {
+	bot.state_mux.Lock()
+	if out {
+		bot.opted_out[user_id] = true
+	} else {
+		delete(bot.opted_out, user_id)
+	}
+	bot.saveState()
+	bot.state_mux.Unlock()
+}
+
+func (bot *Bot) optOut(ctx context.Context, message JsonTable, args []string) {
+	user_id := getSenderId(message)
+	bot.setOptOut(user_id, true)
+	bot.sendPrivateMessage(user_id, OptOutReport, false)
+}
+
+func (bot *Bot) optIn(ctx context.Context, message JsonTable, args []string) {
+	user_id := getSenderId(message)
+	bot.setOptOut(user_id, false)
+	bot.sendPrivateMessage(user_id, OptInReport, false)
+}
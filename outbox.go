@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	outbox_attempts    = 5
+	outbox_retry_delay = 5 * time.Second
+)
+
+// OutboxItem is a queued API call. Done, if set, is called once the call went
+// out or was given up on. It doesn't survive a restart, the call itself does
+// when the outbox is persisted.
+type OutboxItem struct {
+	Method  string
+	Request JsonTable
+	Done    func(result JsonAny, err error) `json:"-"`
+}
+
+// Outbox serializes notifications and other sends nobody waits for: they go
+// out one by one through send_limiter and are retried when the API can't be
+// reached. Interactive replies and prompts are sent directly, they shouldn't
+// queue up behind a broadcast.
+type Outbox struct {
+	path string // empty keeps the queue in memory only
+	log  *slog.Logger
+
+	mux     sync.Mutex
+	items   []*OutboxItem
+	closing bool
+	wake    chan struct{}
+	drained chan struct{}
+}
+
+// newOutbox creates the outbox, picking up calls persisted in path by the
+// previous run.
+func newOutbox(path string, log *slog.Logger) (*Outbox, error) {
+	o := &Outbox{path: path, log: log, wake: make(chan struct{}, 1), drained: make(chan struct{})}
+	if path == "" {
+		return o, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return o, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err = d.Decode(&o.items); err != nil {
+		return nil, err
+	}
+	if len(o.items) > 0 {
+		o.log.Info("resuming queued sends", "count", len(o.items))
+	}
+	return o, nil
+}
+
+func (o *Outbox) Enqueue(item *OutboxItem) {
+	o.mux.Lock()
+	o.items = append(o.items, item)
+	o.persist()
+	o.mux.Unlock()
+	o.signal()
+}
+
+func (o *Outbox) signal() {
+	select {
+	case o.wake <- struct{}{}:
+	default:
+	}
+}
+
+// persist saves the queue. Must be called with o.mux held.
+func (o *Outbox) persist() {
+	if o.path == "" {
+		return
+	}
+	data, err := json.Marshal(o.items)
+	if err == nil {
+		err = writeFileAtomic(o.path, data)
+	}
+	if err != nil {
+		o.log.Error("failed to save outbox", "file", o.path, "error", err)
+	}
+}
+
+// run delivers queued calls until Drain is called and the queue is empty.
+func (o *Outbox) run(bot *Bot) {
+	for {
+		o.mux.Lock()
+		if len(o.items) == 0 {
+			closing := o.closing
+			o.mux.Unlock()
+			if closing {
+				close(o.drained)
+				return
+			}
+			<-o.wake
+			continue
+		}
+		item := o.items[0]
+		o.mux.Unlock()
+
+		result, err := o.deliver(bot, item)
+
+		o.mux.Lock()
+		o.items = o.items[1:]
+		o.persist()
+		o.mux.Unlock()
+		if item.Done != nil {
+			item.Done(result, err)
+		}
+	}
+}
+
+// deliver makes the call, apiCall retries network errors itself. The call is
+// only repeated here when it wasn't sent at all: rate limited or held back by
+// the open breaker.
+func (o *Outbox) deliver(bot *Bot, item *OutboxItem) (JsonAny, error) {
+	for attempt := 1; ; attempt++ {
+		send_limiter.Wait()
+		result, err := bot.apiCall(item.Method, item.Request)
+		var api_err TgApiError
+		switch {
+		case attempt == outbox_attempts:
+		case errors.Is(err, ErrRateLimited) && errors.As(err, &api_err):
+			bot.log.Warn("queued send rate limited", "api_func", item.Method, "retry_after", api_err.RetryAfter)
+			time.Sleep(max(api_err.RetryAfter, outbox_retry_delay))
+			continue
+		case errors.Is(err, ErrBreakerOpen):
+			time.Sleep(outbox_retry_delay)
+			continue
+		}
+		if err != nil {
+			bot.log.Warn("queued send failed", "api_func", item.Method,
+				"chat_id", item.Request["chat_id"], "error", err)
+		}
+		return result, err
+	}
+}
+
+// Drain waits up to timeout for the queue to empty. Calls still queued then
+// are lost unless the outbox is persisted.
+func (o *Outbox) Drain(timeout time.Duration) {
+	o.mux.Lock()
+	o.closing = true
+	o.mux.Unlock()
+	o.signal()
+
+	select {
+	case <-o.drained:
+	case <-time.After(timeout):
+		o.mux.Lock()
+		o.log.Warn("outbox not drained", "pending", len(o.items), "persisted", o.path != "")
+		o.mux.Unlock()
+	}
+}
+
+// queueMessage sends a message through the outbox. done may be nil.
+func (bot *Bot) queueMessage(request JsonTable, done func(result JsonAny, err error)) {
+	bot.outbox.Enqueue(&OutboxItem{Method: "sendMessage", Request: request, Done: done})
+}
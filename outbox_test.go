@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// An error the API answered with is final: the outbox doesn't repeat the
+// call whatever the retry policy, apiCall has retried network errors already.
+func TestOutboxDoesNotRetryApiErrors(t *testing.T) {
+	for name, policy := range map[string]RetryPolicy{"safe": RetrySafe, "always": RetryAlways} {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			bot, api := newTestBot(t)
+			bot.send_retry_policy = policy
+			api.fail("sendMessage", 400, "Bad Request: chat not found")
+			go bot.outbox.run(bot)
+
+			done := make(chan error, 1)
+			bot.queueMessage(JsonTable{"chat_id": "5", "text": "hi"}, func(result JsonAny, err error) {
+				done <- err
+			})
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Error("the failed send reported success")
+				}
+			case <-time.After(time.Second):
+				t.Fatal("the outbox is still retrying")
+			}
+			if calls := api.sent("sendMessage"); len(calls) != 1 {
+				t.Errorf("sendMessage called %d times, want once", len(calls))
+			}
+		})
+	}
+}
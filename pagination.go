@@ -0,0 +1,202 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const page_size = 20
+
+const PageDeniedMsg = "Этот список вам недоступен."
+
+// PageRenderer returns the lines of a paged listing. It is called again on
+// every page switch so the listing is always current.
+type PageRenderer = func(user_id json.Number, arg string) (header string, lines []string)
+
+// PagedListing is a listing sendPaged can page through. Anyone can send any
+// callback data, so Access, who may see it, is checked again on every page
+// switch. Chat returns the chat arg is about, whose admins or members the
+// access is checked against, "" for listings about the whole bot. PageSize,
+// if set, gives the lines per page instead of page_size.
+type PagedListing struct {
+	Render   PageRenderer
+	Access   Access
+	Chat     func(arg string) json.Number
+	PageSize func(arg string) int
+}
+
+// argChat is the Chat of the listings whose arg is a chat id.
+func argChat(arg string) json.Number {
+	return json.Number(arg)
+}
+
+// pagedListings maps a callback data prefix to the listings of bot. Button
+// data is "<prefix>:<page>:<arg>".
+func (bot *Bot) pagedListings() map[string]PagedListing {
+	return map[string]PagedListing{
+		"chats": {Render: bot.renderChats, Access: AccessBotAdmin},
+		"history": {
+			Render:   cachedRenderer(bot.renderHistory, history_cache_ttl),
+			Access:   AccessAnyone,
+			Chat:     argChat,
+			PageSize: func(arg string) int { return bot.getChatConfig(json.Number(arg)).historyPageSize() },
+		},
+		"log":   {Render: bot.renderLog, Access: AccessObserver, Chat: logChat},
+		"usage": {Render: bot.renderUsage, Access: AccessObserver, Chat: argChat},
+	}
+}
+
+func (bot *Bot) pageSize(prefix string, arg string) int {
+	if size := bot.paged_listings[prefix].PageSize; size != nil {
+		return size(arg)
+	}
+	return page_size
+}
+
+// cachedRenderer keeps what render returned for a user and arg for ttl, so
+// paging through a listing that is costly to render stays quick. The pages
+// may lag behind changes by up to ttl.
+func cachedRenderer(render PageRenderer, ttl time.Duration) PageRenderer {
+	type key struct {
+		user_id json.Number
+		arg     string
+	}
+	type entry struct {
+		header  string
+		lines   []string
+		expires time.Time
+	}
+	cache := map[key]entry{}
+	var mux sync.Mutex
+	return func(user_id json.Number, arg string) (string, []string) {
+		now := time.Now()
+		mux.Lock()
+		for k, e := range cache {
+			if now.After(e.expires) {
+				delete(cache, k)
+			}
+		}
+		e, ok := cache[key{user_id, arg}]
+		mux.Unlock()
+		if ok {
+			return e.header, e.lines
+		}
+
+		header, lines := render(user_id, arg)
+		mux.Lock()
+		cache[key{user_id, arg}] = entry{header, lines, now.Add(ttl)}
+		mux.Unlock()
+		return header, lines
+	}
+}
+
+func pageText(header string, lines []string, page int, size int) (string, int) {
+	pages := (len(lines) + size - 1) / size
+	if pages == 0 {
+		pages = 1
+	}
+	page = max(0, min(page, pages-1))
+	end := min(len(lines), (page+1)*size)
+	text := header
+	if page*size < end {
+		text += "\n" + strings.Join(lines[page*size:end], "\n")
+	}
+	return text, pages
+}
+
+func pageKeyboard(prefix string, arg string, page int, pages int) JsonTable {
+	if pages <= 1 {
+		return nil
+	}
+	var row []InlineButton
+	if page > 0 {
+		row = append(row, InlineButton{"◀", fmt.Sprintf("%s:%d:%s", prefix, page-1, arg)})
+	}
+	row = append(row, InlineButton{fmt.Sprintf("%d/%d", page+1, pages), fmt.Sprintf("%s:%d:%s", prefix, page, arg)})
+	if page < pages-1 {
+		row = append(row, InlineButton{"▶", fmt.Sprintf("%s:%d:%s", prefix, page+1, arg)})
+	}
+	return inlineKeyboard(row)
+}
+
+// sendPaged posts the first page of a listing to chat_id.
+func (bot *Bot) sendPaged(chat_id json.Number, user_id json.Number, prefix string, arg string) {
+	header, lines := bot.paged_listings[prefix].Render(user_id, arg)
+	text, pages := pageText(header, lines, 0, bot.pageSize(prefix, arg))
+	request := JsonTable{
+		"chat_id":    chat_id,
+		"text":       text,
+		"parse_mode": "Markdown",
+	}
+	if keyboard := pageKeyboard(prefix, arg, 0, pages); keyboard != nil {
+		request["reply_markup"] = keyboard
+	}
+	if _, err := bot.apiCall("sendMessage", request); err != nil {
+		bot.log.Warn("failed to send listing", "chat_id", chat_id, "error", err)
+	}
+}
+
+// mayPage reports whether user_id may see the listing for arg.
+func (bot *Bot) mayPage(listing PagedListing, user_id json.Number, arg string) bool {
+	var chat_id json.Number
+	if listing.Chat != nil {
+		chat_id = listing.Chat(arg)
+	}
+	switch listing.Access {
+	case AccessBotAdmin:
+		return bot.isBotAdmin(user_id)
+	case AccessObserver:
+		if bot.isObserver(user_id) {
+			return true
+		}
+	case AccessAnyone:
+		return chat_id == "" || bot.isChatMember(user_id, chat_id)
+	}
+	if chat_id == "" {
+		return false
+	}
+	admin, _ := bot.isUserAdmin(user_id, chat_id)
+	return admin
+}
+
+// processPageCallback switches a listing to another page. Returns false if
+// the callback isn't a page switch.
+func (bot *Bot) processPageCallback(callback JsonTable) bool {
+	parts := strings.SplitN(getStr(callback, "data"), ":", 3)
+	if len(parts) != 3 {
+		return false
+	}
+	listing, ok := bot.paged_listings[parts[0]]
+	if !ok {
+		return false
+	}
+	page, _ := strconv.Atoi(parts[1])
+	user_id := getNum(getTbl(callback, "from"), "id")
+	if !bot.mayPage(listing, user_id, parts[2]) {
+		bot.log.Warn("page switch denied", "user_id", user_id, "listing", parts[0], "arg", parts[2])
+		bot.answerCallback(getStr(callback, "id"), PageDeniedMsg)
+		return true
+	}
+
+	header, lines := listing.Render(user_id, parts[2])
+	text, pages := pageText(header, lines, page, bot.pageSize(parts[0], parts[2]))
+	page = max(0, min(page, pages-1))
+
+	message := getTbl(callback, "message")
+	request := JsonTable{
+		"chat_id":    getChatId(message),
+		"message_id": getNum(message, "message_id"),
+		"text":       text,
+		"parse_mode": "Markdown",
+	}
+	if keyboard := pageKeyboard(parts[0], parts[2], page, pages); keyboard != nil {
+		request["reply_markup"] = keyboard
+	}
+	bot.apiCall("editMessageText", request)
+	bot.answerCallback(getStr(callback, "id"), "")
+	return true
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	PollQuestion  = "Кто придёт на событие #%s?"
+	PollYes       = "Приду"
+	PollMaybe     = "Возможно"
+	PollNo        = "Не приду"
+	PollTallyLine = "Опрос: %s %d, %s %d, %s %d"
+	PollFailedMsg = "Не удалось создать опрос."
+)
+
+var poll_options = []string{PollYes, PollMaybe, PollNo}
+
+// pollTally counts the answers per option, indexed like poll_options.
+func pollTally(event *EventInfo) []int {
+	tally := make([]int, len(poll_options))
+	for _, option := range event.PollAnswers {
+		if option >= 0 && option < len(tally) {
+			tally[option]++
+		}
+	}
+	return tally
+}
+
+func formatPollTally(event *EventInfo) string {
+	tally := pollTally(event)
+	return fmt.Sprintf(PollTallyLine, PollYes, tally[0], PollMaybe, tally[1], PollNo, tally[2])
+}
+
+func (bot *Bot) eventPoll(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	bot.state_mux.Unlock()
+	if !ok {
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+
+	request := JsonTable{
+		"chat_id":      chat_id,
+		"question":     fmt.Sprintf(PollQuestion, event.ref()),
+		"options":      poll_options,
+		"is_anonymous": false,
+	}
+	if thread_id != "" {
+		request["message_thread_id"] = thread_id
+	}
+	resp, err := bot.apiCall("sendPoll", request)
+	if err != nil {
+		bot.messageLogger(message).Warn("failed to send poll", "error", err)
+		bot.sendReply(chat_id, thread_id, message_id, PollFailedMsg)
+		return
+	}
+
+	bot.state_mux.Lock()
+	event.PollId = getStr(getTbl(asTbl(resp), "poll"), "id")
+	event.PollAnswers = map[json.Number]int{}
+	bot.saveState()
+	bot.state_mux.Unlock()
+}
+
+// processPollAnswer records a vote in the event the poll belongs to. A
+// retracted vote comes with no options.
+func (bot *Bot) processPollAnswer(answer JsonTable) {
+	poll_id := getStr(answer, "poll_id")
+	user_id := getNum(getTbl(answer, "user"), "id")
+	options, _ := answer["option_ids"].(JsonArray)
+
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	for _, event := range bot.current_events {
+		if event.PollId != poll_id {
+			continue
+		}
+		if len(options) == 0 {
+			delete(event.PollAnswers, user_id)
+		} else {
+			choice, _ := options[0].(json.Number)
+			option, _ := choice.Int64()
+			event.PollAnswers[user_id] = int(option)
+		}
+		bot.saveState()
+		return
+	}
+}
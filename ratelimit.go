@@ -0,0 +1,37 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Telegram allows about 30 messages per second in total.
+const send_rate = 30
+
+// RateLimiter spaces calls at least interval apart.
+type RateLimiter struct {
+	interval time.Duration
+
+	mux  sync.Mutex
+	next time.Time
+}
+
+func newRateLimiter(per_second int) *RateLimiter {
+	return &RateLimiter{interval: time.Second / time.Duration(per_second)}
+}
+
+// Wait blocks until the caller may make the next call.
+func (l *RateLimiter) Wait() {
+	l.mux.Lock()
+	now := time.Now()
+	if l.next.Before(now) {
+		l.next = now
+	}
+	wait := l.next.Sub(now)
+	l.next = l.next.Add(l.interval)
+	l.mux.Unlock()
+
+	time.Sleep(wait)
+}
+
+var send_limiter = newRateLimiter(send_rate)
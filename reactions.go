@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	reaction_emoji = "👍"
+
+	ReactionRegPost   = "Регистрация на событие #%s:\n\n%s\n\nПоставьте " + reaction_emoji + " этому сообщению, чтобы зарегистрироваться, уберите — чтобы отменить регистрацию."
+	ReactionRegOff    = "Регистрация реакциями на событие #%s выключена."
+	ReactionRegFailed = "Не удалось опубликовать сообщение для регистрации."
+)
+
+// reactionRegistration posts the event message members react to in order to
+// register, "/reactreg off" stops it. Reactions carry no license, members
+// registered this way have just their name.
+func (bot *Bot) reactionRegistration(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	var details string
+	if ok {
+		details = formatEventDetails(event)
+	}
+	bot.state_mux.Unlock()
+	if !ok {
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+
+	if len(args) == 1 && args[0] == "off" {
+		bot.state_mux.Lock()
+		event.ReactionMessageId = ""
+		bot.saveState()
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(ReactionRegOff, event.ref()))
+		return
+	}
+
+	request := chatMessage(chat_id, thread_id, fmt.Sprintf(ReactionRegPost, event.ref(), details))
+	resp, err := bot.apiCall("sendMessage", request)
+	if err != nil {
+		bot.messageLogger(message).Warn("failed to post reaction registration", "error", err)
+		bot.sendReply(chat_id, thread_id, message_id, ReactionRegFailed)
+		return
+	}
+
+	bot.state_mux.Lock()
+	event.ReactionMessageId = getNum(asTbl(resp), "message_id")
+	event.PostMessageId = event.ReactionMessageId
+	bot.saveState()
+	bot.state_mux.Unlock()
+}
+
+func hasReactionEmoji(reactions JsonArray) bool {
+	for _, r := range reactions {
+		reaction := asTbl(r)
+		if getStr(reaction, "type") == "emoji" && getStr(reaction, "emoji") == reaction_emoji {
+			return true
+		}
+	}
+	return false
+}
+
+// reactionEvent finds the event whose registration message was reacted to.
+// Must be called with state_mux held.
+func (bot *Bot) reactionEvent(chat_id json.Number, message_id json.Number) *EventInfo {
+	for _, event := range bot.current_events {
+		if event.ChatId == chat_id && event.ReactionMessageId != "" && event.ReactionMessageId == message_id {
+			return event
+		}
+	}
+	return nil
+}
+
+// processReaction registers users who put reaction_emoji on a registration
+// message and unregisters them when they take it back. Anonymous reactions
+// come without a user and are ignored.
+func (bot *Bot) processReaction(update JsonTable) {
+	user := getTbl(update, "user")
+	if user == nil {
+		return
+	}
+	chat_id := getNum(getTbl(update, "chat"), "id")
+	message_id := getNum(update, "message_id")
+	old_reactions, _ := update["old_reaction"].(JsonArray)
+	new_reactions, _ := update["new_reaction"].(JsonArray)
+
+	had, has := hasReactionEmoji(old_reactions), hasReactionEmoji(new_reactions)
+	switch {
+	case has && !had:
+		bot.reactionRegister(chat_id, message_id, user)
+	case had && !has:
+		bot.reactionUnregister(chat_id, message_id, getNum(user, "id"))
+	}
+}
+
+func (bot *Bot) reactionRegister(chat_id json.Number, message_id json.Number, user JsonTable) {
+	user_id := getNum(user, "id")
+	if bot.isBanned(chat_id, user_id) {
+		return
+	}
+	if reason := bot.memberAgeBlock(chat_id, user_id); reason != "" {
+		bot.sendNotification(user_id, reason)
+		return
+	}
+
+	bot.state_mux.Lock()
+	event := bot.reactionEvent(chat_id, message_id)
+	if event == nil || event.findMember(user_id) != -1 || event.findWaiting(user_id) != -1 {
+		bot.state_mux.Unlock()
+		return
+	}
+	if closed := closedReason(event, time.Now()); closed != "" {
+		bot.state_mux.Unlock()
+		bot.sendNotification(user_id, closed)
+		return
+	}
+	name := userDisplayName(user)
+	record := MemberRecord{Seq: event.nextSeq(), Name: name, UserId: user_id}
+	waitlisted := !event.fits(record)
+	var position int
+	if waitlisted {
+		position = event.addToWaitlist(record)
+		event.audit(nil, audit_waitlisted, name)
+	} else {
+		event.Registrations = append(event.Registrations, record)
+		position = len(event.Registrations)
+		event.audit(nil, audit_registered, name)
+	}
+	details := formatEventDetails(event)
+	notice := bot.capacityNotice(event)
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	if bot.confirm_dm {
+		confirmation := RegisterConfirmDM
+		if waitlisted {
+			confirmation = WaitlistConfirmDM
+		}
+		bot.sendNotification(user_id, fmt.Sprintf(confirmation, event.ref(), details, localeOf(event.Locale).Ordinal(position)))
+	}
+	bot.announceCapacity(event, notice)
+}
+
+func (bot *Bot) reactionUnregister(chat_id json.Number, message_id json.Number, user_id json.Number) {
+	bot.state_mux.Lock()
+	event := bot.reactionEvent(chat_id, message_id)
+	if event == nil {
+		bot.state_mux.Unlock()
+		return
+	}
+	removed, promoted, found := event.dropUser(user_id)
+	if !found {
+		bot.state_mux.Unlock()
+		return
+	}
+	event.audit(nil, audit_unregistered, removed.Name)
+	notice := bot.capacityNotice(event)
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	bot.notifyPromotions(promoted)
+	bot.announceCapacity(event, notice)
+}
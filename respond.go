@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// ReplyTarget is where respond sends a command's output.
+type ReplyTarget int
+
+const (
+	ReplyInChat  ReplyTarget = iota // a reply to the command, in the chat it was sent to
+	ReplyPrivate                    // the sender's private chat, for long or personal output
+)
+
+type commandKey struct{}
+
+func withCommand(ctx context.Context, command Command) context.Context {
+	return context.WithValue(ctx, commandKey{}, command)
+}
+
+// commandOf is the command ctx belongs to, the zero Command outside
+// commands.
+func commandOf(ctx context.Context) Command {
+	command, _ := ctx.Value(commandKey{}).(Command)
+	return command
+}
+
+// respond answers message in Markdown where the command's Reply says.
+func (bot *Bot) respond(ctx context.Context, message JsonTable, text string) error {
+	return bot.deliver(ctx, message, JsonTable{"text": text, "parse_mode": "Markdown"})
+}
+
+// respondRaw is respond for text that must not be parsed as Markdown.
+func (bot *Bot) respondRaw(ctx context.Context, message JsonTable, text string) error {
+	return bot.deliver(ctx, message, JsonTable{"text": text})
+}
+
+// respondFormatted is respond for text built with entities.
+func (bot *Bot) respondFormatted(ctx context.Context, message JsonTable, b *TextBuilder) error {
+	content := JsonTable{}
+	content["text"], content["entities"] = b.Build()
+	return bot.deliver(ctx, message, content)
+}
+
+// deliver sends content privately or in the chat. Private output isn't
+// posted in the chat when the sender can't be DMed, they get a short-lived
+// hint to start the bot instead.
+func (bot *Bot) deliver(ctx context.Context, message JsonTable, content JsonTable) error {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	if commandOf(ctx).Reply == ReplyInChat || isPrivateChat(message) {
+		request := replyRequest(chat_id, thread_id, message_id)
+		for key, value := range content {
+			request[key] = value
+		}
+		_, err := bot.postReply(request)
+		return err
+	}
+
+	request := JsonTable{"chat_id": getSenderId(message)}
+	for key, value := range content {
+		request[key] = value
+	}
+	_, err := bot.postPrivate(request)
+	if err != nil && isDmForbidden(err) {
+		resp, err := bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(StartBotFirstMsg, escapeMarkdown("@"+bot.Name)))
+		if err == nil {
+			bot.deleteAfter(messageRef{chat_id, getNum(asTbl(resp), "message_id")}, ephemeral_reply_ttl)
+		}
+	}
+	return err
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+const (
+	api_attempts    = 3
+	api_retry_delay = time.Second
+)
+
+// RetryPolicy decides whether a failed call to a method that posts something
+// (sendMessage, sendPoll, ...) is repeated. A request that timed out may still
+// have reached Telegram, so repeating it can post the same message twice,
+// while not repeating it can lose the message.
+type RetryPolicy int
+
+const (
+	// RetrySafe repeats sends only when the request certainly never left the
+	// bot, i.e. the connection couldn't be established.
+	RetrySafe RetryPolicy = iota
+	// RetryAlways repeats sends on any network error, accepting duplicates.
+	RetryAlways
+	// RetryNever doesn't repeat sends at all.
+	RetryNever
+)
+
+func parseRetryPolicy(name string) (RetryPolicy, error) {
+	switch name {
+	case "", "safe":
+		return RetrySafe, nil
+	case "always":
+		return RetryAlways, nil
+	case "never":
+		return RetryNever, nil
+	}
+	return RetrySafe, fmt.Errorf("unknown retry policy %q", name)
+}
+
+// isIdempotent reports whether repeating tg_func can't produce a duplicate.
+func isIdempotent(tg_func string) bool {
+	return !strings.HasPrefix(tg_func, "send") &&
+		tg_func != "forwardMessage" && tg_func != "copyMessage"
+}
+
+// notSent reports whether err happened before the request was written.
+func notSent(err error) bool {
+	var op_err *net.OpError
+	return errors.As(err, &op_err) && (op_err.Op == "dial" || op_err.Op == "proxyconnect")
+}
+
+// shouldRetry is consulted on transport errors only, errors reported by the
+// API itself are never retried here.
+func (bot *Bot) shouldRetry(tg_func string, err error) bool {
+	if isIdempotent(tg_func) {
+		return true
+	}
+	switch bot.send_retry_policy {
+	case RetryAlways:
+		return true
+	case RetrySafe:
+		return notSent(err)
+	}
+	return false
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	schedule_check_interval = time.Minute
+	schedule_time_layout    = "15:04"
+)
+
+const (
+	ScheduleAskDescription = "Введите описание регулярного события:"
+	ScheduleAskWhen        = "Введите день недели и время начала, например \"сб 10:00\":"
+	ScheduleBadWhen        = "Не удалось разобрать день и время, пример: \"сб 10:00\"."
+	ScheduleAskRecurrence  = "Как часто проводится событие? 1 — каждую неделю, 2 — раз в две недели:"
+	ScheduleBadRecurrence  = "Введите 1 или 2."
+	ScheduleReport         = "Расписание сохранено. Следующее событие открыто, регистрация на новое откроется %s."
+	ScheduleRemoved        = "Расписание удалено."
+	ScheduleNone           = "Расписание не задано."
+	ScheduleOpenedMsg      = "Открыта регистрация на новое событие по расписанию:\n\n%s"
+)
+
+var weekdays = map[string]time.Weekday{
+	"пн": time.Monday, "вт": time.Tuesday, "ср": time.Wednesday, "чт": time.Thursday,
+	"пт": time.Friday, "сб": time.Saturday, "вс": time.Sunday,
+	"mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday,
+	"fri": time.Friday, "sat": time.Saturday, "sun": time.Sunday,
+}
+
+// ScheduleTemplate describes a recurring event. Each time a session starts,
+// its event is closed and registration for the next session is opened.
+type ScheduleTemplate struct {
+	Description string
+	Location    string
+	Capacity    int
+	ThreadId    json.Number `json:",omitempty"`
+	OwnerId     json.Number `json:",omitempty"`
+
+	Weekday    time.Weekday
+	Time       string // HH:MM
+	EveryWeeks int    // 1 weekly, 2 biweekly
+
+	NextRun time.Time // start of the session the active event is for
+}
+
+// following is the session after start, at the same wall clock time in loc
+// so that a change to or from daylight saving time doesn't shift it.
+func (t *ScheduleTemplate) following(start time.Time, loc *time.Location) time.Time {
+	return start.In(loc).AddDate(0, 0, 7*t.EveryWeeks)
+}
+
+// nextOccurrence returns the first time after now that falls on the
+// template's weekday and time.
+func (t *ScheduleTemplate) nextOccurrence(now time.Time) time.Time {
+	clock, _ := time.Parse(schedule_time_layout, t.Time)
+	next := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+	next = next.AddDate(0, 0, int(t.Weekday-next.Weekday()+7)%7)
+	if !next.After(now) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+func (t *ScheduleTemplate) newEvent(chat_id json.Number, start time.Time) *EventInfo {
+	return &EventInfo{
+		Description: t.Description,
+		ChatId:      chat_id,
+		ThreadId:    t.ThreadId,
+		StartTime:   start,
+		Location:    t.Location,
+		Capacity:    t.Capacity,
+		OwnerId:     t.OwnerId,
+	}
+}
+
+func parseWhen(text string) (time.Weekday, string, bool) {
+	fields := strings.Fields(strings.ToLower(text))
+	if len(fields) != 2 {
+		return 0, "", false
+	}
+	day, ok := weekdays[fields[0]]
+	if !ok {
+		return 0, "", false
+	}
+	if _, err := time.Parse(schedule_time_layout, fields[1]); err != nil {
+		return 0, "", false
+	}
+	return day, fields[1], true
+}
+
+func (bot *Bot) scheduleEvent(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	user_id := getSenderId(message)
+
+	if len(args) > 0 && args[0] == "off" {
+		bot.updateChatConfig(chat_id, func(config *ChatConfig) {
+			config.Schedule = nil
+		})
+		bot.sendPrivateMessage(user_id, ScheduleRemoved, false)
+		return
+	}
+
+	template := &ScheduleTemplate{ThreadId: getThreadId(message), OwnerId: user_id}
+	var err error
+	if template.Description, err = bot.askFiltered(ctx, user_id, ScheduleAskDescription); err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	for {
+		text, err := bot.askText(ctx, user_id, ScheduleAskWhen)
+		if err != nil {
+			bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+			return
+		}
+		var ok bool
+		if template.Weekday, template.Time, ok = parseWhen(text); ok {
+			break
+		}
+		bot.sendPrivateMessage(user_id, ScheduleBadWhen, false)
+	}
+	if template.Location, err = bot.askText(ctx, user_id, EventOpenAskLocation); err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	if template.Location == skip_answer {
+		template.Location = ""
+	}
+	if template.Capacity, err = bot.askCapacity(ctx, user_id, bot.getChatConfig(chat_id).DefaultCapacity); err != nil {
+		bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+		return
+	}
+	for {
+		text, err := bot.askText(ctx, user_id, ScheduleAskRecurrence)
+		if err != nil {
+			bot.messageLogger(message).Warn("Failed to get answer", "error", err)
+			return
+		}
+		if weeks, err := strconv.Atoi(text); err == nil && (weeks == 1 || weeks == 2) {
+			template.EveryWeeks = weeks
+			break
+		}
+		bot.sendPrivateMessage(user_id, ScheduleBadRecurrence, false)
+	}
+
+	template.NextRun = template.nextOccurrence(time.Now().In(bot.getChatConfig(chat_id).Location()))
+	bot.updateChatConfig(chat_id, func(config *ChatConfig) {
+		config.Schedule = template
+	})
+	bot.runSchedule(chat_id, time.Now())
+	bot.sendPrivateMessage(user_id, fmt.Sprintf(ScheduleReport, template.NextRun.Format(event_time_layout)), false)
+}
+
+// runSchedule makes sure the chat has an active event for the upcoming
+// session: once a session has started its event is closed and the next one is
+// opened.
+func (bot *Bot) runSchedule(chat_id json.Number, now time.Time) {
+	var opened *EventInfo
+	bot.state_mux.Lock()
+	config, ok := bot.chat_configs[chat_id]
+	if !ok || config.Schedule == nil {
+		bot.state_mux.Unlock()
+		return
+	}
+	template := config.Schedule
+	key := EventKey{chat_id, template.ThreadId}
+	active, has_active := bot.current_events[key]
+
+	if !now.Before(template.NextRun) || !has_active {
+		if has_active {
+			bot.archiveEvent(active)
+			active.audit(nil, audit_closed, "")
+		}
+		for !now.Before(template.NextRun) {
+			template.NextRun = template.following(template.NextRun, config.Location())
+		}
+		opened = template.newEvent(chat_id, template.NextRun)
+		opened.Locale = config.Locale
+		bot.addEvent(key, opened)
+		opened.audit(nil, audit_created, "")
+		bot.saveState()
+	}
+	var text string
+	if opened != nil {
+		text = fmt.Sprintf(ScheduleOpenedMsg, bot.formatEvent(opened))
+	}
+	bot.state_mux.Unlock()
+
+	if opened != nil {
+		bot.log.Info("scheduled event opened", "chat_id", chat_id, "event_id", opened.EventId)
+		if resp, err := bot.sendToChat(chat_id, template.ThreadId, text); err == nil {
+			bot.rememberPost(opened, resp)
+		}
+	}
+}
+
+// sendToChat posts a message to a chat (and forum topic), not as a reply.
+func (bot *Bot) sendToChat(chat_id json.Number, thread_id json.Number, text string) (JsonAny, error) {
+	resp, err := bot.apiCall("sendMessage", chatMessage(chat_id, thread_id, text))
+	if err != nil {
+		bot.log.Warn("failed to send message", "chat_id", chat_id, "error", err)
+	}
+	return resp, err
+}
+
+func chatMessage(chat_id json.Number, thread_id json.Number, text string) JsonTable {
+	request := JsonTable{
+		"chat_id":    chat_id,
+		"text":       text,
+		"parse_mode": "Markdown",
+	}
+	if thread_id != "" {
+		request["message_thread_id"] = thread_id
+	}
+	return request
+}
+
+func (bot *Bot) scheduler() {
+	for now := range time.Tick(schedule_check_interval) {
+		bot.expireWaitlists(now)
+		bot.lockRegistrations(now)
+
+		bot.state_mux.Lock()
+		var chats []json.Number
+		for chat_id, config := range bot.chat_configs {
+			if config.Schedule != nil && !now.Before(config.Schedule.NextRun) {
+				chats = append(chats, chat_id)
+			}
+		}
+		bot.state_mux.Unlock()
+
+		for _, chat_id := range chats {
+			bot.runSchedule(chat_id, now)
+		}
+	}
+}
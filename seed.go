@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const (
+	SeedDisabledMsg = "Тестовые данные отключены, задайте BOT_ALLOW_SEED=1."
+	SeedReport      = "Создано тестовое событие #%s: %d участников."
+
+	seed_capacity = 8
+	seed_members  = 12 // more than seed_capacity to fill the waitlist too
+)
+
+// seedEvent opens a sample event with fake registrations in the chat, for
+// demos and trying out /show and the listings.
+func (bot *Bot) seedEvent(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	user_id := getSenderId(message)
+
+	if !bot.allow_seed {
+		bot.replyPrivately(message, SeedDisabledMsg)
+		bot.countDenied(message)
+		return
+	}
+	if !bot.isBotAdmin(user_id) {
+		bot.replyPrivately(message, BotAdminOnlyMsg)
+		bot.countDenied(message)
+		return
+	}
+
+	event := &EventInfo{
+		Description: "Тестовое событие",
+		ChatId:      chat_id,
+		ThreadId:    thread_id,
+		StartTime:   time.Now().Add(7 * 24 * time.Hour).Truncate(time.Hour),
+		Location:    "Тестовая трасса",
+		Capacity:    seed_capacity,
+	}
+	for i := 1; i <= seed_members; i++ {
+		member := MemberRecord{
+			Seq:     event.nextSeq(),
+			Name:    fmt.Sprintf("Участник %d", i),
+			License: fmt.Sprintf("TEST-%03d", i),
+		}
+		if event.isFull() {
+			event.addToWaitlist(member)
+		} else {
+			event.Registrations = append(event.Registrations, member)
+		}
+	}
+
+	bot.state_mux.Lock()
+	if !bot.addEvent(EventKey{chat_id, thread_id}, event) {
+		bot.state_mux.Unlock()
+		bot.sendPrivateMessage(user_id, EventOpenAlreadyExists, false)
+		return
+	}
+	event.audit(message, audit_created, "seed")
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	bot.sendReply(chat_id, thread_id, getNum(message, "message_id"), fmt.Sprintf(SeedReport, event.ref(), seed_members))
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const shutdown_timeout = 10 * time.Second
+
+const ShutdownMsg = "Бот перезапускается. Повторите команду через пару минут."
+
+var (
+	ErrReplyTimeout = errors.New("reply timeout")
+	ErrShuttingDown = errors.New("bot is shutting down")
+)
+
+// confirmUpdates acknowledges the updates handed to the workers. getUpdates
+// only does that with the next call, which the poll loop doesn't make once
+// shutting down, and they would be handled again after the restart.
+func (bot *Bot) confirmUpdates() {
+	offset := bot.poll_offset.Load()
+	if offset == 0 {
+		return
+	}
+	if _, err := bot.apiCall("getUpdates", JsonTable{"offset": offset, "limit": 1, "timeout": 0}); err != nil {
+		bot.log.Warn("failed to confirm updates", "offset", offset, "error", err)
+	}
+}
+
+func (bot *Bot) isShuttingDown() bool {
+	return bot.root_ctx.Err() != nil
+}
+
+// waitForShutdown blocks until SIGINT or SIGTERM, then stops pending
+// questions and gives running handlers and then the outbox shutdown_timeout
+// each to finish, all the bots at once. No new updates are taken meanwhile,
+// and the ones not acknowledged yet are delivered to the next instance.
+func waitForShutdown(bots []*Bot) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	slog.Info("shutting down", "signal", sig.String())
+	var wg sync.WaitGroup
+	for _, bot := range bots {
+		wg.Go(func() { bot.shutdown(shutdown_timeout) })
+	}
+	wg.Wait()
+}
+
+// shutdown cancels root_ctx and drains the handlers and then the outbox,
+// waiting up to timeout for each. False if handlers were still running.
+// The updates handled are confirmed in between.
+func (bot *Bot) shutdown(timeout time.Duration) bool {
+	bot.stop_root(ErrShuttingDown)
+
+	done := make(chan struct{})
+	go func() {
+		bot.handlers.Wait()
+		close(done)
+	}()
+	finished := true
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		bot.log.Warn("handlers still running, exiting anyway")
+		bot.logStragglers()
+		finished = false
+	}
+	bot.confirmUpdates()
+	if bot.outbox != nil {
+		bot.outbox.Drain(timeout)
+	}
+	return finished
+}
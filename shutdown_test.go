@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newSlowBot is a test bot whose /slow command runs handler, with its
+// outbox running so that shutdown drains it.
+func newSlowBot(t *testing.T, handler CommandHandler) (*Bot, *fakeApi) {
+	bot, api := newTestBot(t)
+	bot.commands["/slow"] = Command{Handler: handler}
+	go bot.outbox.run(bot)
+	return bot, api
+}
+
+func slowUpdate() JsonTable {
+	return JsonTable{
+		"update_id": json.Number("5"),
+		"message": JsonTable{
+			"message_id": json.Number("1"),
+			"chat":       JsonTable{"id": json.Number("2"), "type": "private"},
+			"from":       JsonTable{"id": json.Number("2")},
+			"text":       "/slow",
+		},
+	}
+}
+
+// A handler still busy when the context is cancelled is waited for.
+func TestShutdownWaitsForHandler(t *testing.T) {
+	t.Parallel()
+	started := make(chan struct{})
+	var finished atomic.Bool
+	bot, _ := newSlowBot(t, func(ctx context.Context, message JsonTable, args []string) {
+		close(started)
+		<-ctx.Done()
+		// saving what it did takes a while yet
+		time.Sleep(100 * time.Millisecond)
+		finished.Store(true)
+	})
+
+	if offset := bot.dispatchUpdates([]JsonTable{slowUpdate()}, 5); offset != 6 {
+		t.Fatalf("offset %d, want the update accepted", offset)
+	}
+	<-started
+	if !bot.shutdown(2 * time.Second) {
+		t.Error("shutdown gave up on the handler")
+	}
+	if !finished.Load() {
+		t.Error("shutdown returned before the handler finished")
+	}
+	if offset := bot.dispatchUpdates([]JsonTable{slowUpdate()}, 6); offset != 6 {
+		t.Errorf("offset %d, want updates refused after shutdown", offset)
+	}
+}
+
+// A handler that doesn't finish is waited for up to the deadline only.
+func TestShutdownDeadline(t *testing.T) {
+	t.Parallel()
+	started, release := make(chan struct{}), make(chan struct{})
+	bot, _ := newSlowBot(t, func(ctx context.Context, message JsonTable, args []string) {
+		close(started)
+		<-release
+	})
+	defer close(release)
+
+	bot.dispatchUpdates([]JsonTable{slowUpdate()}, 5)
+	<-started
+	start := time.Now()
+	if bot.shutdown(100 * time.Millisecond) {
+		t.Error("shutdown reported a stuck handler as finished")
+	}
+	// the handler deadline, the outbox drains at once
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond || elapsed > time.Second {
+		t.Errorf("shutdown took %s with a 100ms deadline", elapsed)
+	}
+}
+
+// The updates handled before the shutdown are confirmed with a last
+// getUpdates, or the next start would run them again.
+func TestShutdownConfirmsUpdates(t *testing.T) {
+	t.Parallel()
+	ran := make(chan struct{}, 1)
+	bot, api := newSlowBot(t, func(ctx context.Context, message JsonTable, args []string) {
+		ran <- struct{}{}
+	})
+
+	bot.dispatchUpdates([]JsonTable{slowUpdate()}, 5)
+	<-ran
+	bot.shutdown(time.Second)
+
+	calls := api.sent("getUpdates")
+	if len(calls) != 1 || getInt(calls[0], "offset") != 6 || getInt(calls[0], "timeout") != 0 {
+		t.Errorf("getUpdates called with %v, want offset 6 and no timeout", calls)
+	}
+}
+
+// Nothing is confirmed by a bot that didn't poll, in webhook mode say.
+func TestShutdownWithoutUpdates(t *testing.T) {
+	t.Parallel()
+	bot, api := newTestBot(t)
+	bot.shutdown(time.Second)
+	if calls := api.sent("getUpdates"); len(calls) != 0 {
+		t.Errorf("getUpdates called with %v", calls)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const default_state_file = "drift-tracker-state.json"
+
+// BotState is everything that has to survive a restart.
+type BotState struct {
+	IdCounter int32
+	Events    map[EventKey]*EventInfo
+	Configs   map[json.Number]*ChatConfig
+	History   map[json.Number][]*EventInfo
+	OptedOut  map[json.Number]bool
+	Chats     map[json.Number]*ChatInfo
+	JoinDates map[json.Number]map[json.Number]time.Time
+	Usage     map[string]map[json.Number]*UsageCounter
+	Bans      map[json.Number]map[json.Number]bool
+
+	Maintenance bool `json:",omitempty"`
+}
+
+type Store interface {
+	Load() (*BotState, error)
+	Save(state *BotState) error
+}
+
+// FileStore keeps the state as a single JSON document on disk.
+type FileStore struct {
+	Path string
+}
+
+func (s *FileStore) Load() (*BotState, error) {
+	data, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &BotState{
+			Events:    map[EventKey]*EventInfo{},
+			Configs:   map[json.Number]*ChatConfig{},
+			History:   map[json.Number][]*EventInfo{},
+			OptedOut:  map[json.Number]bool{},
+			Chats:     map[json.Number]*ChatInfo{},
+			JoinDates: map[json.Number]map[json.Number]time.Time{},
+			Usage:     map[string]map[json.Number]*UsageCounter{},
+			Bans:      map[json.Number]map[json.Number]bool{},
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	state := &BotState{}
+	if err = json.Unmarshal(data, state); err != nil {
+		return nil, err
+	}
+	if state.Events == nil {
+		state.Events = map[EventKey]*EventInfo{}
+	}
+	if state.Configs == nil {
+		state.Configs = map[json.Number]*ChatConfig{}
+	}
+	if state.History == nil {
+		state.History = map[json.Number][]*EventInfo{}
+	}
+	if state.OptedOut == nil {
+		state.OptedOut = map[json.Number]bool{}
+	}
+	if state.Chats == nil {
+		state.Chats = map[json.Number]*ChatInfo{}
+	}
+	if state.JoinDates == nil {
+		state.JoinDates = map[json.Number]map[json.Number]time.Time{}
+	}
+	if state.Usage == nil {
+		state.Usage = map[string]map[json.Number]*UsageCounter{}
+	}
+	if state.Bans == nil {
+		state.Bans = map[json.Number]map[json.Number]bool{}
+	}
+	return state, nil
+}
+
+func (s *FileStore) Save(state *BotState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, data)
+}
+
+// writeFileAtomic writes to a temporary file first so a crash never leaves a
+// truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (bot *Bot) loadState() error {
+	state, err := bot.store.Load()
+	if err != nil {
+		return err
+	}
+
+	// events saved before EventInfo.ChatId existed
+	for key, event := range state.Events {
+		if event.ChatId == "" {
+			event.ChatId = key.ChatId
+		}
+		// events saved before members were numbered
+		if event.LastSeq == 0 {
+			for _, list := range [][]MemberRecord{event.Registrations, event.Waitlist} {
+				for i := range list {
+					list[i].Seq = event.nextSeq()
+				}
+			}
+		}
+	}
+
+	bot.state_mux.Lock()
+	bot.id_counter = state.IdCounter
+	bot.current_events = state.Events
+	bot.chat_configs = state.Configs
+	bot.chat_history = state.History
+	bot.opted_out = state.OptedOut
+	bot.chat_infos = state.Chats
+	bot.join_dates = state.JoinDates
+	bot.command_usage = state.Usage
+	bot.chat_bans = state.Bans
+	bot.maintenance = state.Maintenance
+	bot.state_mux.Unlock()
+	return nil
+}
+
+// currentState collects the state to save. Must be called with state_mux
+// held.
+func (bot *Bot) currentState() *BotState {
+	return &BotState{
+		IdCounter: bot.id_counter,
+		Events:    bot.current_events,
+		Configs:   bot.chat_configs,
+		History:   bot.chat_history,
+		OptedOut:  bot.opted_out,
+		Chats:     bot.chat_infos,
+		JoinDates: bot.join_dates,
+		Usage:     bot.command_usage,
+		Bans:      bot.chat_bans,
+
+		Maintenance: bot.maintenance,
+	}
+}
+
+// saveState persists the current state. Must be called with state_mux held.
+func (bot *Bot) saveState() {
+	if err := bot.store.Save(bot.currentState()); err != nil {
+		bot.log.Error("failed to save state", "error", err)
+		bot.alertError("сохранение состояния", err)
+	}
+}
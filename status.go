@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"runtime"
+)
+
+const StatusMsg = `Активных событий: %d
+Зарегистрировано: %d, в листе ожидания: %d
+Ожидают ответа: %d
+Горутин: %d`
+
+const StatusFeesMsg = "\nОплатили в событиях с учётом оплаты: %d из %d, собрано: %d"
+
+// StatusSnapshot is a point-in-time summary of the bot for operators.
+type StatusSnapshot struct {
+	ActiveEvents  int `json:"active_events"`
+	Registrations int `json:"registrations"`
+	Waitlisted    int `json:"waitlisted"`
+	// registrations of events in chats with "fees" on, see feeTotals
+	FeeRegistrations int `json:"fee_registrations"`
+	Paid             int `json:"paid"`
+	Collected        int `json:"collected"`
+	PendingReplies   int `json:"pending_replies"`
+	Goroutines       int `json:"goroutines"`
+}
+
+// statusSnapshot takes the locks of the state it counts, so it is safe to call
+// from any goroutine. The counts are consistent per lock, not across them.
+func (bot *Bot) statusSnapshot() StatusSnapshot {
+	var s StatusSnapshot
+	bot.state_mux.Lock()
+	s.ActiveEvents = len(bot.current_events)
+	for _, event := range bot.current_events {
+		s.Registrations += len(event.Registrations)
+		s.Waitlisted += len(event.Waitlist)
+		if !bot.feesEnabled(event.ChatId) {
+			continue
+		}
+		s.FeeRegistrations += len(event.Registrations)
+		for _, member := range event.Registrations {
+			if member.Paid {
+				s.Paid++
+				s.Collected += member.Amount
+			}
+		}
+	}
+	bot.state_mux.Unlock()
+
+	s.PendingReplies = bot.pendingReplies()
+
+	s.Goroutines = runtime.NumGoroutine()
+	return s
+}
+
+func (bot *Bot) botStatus(ctx context.Context, message JsonTable, args []string) {
+	s := bot.statusSnapshot()
+	text := fmt.Sprintf(StatusMsg, s.ActiveEvents, s.Registrations, s.Waitlisted, s.PendingReplies, s.Goroutines)
+	if s.FeeRegistrations > 0 {
+		text += fmt.Sprintf(StatusFeesMsg, s.Paid, s.FeeRegistrations, s.Collected)
+	}
+	bot.sendPrivateMessage(getSenderId(message), text, false)
+}
+
+// publishStatus makes the status and the API breaker state of the bots part
+// of /debug/vars, by bot name when there are several.
+func publishStatus(bots []*Bot) {
+	publishByBot("status", bots, func(bot *Bot) any { return bot.statusSnapshot() })
+	publishByBot("api_breaker_state", bots, func(bot *Bot) any { return bot.breaker.State().String() })
+}
+
+func publishByBot(name string, bots []*Bot, value func(bot *Bot) any) {
+	expvar.Publish(name, expvar.Func(func() any {
+		if len(bots) == 1 && bots[0].instance == "" {
+			return value(bots[0])
+		}
+		values := map[string]any{}
+		for _, bot := range bots {
+			values[bot.instance] = value(bot)
+		}
+		return values
+	}))
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	TemplateUsage      = "Использование: /template <название> <поле>, <поле>... или /template <название> - чтобы удалить шаблон"
+	TemplateSaved      = "Шаблон %s сохранён: имя, %s."
+	TemplateDeleted    = "Шаблон %s удалён."
+	TemplateNotFound   = "Нет шаблона %s, список шаблонов — /templates"
+	TemplatesHeader    = "Шаблоны регистрации (имя спрашивается всегда):"
+	TemplatesEmpty     = "Шаблонов нет, регистрация спрашивает имя и номер лицензии."
+	TemplateLine       = "%s — %s"
+	TemplateLabel      = "Шаблон регистрации: %s"
+	EventOpenAskForm   = "Шаблон регистрации (%s) или \"-\" — имя и номер лицензии:"
+	RegisterAskField   = "Введите %s:"
+	RegisterConfirmFmt = "Зарегистрироваться на событие #%s?\nИмя: %s%s"
+	EditRegAskField    = "%s сейчас: %s\nВведите новое значение или \"-\", чтобы оставить как есть:"
+
+	max_template_fields = 10
+)
+
+// A registration template lists the fields asked after the name, replacing
+// the license. Events copy the fields when opened, so changing a template
+// doesn't affect existing registrations.
+
+func (c ChatConfig) templateNames() []string {
+	names := make([]string, 0, len(c.Templates))
+	for name := range c.Templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parseTemplateFields(text string) []string {
+	var fields []string
+	for _, field := range strings.Split(text, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// info is what /show lists after the member's name.
+func (m MemberRecord) info() string {
+	if len(m.Fields) > 0 {
+		return strings.Join(m.Fields, ", ")
+	}
+	return m.License
+}
+
+// askTemplate lets the admin opening an event pick one of the chat's
+// templates. Returns no fields for the default form.
+func (bot *Bot) askTemplate(ctx context.Context, user_id json.Number, config ChatConfig) (string, []string, error) {
+	names := config.templateNames()
+	if len(names) == 0 {
+		return "", nil, nil
+	}
+	question := fmt.Sprintf(EventOpenAskForm, escapeMarkdown(strings.Join(names, ", ")))
+	for {
+		name, err := bot.askText(ctx, user_id, question)
+		if err != nil || name == skip_answer {
+			return "", nil, err
+		}
+		if fields, ok := config.Templates[name]; ok {
+			return name, fields, nil
+		}
+		bot.sendPrivateMessage(user_id, fmt.Sprintf(TemplateNotFound, escapeMarkdown(name)), false)
+	}
+}
+
+// askFields asks the values of the event's template fields, in order.
+func (bot *Bot) askFields(ctx context.Context, user_id json.Number, fields []string) ([]string, error) {
+	values := make([]string, 0, len(fields))
+	for _, field := range fields {
+		value, err := bot.askText(ctx, user_id, fmt.Sprintf(RegisterAskField, escapeMarkdown(field)))
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, nil
+}
+
+// replaceTemplate returns a copy of templates with name set to fields, or
+// removed if fields is nil. The map is never changed in place because copies
+// returned by getChatConfig share it.
+func replaceTemplate(templates map[string][]string, name string, fields []string) map[string][]string {
+	copied := map[string][]string{}
+	for k, v := range templates {
+		if k != name {
+			copied[k] = v
+		}
+	}
+	if fields != nil {
+		copied[name] = fields
+	}
+	return copied
+}
+
+// saveTemplate is "/template <name> <field>, <field>..." and
+// "/template <name> -" to delete it.
+func (bot *Bot) saveTemplate(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	if len(args) < 2 {
+		bot.sendReply(chat_id, thread_id, message_id, TemplateUsage)
+		return
+	}
+	name := args[0]
+	if args[1] == skip_answer && len(args) == 2 {
+		found := false
+		bot.updateChatConfig(chat_id, func(config *ChatConfig) {
+			_, found = config.Templates[name]
+			config.Templates = replaceTemplate(config.Templates, name, nil)
+		})
+		text := fmt.Sprintf(TemplateDeleted, escapeMarkdown(name))
+		if !found {
+			text = fmt.Sprintf(TemplateNotFound, escapeMarkdown(name))
+		}
+		bot.sendReply(chat_id, thread_id, message_id, text)
+		return
+	}
+
+	fields := parseTemplateFields(strings.Join(args[1:], " "))
+	if len(fields) == 0 || len(fields) > max_template_fields {
+		bot.sendReply(chat_id, thread_id, message_id, TemplateUsage)
+		return
+	}
+	bot.updateChatConfig(chat_id, func(config *ChatConfig) {
+		config.Templates = replaceTemplate(config.Templates, name, fields)
+	})
+	bot.sendReply(chat_id, thread_id, message_id,
+		fmt.Sprintf(TemplateSaved, escapeMarkdown(name), escapeMarkdown(strings.Join(fields, ", "))))
+}
+
+func (bot *Bot) listTemplates(ctx context.Context, message JsonTable, args []string) {
+	config := bot.getChatConfig(getChatId(message))
+	lines := []string{TemplatesEmpty}
+	if names := config.templateNames(); len(names) > 0 {
+		lines = []string{TemplatesHeader}
+		for _, name := range names {
+			lines = append(lines, fmt.Sprintf(TemplateLine,
+				escapeMarkdown(name), escapeMarkdown(strings.Join(config.Templates[name], ", "))))
+		}
+	}
+	bot.sendReply(getChatId(message), getThreadId(message), getNum(message, "message_id"), strings.Join(lines, "\n"))
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const (
+	EnableUsage     = "Использование: /enable <команда>"
+	DisableUsage    = "Использование: /disable <команда>"
+	DisabledHeader  = "Отключены в этом канале: %s"
+	ToggleUnknown   = "Нет команды %s."
+	ToggleCore      = "Команду %s нельзя отключить."
+	DisableReport   = "Команда %s отключена в этом канале."
+	EnableReport    = "Команда %s снова работает в этом канале."
+	CommandDisabled = "Команда %s отключена в этом канале."
+)
+
+// isDisabled reports whether the chat admins turned the command off.
+func (bot *Bot) isDisabled(chat_id json.Number, name string) bool {
+	return bot.getChatConfig(chat_id).DisabledCommands[name]
+}
+
+// canDisable is whether a chat may turn the command off: not the core ones
+// and not the operator commands, which aren't about the chat.
+func canDisable(command Command) bool {
+	return !command.Core && command.Chat != ChatPrivate && command.Access != AccessBotAdmin
+}
+
+func replaceDisabled(disabled map[string]bool, name string, off bool) map[string]bool {
+	copied := map[string]bool{}
+	for k := range disabled {
+		if k != name {
+			copied[k] = true
+		}
+	}
+	if off {
+		copied[name] = true
+	}
+	if len(copied) == 0 {
+		return nil
+	}
+	return copied
+}
+
+func (c ChatConfig) disabledNames() []string {
+	names := make([]string, 0, len(c.DisabledCommands))
+	for name := range c.DisabledCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (bot *Bot) enableCommand(ctx context.Context, message JsonTable, args []string) {
+	bot.toggleCommand(message, args, false)
+}
+
+func (bot *Bot) disableCommand(ctx context.Context, message JsonTable, args []string) {
+	bot.toggleCommand(message, args, true)
+}
+
+func (bot *Bot) toggleCommand(message JsonTable, args []string, off bool) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	if len(args) != 1 {
+		usage := EnableUsage
+		if off {
+			usage = DisableUsage
+		}
+		if names := bot.getChatConfig(chat_id).disabledNames(); len(names) > 0 {
+			usage += "\n" + fmt.Sprintf(DisabledHeader, escapeMarkdown(strings.Join(names, ", ")))
+		}
+		bot.sendReply(chat_id, thread_id, message_id, usage)
+		return
+	}
+	name := resolveAlias("/" + strings.ToLower(strings.TrimPrefix(args[0], "/")))
+	command, ok := bot.commands[name]
+	switch {
+	case !ok:
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(ToggleUnknown, escapeMarkdown(name)))
+		return
+	case !canDisable(command):
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(ToggleCore, escapeMarkdown(name)))
+		return
+	}
+
+	bot.updateChatConfig(chat_id, func(config *ChatConfig) {
+		config.DisabledCommands = replaceDisabled(config.DisabledCommands, name, off)
+	})
+	bot.messageLogger(message).Info("command toggled", "command", name, "disabled", off)
+	bot.setupChatMenus(chat_id)
+
+	report := EnableReport
+	if off {
+		report = DisableReport
+	}
+	bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(report, escapeMarkdown(name)))
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	TokenLink       = "Ссылка на событие #%s, её можно превратить в QR-код для афиши:\nhttps://t.me/%s?start=%s"
+	TokenUsage      = "Использование: /token <код события>"
+	TokenInvalid    = "Неверный код события."
+	TokenUnknown    = "Событие с таким кодом не найдено."
+	TokenExpired    = "Событие #%s уже закрыто."
+	TokenRegister   = "Чтобы зарегистрироваться, отправьте /register в канале события."
+	TokenFreeSlots  = "Свободных мест: %s из %s"
+	TokenPostButton = "Зарегистрироваться"
+	token_alphabet  = "abcdefghijklmnopqrstuvwxyz234567"
+)
+
+// base32 without padding, so tokens fit the t.me start parameter
+var token_encoding = base32.NewEncoding(token_alphabet).WithPadding(base32.NoPadding)
+
+var token_re = regexp.MustCompile(`^[a-z2-7]{16}$`)
+
+// eventToken is the event's stable token for links and QR codes, made up
+// the first time it is asked for. Must be called with state_mux held.
+func (bot *Bot) eventToken(event *EventInfo) string {
+	if event.Token == "" {
+		b := make([]byte, 10)
+		rand.Read(b)
+		event.Token = token_encoding.EncodeToString(b)
+		bot.saveState()
+	}
+	return event.Token
+}
+
+// findToken returns the event with the token and whether it is still
+// active. Must be called with state_mux held.
+func (bot *Bot) findToken(token string) (*EventInfo, bool) {
+	for _, event := range bot.current_events {
+		if event.Token == token {
+			return event, true
+		}
+	}
+	for _, events := range bot.chat_history {
+		for _, event := range events {
+			if event.Token == token {
+				return event, false
+			}
+		}
+	}
+	return nil, false
+}
+
+// writePublicEvent is the event as anyone with its link may see it: the
+// details and the places left, not who registered.
+func writePublicEvent(b *TextBuilder, event *EventInfo) {
+	b.Bold(fmt.Sprintf(EventShowHeader, event.ref())).Text("\n")
+	writeEventDetails(b, event)
+	writeEventInfo(b, event)
+	if event.Capacity > 0 {
+		locale := localeOf(event.Locale)
+		free := max(event.Capacity-event.takenSlots(), 0)
+		b.Text("\n\n" + fmt.Sprintf(TokenFreeSlots, locale.Number(free), locale.Number(event.Capacity)))
+	}
+}
+
+// eventByToken answers /token <token> and t.me/<bot>?start=<token> links:
+// the event with how to register for it. The links end up on posters, so
+// the members aren't listed. In a group without arguments it gives the
+// link to the active event instead.
+func (bot *Bot) eventByToken(ctx context.Context, message JsonTable, args []string) {
+	if len(args) == 0 {
+		if isPrivateChat(message) {
+			bot.respond(ctx, message, TokenUsage)
+			return
+		}
+		bot.state_mux.Lock()
+		event, ok := bot.current_events[EventKey{getChatId(message), getThreadId(message)}]
+		var token string
+		if ok {
+			token = bot.eventToken(event)
+		}
+		bot.state_mux.Unlock()
+		if !ok {
+			bot.respond(ctx, message, NoActiveEventMsg)
+			return
+		}
+		bot.respondRaw(ctx, message, fmt.Sprintf(TokenLink, event.ref(), bot.Name, token))
+		return
+	}
+
+	token := strings.ToLower(args[0])
+	if !token_re.MatchString(token) {
+		bot.respond(ctx, message, TokenInvalid)
+		return
+	}
+	b := &TextBuilder{}
+	content := JsonTable{}
+	bot.state_mux.Lock()
+	event, active := bot.findToken(token)
+	if active {
+		writePublicEvent(b, event)
+		b.Text("\n\n" + TokenRegister)
+		// a link button, the registration itself happens in the chat
+		if base, ok := chatLink(JsonTable{"id": event.ChatId}); ok && event.PostMessageId != "" {
+			content["reply_markup"] = JsonTable{"inline_keyboard": [][]JsonTable{{
+				{"text": TokenPostButton, "url": base + "/" + event.PostMessageId.String()},
+			}}}
+		}
+	}
+	bot.state_mux.Unlock()
+
+	switch {
+	case event == nil:
+		bot.respond(ctx, message, TokenUnknown)
+	case !active:
+		bot.respond(ctx, message, fmt.Sprintf(TokenExpired, event.ref()))
+	default:
+		content["text"], content["entities"] = b.Build()
+		bot.deliver(ctx, message, content)
+	}
+}
+
+// start handles the bot being started, with a token when it was through
+// an event link.
+func (bot *Bot) start(ctx context.Context, message JsonTable, args []string) {
+	if len(args) > 0 {
+		bot.eventByToken(ctx, message, args)
+		return
+	}
+	bot.help(ctx, message, args)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// An event link is for posters: it shows the event and the places left,
+// never who registered.
+func TestEventByTokenHidesMembers(t *testing.T) {
+	t.Parallel()
+	bot, api := newTestBot(t)
+	event := &EventInfo{EventId: 1, ChatId: "-1001234", Description: "Тренировка", Location: "Автодром",
+		Capacity: 10, Token: "abcdefghijklmnop", PostMessageId: "77"}
+	event.Registrations = []MemberRecord{{Seq: 1, Name: "Иван Петров", License: "RU-123", UserId: "5"}}
+	event.Waitlist = []MemberRecord{{Seq: 2, Name: "Пётр Иванов", License: "RU-456"}}
+	bot.state_mux.Lock()
+	bot.current_events[EventKey{ChatId: event.ChatId}] = event
+	bot.state_mux.Unlock()
+
+	message := JsonTable{"message_id": json.Number("3"), "text": "/start " + event.Token,
+		"chat": JsonTable{"id": json.Number("2"), "type": "private"}, "from": JsonTable{"id": json.Number("2")}}
+	bot.start(context.Background(), message, []string{event.Token})
+
+	sent := api.sent("sendMessage")
+	if len(sent) != 1 {
+		t.Fatalf("sent %v, want the event", sent)
+	}
+	text := getStr(sent[0], "text")
+	for _, want := range []string{"Тренировка", "Автодром", "Свободных мест: 9 из 10", TokenRegister} {
+		if !strings.Contains(text, want) {
+			t.Errorf("%q doesn't show %q", text, want)
+		}
+	}
+	for _, secret := range []string{"Иван Петров", "RU-123", "Пётр Иванов", "RU-456"} {
+		if strings.Contains(text, secret) {
+			t.Errorf("%q shows %q", text, secret)
+		}
+	}
+	rows, _ := getTbl(sent[0], "reply_markup")["inline_keyboard"].(JsonArray)
+	if len(rows) != 1 || !strings.Contains(fmt.Sprint(rows[0]), "url:https://t.me/c/1234/77") {
+		t.Errorf("keyboard %v, want a button to the event post", rows)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"encoding/json"
+)
+
+// sendTyping shows "typing…" in the chat until the bot's next message, or for
+// five seconds at most. Best effort, a failure only costs the indicator.
+func (bot *Bot) sendTyping(chat_id json.Number, thread_id json.Number) {
+	if !bot.send_typing {
+		return
+	}
+	request := JsonTable{"chat_id": chat_id, "action": "typing"}
+	if thread_id != "" {
+		request["message_thread_id"] = thread_id
+	}
+	if _, err := bot.apiCall("sendChatAction", request); err != nil {
+		bot.log.Debug("failed to send typing", "chat_id", chat_id, "error", err)
+	}
+}
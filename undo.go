@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long /unregister can be undone. The member keeps their slot or waitlist
+// position meanwhile.
+const undo_window = time.Minute
+
+const (
+	UndoButton      = "Вернуть регистрацию"
+	UndoExpired     = "Время на отмену истекло."
+	UndoNotYours    = "Это не ваша регистрация."
+	UndoRestoredMsg = "%s снова участвует в событии #%s."
+
+	undo_callback = "undo"
+)
+
+// offerUndo replies to the /unregister with a button restoring the
+// registration, removed once the window has passed.
+func (bot *Bot) offerUndo(message JsonTable, event *EventInfo, seq int, text string) {
+	request := replyRequest(getChatId(message), getThreadId(message), getNum(message, "message_id"))
+	request["text"] = text
+	request["parse_mode"] = "Markdown"
+	data := fmt.Sprintf("%s:%s:%d:%d", undo_callback, event.ChatId, event.EventId, seq)
+	request["reply_markup"] = inlineKeyboard([]InlineButton{{UndoButton, data}})
+	resp, err := bot.postReply(request)
+
+	time.AfterFunc(undo_window, func() {
+		bot.purgeUnregistered(time.Now())
+		if err == nil {
+			bot.removeKeyboard(getChatId(message), getNum(asTbl(resp), "message_id"))
+		}
+	})
+}
+
+// purgeUnregistered drops the members whose undo window has passed and offers
+// their slots to the waitlist.
+func (bot *Bot) purgeUnregistered(now time.Time) {
+	var promoted []promotion
+	notices := map[*EventInfo]string{}
+
+	bot.state_mux.Lock()
+	changed := false
+	for _, event := range bot.current_events {
+		var expired []int
+		for _, list := range [][]MemberRecord{event.Registrations, event.Waitlist} {
+			for _, member := range list {
+				if !member.UndoDeadline.IsZero() && !now.Before(member.UndoDeadline) {
+					expired = append(expired, member.Seq)
+				}
+			}
+		}
+		for _, seq := range expired {
+			if _, registered, _ := event.removeSeq(seq); registered {
+				promoted = append(promoted, promoteNext(event, "")...)
+			}
+			changed = true
+		}
+		if notice := bot.capacityNotice(event); notice != "" {
+			notices[event] = notice
+		}
+	}
+	if changed {
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+
+	bot.notifyPromotions(promoted)
+	for event, notice := range notices {
+		bot.announceCapacity(event, notice)
+	}
+}
+
+func (e *EventInfo) hasSeq(seq int) bool {
+	for _, list := range [][]MemberRecord{e.Registrations, e.Waitlist} {
+		for _, member := range list {
+			if member.Seq == seq {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// processUndoCallback handles the undo button of offerUndo, only for the
+// member who unregistered.
+func (bot *Bot) processUndoCallback(callback JsonTable) bool {
+	parts := strings.Split(getStr(callback, "data"), ":")
+	if len(parts) != 4 || parts[0] != undo_callback {
+		return false
+	}
+	chat_id := json.Number(parts[1])
+	event_id, _ := strconv.Atoi(parts[2])
+	seq, _ := strconv.Atoi(parts[3])
+	user_id := getNum(getTbl(callback, "from"), "id")
+
+	bot.state_mux.Lock()
+	var record *MemberRecord
+	event := bot.findChatEvent(chat_id, event_id)
+	if event != nil {
+		record = event.memberRecord(user_id)
+	}
+	problem, name, ref := "", "", ""
+	switch {
+	case event == nil || !event.hasSeq(seq):
+		problem = UndoExpired
+	case record == nil || record.Seq != seq:
+		problem = UndoNotYours
+	case record.UndoDeadline.IsZero():
+		problem = UndoExpired
+	default:
+		record.UndoDeadline = time.Time{}
+		event.audit(nil, audit_restored, record.Name)
+		name, ref = record.Name, event.ref()
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+
+	bot.answerCallback(getStr(callback, "id"), problem)
+	if problem != "" {
+		return true
+	}
+	message := getTbl(callback, "message")
+	bot.apiCall("editMessageText", JsonTable{
+		"chat_id":    getChatId(message),
+		"message_id": getNum(message, "message_id"),
+		"text":       fmt.Sprintf(UndoRestoredMsg, escapeMarkdown(name), ref),
+		"parse_mode": "Markdown",
+	})
+	return true
+}
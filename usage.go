@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+const (
+	UsageHeader     = "Использование команд (%s):"
+	UsageAllChats   = "все чаты"
+	UsageLine       = "%s — вызовов: %d, отказано: %d, ошибок: %d"
+	UsageChatHeader = "чат `%s`"
+)
+
+// UsageCounter counts invocations of a command. Denied ones didn't pass
+// authorization, failed ones panicked.
+type UsageCounter struct {
+	Calls  int
+	Denied int `json:",omitempty"`
+	Failed int `json:",omitempty"`
+}
+
+func (bot *Bot) recordUsage(message JsonTable, update func(counter *UsageCounter)) {
+	name, _, ok := bot.commandName(message)
+	if !ok {
+		return
+	}
+	chat_id := getChatId(message)
+
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+	chats, ok := bot.command_usage[name]
+	if !ok {
+		chats = map[json.Number]*UsageCounter{}
+		bot.command_usage[name] = chats
+	}
+	counter, ok := chats[chat_id]
+	if !ok {
+		counter = &UsageCounter{}
+		chats[chat_id] = counter
+	}
+	update(counter)
+}
+
+func (bot *Bot) countUsage(next CommandHandler) CommandHandler {
+	return func(ctx context.Context, message JsonTable, args []string) {
+		bot.recordUsage(message, func(c *UsageCounter) { c.Calls++ })
+		next(ctx, message, args)
+	}
+}
+
+func (bot *Bot) countDenied(message JsonTable) {
+	bot.recordUsage(message, func(c *UsageCounter) { c.Denied++ })
+}
+
+func (bot *Bot) countFailed(message JsonTable) {
+	bot.recordUsage(message, func(c *UsageCounter) { c.Failed++ })
+}
+
+// renderUsage lists the commands by number of calls, for one chat if arg is
+// a chat id.
+func (bot *Bot) renderUsage(user_id json.Number, arg string) (string, []string) {
+	bot.state_mux.Lock()
+	defer bot.state_mux.Unlock()
+
+	totals := map[string]UsageCounter{}
+	for name, chats := range bot.command_usage {
+		for chat_id, counter := range chats {
+			if arg != "" && chat_id != json.Number(arg) {
+				continue
+			}
+			total := totals[name]
+			total.Calls += counter.Calls
+			total.Denied += counter.Denied
+			total.Failed += counter.Failed
+			totals[name] = total
+		}
+	}
+	names := make([]string, 0, len(totals))
+	for name := range totals {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if totals[names[i]].Calls != totals[names[j]].Calls {
+			return totals[names[i]].Calls > totals[names[j]].Calls
+		}
+		return names[i] < names[j]
+	})
+
+	var lines []string
+	for _, name := range names {
+		t := totals[name]
+		lines = append(lines, fmt.Sprintf(UsageLine, escapeMarkdown(name), t.Calls, t.Denied, t.Failed))
+	}
+	scope := UsageAllChats
+	if arg != "" {
+		scope = fmt.Sprintf(UsageChatHeader, arg)
+	}
+	return fmt.Sprintf(UsageHeader, scope), lines
+}
+
+// showUsage sends the command statistics, "/usage <chat_id>" for one chat.
+func (bot *Bot) showUsage(ctx context.Context, message JsonTable, args []string) {
+	arg := ""
+	if len(args) > 0 {
+		arg = args[0]
+	}
+	// chat admins only see their own chat
+	if !bot.isObserver(getSenderId(message)) {
+		arg = string(getChatId(message))
+	}
+	bot.sendPaged(getChatId(message), getSenderId(message), "usage", arg)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// what Telegram accepts in command menus
+var command_name_re = regexp.MustCompile(`^/[a-z0-9_]{1,32}$`)
+
+// handlerName is the function a handler is, like main.(*Bot).register. The
+// -fm suffix of method values is dropped.
+func handlerName(handler CommandHandler) string {
+	return strings.TrimSuffix(runtime.FuncForPC(reflect.ValueOf(handler).Pointer()).Name(), "-fm")
+}
+
+// validateCommands checks the command map and the localized aliases for
+// wiring mistakes and logs what each command runs. A handler shared by two commands or named after another
+// command, like /unregister running register, is taken for a copy-paste slip.
+func validateCommands(registry map[string]Command) error {
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []string
+	used_by := map[string]string{}
+	for _, name := range names {
+		command := registry[name]
+		if !command_name_re.MatchString(name) {
+			problems = append(problems, fmt.Sprintf("%s: invalid command name", name))
+		}
+		if command.Handler == nil {
+			problems = append(problems, fmt.Sprintf("%s: no handler", name))
+			continue
+		}
+		handler := handlerName(command.Handler)
+		slog.Debug("command", "name", name, "handler", handler)
+
+		if other, ok := used_by[handler]; ok {
+			problems = append(problems, fmt.Sprintf("%s: runs %s like %s", name, handler, other))
+		}
+		used_by[handler] = name
+
+		short := strings.ToLower(handler[strings.LastIndex(handler, ".")+1:])
+		if other := "/" + short; other != name && hasCommand(registry, other) {
+			problems = append(problems, fmt.Sprintf("%s: runs %s, the handler of %s", name, handler, other))
+		}
+	}
+	for locale_name, locale := range locales {
+		for alias, target := range locale.Commands {
+			if !hasCommand(registry, target) {
+				problems = append(problems, fmt.Sprintf("%s alias %s: no command %s", locale_name, alias, target))
+			}
+			if hasCommand(registry, alias) {
+				problems = append(problems, fmt.Sprintf("%s alias %s: shadows a command", locale_name, alias))
+			}
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func hasCommand(registry map[string]Command, name string) bool {
+	_, ok := registry[name]
+	return ok
+}
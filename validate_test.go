@@ -0,0 +1,26 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateCommands(t *testing.T) {
+	bot, _ := newTestBot(t)
+	if err := validateCommands(bot.commands); err != nil {
+		t.Fatalf("builtin commands rejected: %v", err)
+	}
+
+	// each handler used once, but /history runs show and /show runs history
+	registry := bot.builtinCommands()
+	history, show := registry["/history"], registry["/show"]
+	history.Handler, show.Handler = show.Handler, history.Handler
+	registry["/history"], registry["/show"] = history, show
+	err := validateCommands(registry)
+	if err == nil {
+		t.Fatal("swapped /history and /show handlers accepted")
+	}
+	if want := ".(*Bot).history, the handler of /history"; !strings.Contains(err.Error(), "/show: runs ") || !strings.Contains(err.Error(), want) {
+		t.Errorf("error %q, want /show running %q", err, want)
+	}
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Set at build time:
+//
+//	go build -ldflags "-X main.version=1.2.0 -X main.commit=$(git rev-parse --short HEAD) -X main.build_date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version    = "dev"
+	commit     = ""
+	build_date = "unknown"
+)
+
+func versionString() string {
+	rev := commit
+	if rev == "" {
+		rev = "unknown"
+		if info, ok := debug.ReadBuildInfo(); ok {
+			for _, setting := range info.Settings {
+				if setting.Key == "vcs.revision" && len(setting.Value) >= 7 {
+					rev = setting.Value[:7]
+				}
+			}
+		}
+	}
+	return fmt.Sprintf("%s (%s, %s, %s)", version, rev, build_date, runtime.Version())
+}
+
+func (bot *Bot) versionCmd(ctx context.Context, message JsonTable, args []string) {
+	bot.sendReply(getChatId(message), getThreadId(message), getNum(message, "message_id"), "`"+versionString()+"`")
+}
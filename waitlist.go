@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	claim_timeout        = 2 * time.Hour
+	claim_check_interval = time.Minute
+)
+
+const (
+	PromotionDM       = "Освободилось место на событие #%s!\n\n%s\n\nПодтвердите участие командой /confirm до %s, иначе место перейдёт следующему в листе ожидания."
+	ClaimConfirmedMsg = "Участие в событии #%s подтверждено."
+	ClaimExpiredDM    = "Вы не подтвердили участие в событии #%s вовремя и возвращены в лист ожидания."
+	NoPendingClaimMsg = "У вас нет мест, ожидающих подтверждения."
+	BumpUsage         = "Использование: /bump <номер из листа ожидания> [приоритет]"
+	BumpNotWaiting    = "В листе ожидания нет участника с номером %d."
+	NotAdmittedDM     = "Событие #%s началось, места для вас не освободилось. Лист ожидания закрыт."
+	BumpReport        = "Участник №%d %s теперь %d-й в листе ожидания (приоритет %d)."
+)
+
+// slots is how much of the capacity the registration takes.
+func (m MemberRecord) slots() int {
+	return max(m.Slots, 1)
+}
+
+func (e *EventInfo) takenSlots() int {
+	taken := 0
+	for _, member := range e.Registrations {
+		taken += member.slots()
+	}
+	return taken
+}
+
+func (e *EventInfo) isFull() bool {
+	return e.Capacity > 0 && e.takenSlots() >= e.Capacity
+}
+
+// fits reports whether there are enough free slots for the member.
+func (e *EventInfo) fits(member MemberRecord) bool {
+	return e.Capacity == 0 || e.takenSlots()+member.slots() <= e.Capacity
+}
+
+func (e *EventInfo) findWaiting(user_id json.Number) int {
+	for i, member := range e.Waitlist {
+		if member.UserId == user_id {
+			return i
+		}
+	}
+	return -1
+}
+
+// findWaitingSeq is findWaiting by the member's number, which imported
+// members without a user id have too.
+func (e *EventInfo) findWaitingSeq(seq int) int {
+	for i, member := range e.Waitlist {
+		if member.Seq == seq {
+			return i
+		}
+	}
+	return -1
+}
+
+// addToWaitlist queues the member behind everyone with the same or a higher
+// priority and returns their position. Must be called with state_mux held.
+func (e *EventInfo) addToWaitlist(member MemberRecord) int {
+	e.Waitlist = append(e.Waitlist, member)
+	e.sortWaitlist()
+	return e.findWaitingSeq(member.Seq) + 1
+}
+
+func (e *EventInfo) sortWaitlist() {
+	sort.SliceStable(e.Waitlist, func(i, j int) bool {
+		return e.Waitlist[i].Priority > e.Waitlist[j].Priority
+	})
+}
+
+type promotion struct {
+	event  *EventInfo
+	member MemberRecord
+}
+
+// promoteNext moves the head of the waitlist into free slots as long as they
+// fit, giving them claim_timeout to confirm. Nobody skips ahead of a head
+// that needs more slots than are free. The member identified by skip_id is
+// passed over, so someone who just let their claim expire stays in the
+// waitlist and the slot goes to the next in line. Must be called with
+// state_mux held.
+func promoteNext(event *EventInfo, skip_id json.Number) []promotion {
+	var promoted []promotion
+	for {
+		i := 0
+		if i < len(event.Waitlist) && skip_id != "" && event.Waitlist[i].UserId == skip_id {
+			i++
+		}
+		if i == len(event.Waitlist) || !event.fits(event.Waitlist[i]) {
+			break
+		}
+		member := event.Waitlist[i]
+		event.Waitlist = append(event.Waitlist[:i], event.Waitlist[i+1:]...)
+		if member.UserId != "" {
+			// imported members can't confirm, their slot is simply theirs
+			member.ClaimDeadline = time.Now().Add(claim_timeout)
+		}
+		event.Registrations = append(event.Registrations, member)
+		event.audit(nil, audit_promoted, member.Name)
+		promoted = append(promoted, promotion{event, member})
+	}
+	return promoted
+}
+
+func (bot *Bot) notifyPromotions(promoted []promotion) {
+	for _, p := range promoted {
+		if p.member.UserId == "" {
+			continue
+		}
+		text := fmt.Sprintf(PromotionDM, p.event.ref(), formatEventDetails(p.event),
+			p.member.ClaimDeadline.Format(localeOf(p.event.Locale).TimeLayout))
+		bot.queueMessage(JsonTable{"chat_id": p.member.UserId, "text": text, "parse_mode": "Markdown"}, nil)
+	}
+}
+
+func (bot *Bot) confirmClaim(ctx context.Context, message JsonTable, args []string) {
+	user_id := getSenderId(message)
+
+	var confirmed []string
+	bot.state_mux.Lock()
+	for _, event := range bot.current_events {
+		for i := range event.Registrations {
+			member := &event.Registrations[i]
+			if member.UserId == user_id && !member.ClaimDeadline.IsZero() {
+				member.ClaimDeadline = time.Time{}
+				event.audit(message, audit_confirmed, member.Name)
+				confirmed = append(confirmed, event.ref())
+			}
+		}
+	}
+	if len(confirmed) > 0 {
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+
+	if len(confirmed) == 0 {
+		bot.sendPrivateMessage(user_id, NoPendingClaimMsg, false)
+		return
+	}
+	for _, ref := range confirmed {
+		bot.sendPrivateMessage(user_id, fmt.Sprintf(ClaimConfirmedMsg, ref), false)
+	}
+}
+
+// expireClaims moves members who didn't confirm a promotion in time back to
+// the end of the waitlist and offers their slot to the next person.
+func (bot *Bot) expireClaims(now time.Time) {
+	type expiry struct {
+		event_id int
+		ref      string
+		user_id  json.Number
+	}
+	var expired []expiry
+	var promoted []promotion
+	notices := map[*EventInfo]string{}
+
+	bot.state_mux.Lock()
+	for _, event := range bot.current_events {
+		for i := 0; i < len(event.Registrations); {
+			member := event.Registrations[i]
+			if member.ClaimDeadline.IsZero() || now.Before(member.ClaimDeadline) {
+				i++
+				continue
+			}
+			event.Registrations = append(event.Registrations[:i], event.Registrations[i+1:]...)
+			member.ClaimDeadline = time.Time{}
+			event.addToWaitlist(member)
+			event.audit(nil, audit_expired, member.Name)
+			expired = append(expired, expiry{event.EventId, event.ref(), member.UserId})
+			promoted = append(promoted, promoteNext(event, member.UserId)...)
+		}
+		if notice := bot.capacityNotice(event); notice != "" {
+			notices[event] = notice
+		}
+	}
+	if len(expired) > 0 {
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+
+	for _, e := range expired {
+		bot.log.Info("claim expired", "user_id", e.user_id, "event_id", e.event_id)
+		bot.sendNotification(e.user_id, fmt.Sprintf(ClaimExpiredDM, e.ref))
+	}
+	bot.notifyPromotions(promoted)
+	for event, notice := range notices {
+		bot.announceCapacity(event, notice)
+	}
+}
+
+// expireWaitlists drops the waitlist of events that have started, in chats
+// that asked for it, and tells the members they weren't admitted. The event
+// remembers it, so the members aren't told again after a restart.
+func (bot *Bot) expireWaitlists(now time.Time) {
+	var dropped []promotion
+	changed := false
+	bot.state_mux.Lock()
+	for _, event := range bot.current_events {
+		if event.WaitlistExpired || event.StartTime.IsZero() || now.Before(event.StartTime) {
+			continue
+		}
+		if config, ok := bot.chat_configs[event.ChatId]; !ok || !config.ExpireWaitlist {
+			continue
+		}
+		event.WaitlistExpired = true
+		changed = true
+		if len(event.Waitlist) == 0 {
+			continue
+		}
+		for _, member := range event.Waitlist {
+			dropped = append(dropped, promotion{event, member})
+		}
+		event.audit(nil, audit_not_admitted, strconv.Itoa(len(event.Waitlist)))
+		event.Waitlist = nil
+	}
+	if changed {
+		bot.saveState()
+	}
+	bot.state_mux.Unlock()
+
+	for _, d := range dropped {
+		bot.log.Info("waitlist expired", "user_id", d.member.UserId, "event_id", d.event.EventId)
+		bot.sendNotification(d.member.UserId, fmt.Sprintf(NotAdmittedDM, d.event.ref()))
+	}
+}
+
+func (bot *Bot) claimWatcher() {
+	for now := range time.Tick(claim_check_interval) {
+		bot.expireClaims(now)
+		// after a restart lost the undo timers
+		bot.purgeUnregistered(now)
+	}
+}
+
+// bumpWaitlisted raises the priority of a waitlisted member, by default just
+// above everyone else, so they are promoted first.
+func (bot *Bot) bumpWaitlisted(ctx context.Context, message JsonTable, args []string) {
+	chat_id := getChatId(message)
+	thread_id := getThreadId(message)
+	message_id := getNum(message, "message_id")
+
+	if len(args) < 1 || len(args) > 2 {
+		bot.sendReply(chat_id, thread_id, message_id, BumpUsage)
+		return
+	}
+	seq, err := strconv.Atoi(args[0])
+	priority := -1
+	if err == nil && len(args) == 2 {
+		if priority, err = strconv.Atoi(args[1]); priority < 0 {
+			err = strconv.ErrRange
+		}
+	}
+	if err != nil {
+		bot.sendReply(chat_id, thread_id, message_id, BumpUsage)
+		return
+	}
+
+	bot.state_mux.Lock()
+	event, ok := bot.current_events[EventKey{chat_id, thread_id}]
+	if !ok {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, NoActiveEventMsg)
+		return
+	}
+	i := event.findWaitingSeq(seq)
+	if i == -1 {
+		bot.state_mux.Unlock()
+		bot.sendReply(chat_id, thread_id, message_id, fmt.Sprintf(BumpNotWaiting, seq))
+		return
+	}
+	if priority == -1 {
+		for _, member := range event.Waitlist {
+			priority = max(priority, member.Priority+1)
+		}
+	}
+	member := event.Waitlist[i]
+	event.Waitlist = append(event.Waitlist[:i], event.Waitlist[i+1:]...)
+	member.Priority = priority
+	position := event.addToWaitlist(member)
+	event.audit(message, audit_bumped, fmt.Sprintf("%s: %d", member.Name, priority))
+	bot.saveState()
+	bot.state_mux.Unlock()
+
+	bot.sendReply(chat_id, thread_id, message_id,
+		fmt.Sprintf(BumpReport, seq, escapeMarkdown(member.Name), position, priority))
+}
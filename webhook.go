@@ -0,0 +1,98 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+const default_webhook_addr = ":8443"
+
+// setupWebhook makes Telegram's webhook match the selected mode: set in
+// webhook mode, removed in polling mode where a stale one makes getUpdates
+// fail. Both calls are safe to repeat, so it runs on every start.
+func (bot *Bot) setupWebhook() error {
+	info, err := bot.apiCall("getWebhookInfo", JsonTable{})
+	if err != nil {
+		return err
+	}
+	if bot.webhook_url != "" {
+		request := JsonTable{"url": bot.webhook_url, "allowed_updates": allowed_updates}
+		if bot.webhook_secret != "" {
+			request["secret_token"] = bot.webhook_secret
+		}
+		if _, err = bot.apiCall("setWebhook", request); err != nil {
+			return err
+		}
+	} else if getStr(asTbl(info), "url") != "" {
+		// pending updates are kept, getUpdates delivers them
+		if _, err = bot.apiCall("deleteWebhook", JsonTable{}); err != nil {
+			return err
+		}
+	} else {
+		return nil
+	}
+
+	if info, err = bot.apiCall("getWebhookInfo", JsonTable{}); err != nil {
+		return err
+	}
+	tbl := asTbl(info)
+	bot.log.Info("webhook configured", "url", getStr(tbl, "url"),
+		"pending_updates", getNum(tbl, "pending_update_count"),
+		"last_error", getStr(tbl, "last_error_message"))
+	return nil
+}
+
+// webhookHandler receives updates pushed by Telegram. While shutting down it
+// refuses them, so Telegram redelivers them to the next instance.
+func (bot *Bot) webhookHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.Header.Get("X-Telegram-Bot-Api-Secret-Token")
+	if bot.webhook_secret != "" && subtle.ConstantTimeCompare([]byte(token), []byte(bot.webhook_secret)) != 1 {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	if bot.isShuttingDown() {
+		http.Error(w, "shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	var update JsonTable
+	decoder := json.NewDecoder(r.Body)
+	decoder.UseNumber()
+	if err := decoder.Decode(&update); err != nil {
+		bot.log.Warn("malformed webhook update", "error", err)
+		http.Error(w, "bad update", http.StatusBadRequest)
+		return
+	}
+
+	if !bot.acquireWorker(webhook_worker_wait) {
+		// Telegram redelivers it later
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+		return
+	}
+	bot.runUpdate(update)
+}
+
+func (bot *Bot) webhookAddr() string {
+	if bot.webhook_addr == "" {
+		return default_webhook_addr
+	}
+	return bot.webhook_addr
+}
+
+// serveWebhook receives updates on the path of webhook_url. TLS is expected to
+// be terminated by a reverse proxy in front of the bot.
+func (bot *Bot) serveWebhook() {
+	path := "/"
+	if u, err := url.Parse(bot.webhook_url); err == nil && u.Path != "" {
+		path = u.Path
+	}
+	addr := bot.webhookAddr()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+path, bot.webhookHandler)
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			bot.fatal("webhook server failed", "addr", addr, "error", err)
+		}
+	}()
+}
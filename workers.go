@@ -0,0 +1,96 @@
+package main
+
+import (
+	"sort"
+	"time"
+)
+
+const (
+	default_update_workers = 64
+	// how long an update waits for a free worker before the poll loop asks
+	// Telegram again, acknowledging the updates accepted so far
+	worker_wait = poll_timeout
+	// the same for a webhook update, which is refused and redelivered later
+	webhook_worker_wait = 2 * time.Second
+)
+
+// acquireWorker takes a worker for an update, waiting up to wait for one to
+// free up. False if none did or the bot is shutting down.
+func (bot *Bot) acquireWorker(wait time.Duration) bool {
+	select {
+	case bot.update_workers <- struct{}{}:
+		return true
+	default:
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case bot.update_workers <- struct{}{}:
+		return true
+	case <-timer.C:
+		return false
+	case <-bot.root_ctx.Done():
+		return false
+	}
+}
+
+func (bot *Bot) releaseWorker() {
+	<-bot.update_workers
+}
+
+// runUpdate handles update on the worker acquireWorker took for it.
+func (bot *Bot) runUpdate(update JsonTable) {
+	update_id := getInt(update, "update_id")
+	bot.in_flight_mux.Lock()
+	bot.in_flight[update_id] = time.Now()
+	bot.in_flight_mux.Unlock()
+
+	bot.handlers.Add(1)
+	go func() {
+		defer bot.handlers.Done()
+		defer bot.releaseWorker()
+		defer func() {
+			bot.in_flight_mux.Lock()
+			delete(bot.in_flight, update_id)
+			bot.in_flight_mux.Unlock()
+		}()
+		bot.handleMessage(update)
+	}()
+}
+
+// logStragglers logs the updates still being handled, oldest first.
+func (bot *Bot) logStragglers() {
+	bot.in_flight_mux.Lock()
+	defer bot.in_flight_mux.Unlock()
+	ids := make([]int64, 0, len(bot.in_flight))
+	for update_id := range bot.in_flight {
+		ids = append(ids, update_id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for _, update_id := range ids {
+		bot.log.Warn("update still being handled", "update_id", update_id, "running", time.Since(bot.in_flight[update_id]).Round(time.Millisecond))
+	}
+}
+
+// dispatchUpdates hands a getUpdates batch to the workers in order and
+// returns the offset past the last update accepted. If every worker stays
+// busy, the rest of the batch isn't acknowledged and comes again with the
+// next getUpdates, so nothing is dropped.
+func (bot *Bot) dispatchUpdates(updates []JsonTable, offset int64) int64 {
+	for i, update := range updates {
+		if bot.isShuttingDown() {
+			// not acknowledged, the next instance gets them again
+			return offset
+		}
+		if !bot.acquireWorker(worker_wait) {
+			if !bot.isShuttingDown() {
+				bot.log.Warn("all update workers busy, deferring updates", "workers", cap(bot.update_workers), "deferred", len(updates)-i)
+			}
+			return offset
+		}
+		offset = getInt(update, "update_id") + 1
+		bot.poll_offset.Store(offset)
+		bot.runUpdate(update)
+	}
+	return offset
+}